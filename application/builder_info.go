@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
@@ -19,11 +20,13 @@ type Fetcher interface {
 }
 type BuilderInfoService struct {
 	fetcher      Fetcher
+	mu           sync.RWMutex
 	builderInfos []BuilderInfo
+	lastFetched  time.Time
 }
 
 func StartBuilderInfoService(ctx context.Context, fetcher Fetcher, fetchInterval time.Duration) (*BuilderInfoService, error) {
-	bis := BuilderInfoService{
+	bis := &BuilderInfoService{
 		fetcher: fetcher,
 	}
 	if fetcher != nil {
@@ -34,13 +37,24 @@ func StartBuilderInfoService(ctx context.Context, fetcher Fetcher, fetchInterval
 		go bis.syncLoop(fetchInterval)
 
 	}
-	return &bis, nil
+	return bis, nil
 }
 func (bis *BuilderInfoService) Builders() []BuilderInfo {
+	bis.mu.RLock()
+	defer bis.mu.RUnlock()
 	return bis.builderInfos
 }
 
+// LastFetched returns the time of the last successful registry fetch, and false if no fetcher is configured
+func (bis *BuilderInfoService) LastFetched() (time.Time, bool) {
+	bis.mu.RLock()
+	defer bis.mu.RUnlock()
+	return bis.lastFetched, bis.fetcher != nil
+}
+
 func (bis *BuilderInfoService) BuilderNames() []string {
+	bis.mu.RLock()
+	defer bis.mu.RUnlock()
 	var names = make([]string, 0, len(bis.builderInfos))
 	for _, builderInfo := range bis.builderInfos {
 		names = append(names, strings.ToLower(builderInfo.Name))
@@ -71,6 +85,9 @@ func (bis *BuilderInfoService) fetchBuilderInfo(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	bis.mu.Lock()
+	defer bis.mu.Unlock()
 	bis.builderInfos = builderInfos
+	bis.lastFetched = time.Now()
 	return nil
 }
@@ -1,17 +1,22 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/flashbots/rpc-endpoint/database"
 	"github.com/flashbots/rpc-endpoint/metrics"
 	"github.com/flashbots/rpc-endpoint/server"
+	"github.com/jmoiron/sqlx"
 )
 
 var (
@@ -33,24 +38,59 @@ var (
 	defaultMempoolRPC               = os.Getenv("DEFAULT_MEMPOOL_RPC")
 	defaultMetricsAddr              = os.Getenv("METRICS_ADDR")
 	defaultCustomerConfigFile       = os.Getenv("CUSTOMER_CONFIG")
+	defaultChainsConfigFile         = os.Getenv("CHAINS_CONFIG")
+	defaultInclusionWindowMinutes   = 30
+	defaultMempoolDelaySeconds      = 12
+	defaultStateOptions             = server.DefaultStateOptions
 
 	// cli flags
-	versionPtr           = flag.Bool("version", false, "just print the program version")
-	listenAddress        = flag.String("listen", getEnvAsStrOrDefault("LISTEN_ADDR", defaultListenAddress), "Listen address")
-	drainAddress         = flag.String("drain", getEnvAsStrOrDefault("DRAIN_ADDR", defaultDrainAddress), "Drain address")
-	drainSeconds         = flag.Int("drainSeconds", getEnvAsIntOrDefault("DRAIN_SECONDS", defaultDrainSeconds), "seconds to wait for graceful shutdown")
-	fetchIntervalSeconds = flag.Int("fetchIntervalSeconds", getEnvAsIntOrDefault("FETCH_INFO_INTERVAL_SECONDS", defaultFetchInfoIntervalSeconds), "seconds between builder info fetches")
-	ttlCacheSeconds      = flag.Int("ttlCacheSeconds", getEnvAsIntOrDefault("TTL_CACHE_SECONDS", defaultRpcTTLCacheSeconds), "seconds to cache static requests")
-	builderInfoSource    = flag.String("builderInfoSource", getEnvAsStrOrDefault("BUILDER_INFO_SOURCE", ""), "URL for json source of actual builder info")
-	proxyUrl             = flag.String("proxy", getEnvAsStrOrDefault("PROXY_URL", defaultProxyUrl), "URL for default JSON-RPC proxy target (eth node, Infura, etc.)")
-	proxyTimeoutSeconds  = flag.Int("proxyTimeoutSeconds", getEnvAsIntOrDefault("PROXY_TIMEOUT_SECONDS", defaultProxyTimeoutSeconds), "proxy client timeout in seconds")
-	redisUrl             = flag.String("redis", getEnvAsStrOrDefault("REDIS_URL", defaultRedisUrl), "URL for Redis (use 'dev' to use integrated in-memory redis)")
-	relayUrl             = flag.String("relayUrl", getEnvAsStrOrDefault("RELAY_URL", defaultRelayUrl), "URL for relay")
-	relaySigningKey      = flag.String("signingKey", os.Getenv("RELAY_SIGNING_KEY"), "Signing key for relay requests")
-	psqlDsn              = flag.String("psql", os.Getenv("POSTGRES_DSN"), "Postgres DSN")
-	debugPtr             = flag.Bool("debug", defaultDebug, "print debug output")
-	logJSONPtr           = flag.Bool("logJSON", defaultLogJSON, "log in JSON")
-	serviceName          = flag.String("serviceName", defaultServiceName, "name of the service which will be used in the logs")
+	versionPtr             = flag.Bool("version", false, "just print the program version")
+	listenAddress          = flag.String("listen", getEnvAsStrOrDefault("LISTEN_ADDR", defaultListenAddress), "Listen address")
+	drainAddress           = flag.String("drain", getEnvAsStrOrDefault("DRAIN_ADDR", defaultDrainAddress), "Drain address")
+	adminAddress           = flag.String("admin", os.Getenv("ADMIN_ADDR"), "Admin API address (disabled if empty)")
+	adminToken             = flag.String("adminToken", os.Getenv("ADMIN_TOKEN"), "Bearer token required by the admin API")
+	adminTLSCert           = flag.String("adminTLSCert", os.Getenv("ADMIN_TLS_CERT"), "TLS certificate file of the admin API (plain HTTP if empty)")
+	adminTLSKey            = flag.String("adminTLSKey", os.Getenv("ADMIN_TLS_KEY"), "TLS key file of the admin API")
+	adminClientCA          = flag.String("adminClientCA", os.Getenv("ADMIN_CLIENT_CA"), "CA file of client certificates required by the admin API (mTLS)")
+	drainSeconds           = flag.Int("drainSeconds", getEnvAsIntOrDefault("DRAIN_SECONDS", defaultDrainSeconds), "seconds to wait for graceful shutdown")
+	fetchIntervalSeconds   = flag.Int("fetchIntervalSeconds", getEnvAsIntOrDefault("FETCH_INFO_INTERVAL_SECONDS", defaultFetchInfoIntervalSeconds), "seconds between builder info fetches")
+	ttlCacheSeconds        = flag.Int("ttlCacheSeconds", getEnvAsIntOrDefault("TTL_CACHE_SECONDS", defaultRpcTTLCacheSeconds), "seconds to cache static requests")
+	builderInfoSource      = flag.String("builderInfoSource", getEnvAsStrOrDefault("BUILDER_INFO_SOURCE", ""), "URL for json source of actual builder info")
+	proxyUrl               = flag.String("proxy", getEnvAsStrOrDefault("PROXY_URL", defaultProxyUrl), "URL for default JSON-RPC proxy target (eth node, Infura, etc.)")
+	proxyTimeoutSeconds    = flag.Int("proxyTimeoutSeconds", getEnvAsIntOrDefault("PROXY_TIMEOUT_SECONDS", defaultProxyTimeoutSeconds), "proxy client timeout in seconds")
+	requestTimeoutSeconds  = flag.Int("requestTimeoutSeconds", getEnvAsIntOrDefault("REQUEST_TIMEOUT_SECONDS", int(server.DefaultRequestTimeouts.Request.Seconds())), "seconds after which a request and its calls are cancelled")
+	relayTimeoutSeconds    = flag.Int("relayTimeoutSeconds", getEnvAsIntOrDefault("RELAY_TIMEOUT_SECONDS", int(server.DefaultRequestTimeouts.Relay.Seconds())), "relay call timeout in seconds")
+	txStatusTimeoutSeconds = flag.Int("txStatusTimeoutSeconds", getEnvAsIntOrDefault("TX_STATUS_TIMEOUT_SECONDS", int(server.DefaultRequestTimeouts.TxStatus.Seconds())), "tx status API call timeout in seconds")
+	tracingExporter        = flag.String("tracingExporter", getEnvAsStrOrDefault("TRACING_EXPORTER", ""), "OpenTelemetry trace exporter: otlp or stdout (disabled if empty)")
+	tracingEndpoint        = flag.String("tracingEndpoint", getEnvAsStrOrDefault("TRACING_ENDPOINT", ""), "host:port of the OTLP/HTTP collector (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)")
+	tracingSampleRatio     = flag.Float64("tracingSampleRatio", getEnvAsFloatOrDefault("TRACING_SAMPLE_RATIO", 1), "ratio of requests which are sampled")
+	auditSinkTarget        = flag.String("auditSink", getEnvAsStrOrDefault("AUDIT_SINK", ""), "sink of tx decision records: stdout, file:<path> or a webhook url (disabled if empty)")
+	redisUrl               = flag.String("redis", getEnvAsStrOrDefault("REDIS_URL", defaultRedisUrl), "Redis address or redis[s]:// URL, with ?mode=cluster or ?mode=sentinel&master=name for multiple hosts (use 'dev' to keep state in memory instead)")
+	redisNamespace         = flag.String("redisNamespace", os.Getenv("REDIS_NAMESPACE"), "namespace added to all Redis keys, e.g. mainnet, so multiple instances can share a Redis")
+	stateTxSeconds         = flag.Int("stateTxExpirySeconds", getEnvAsIntOrDefault("STATE_TX_EXPIRY_SECONDS", int(defaultStateOptions.TxSentToRelayExpiry.Seconds())), "seconds to keep the relay status, sender, nonce and block status of a tx")
+	stateNonceFixSeconds   = flag.Int("stateNonceFixExpirySeconds", getEnvAsIntOrDefault("STATE_NONCE_FIX_EXPIRY_SECONDS", int(defaultStateOptions.NonceFixForAccountExpiry.Seconds())), "seconds to keep the nonce fix of an account")
+	stateWhitehatSeconds   = flag.Int("stateWhitehatBundleExpirySeconds", getEnvAsIntOrDefault("STATE_WHITEHAT_BUNDLE_EXPIRY_SECONDS", int(defaultStateOptions.WhitehatBundleExpiry.Seconds())), "seconds to keep the txs of a whitehat bundle")
+	blockTimeSeconds       = flag.Int("blockTimeSeconds", getEnvAsIntOrDefault("BLOCK_TIME_SECONDS", int(defaultStateOptions.BlockTime.Seconds())), "block time of the chain, used to keep the pending max nonce of a sender for the blockRange of its tx")
+	defaultBlockRange      = flag.Int("defaultBlockRange", getEnvAsIntOrDefault("DEFAULT_BLOCK_RANGE", defaultStateOptions.DefaultBlockRange), "blocks to keep the pending max nonce of a sender, if the tx sets no blockRange")
+	rejectUnprotected      = flag.Bool("rejectUnprotectedTxs", os.Getenv("REJECT_UNPROTECTED_TXS") == "1", "reject legacy txs without a chain id (pre EIP-155)")
+	chainName              = flag.String("chainName", getEnvAsStrOrDefault("CHAIN_NAME", "mainnet"), "name of the default chain, reported by GET /chain")
+	allowMethods           = flag.String("allowMethods", os.Getenv("ALLOW_METHODS"), "comma-separated JSON-RPC method patterns which are served, e.g. eth_*,net_* (all methods if empty)")
+	denyMethods            = flag.String("denyMethods", getEnvAsStrOrDefault("DENY_METHODS", strings.Join(server.DefaultDeniedMethods, ",")), "comma-separated JSON-RPC method patterns which are not served")
+	customUpstreams        = flag.String("allowedCustomUpstreams", os.Getenv("ALLOWED_CUSTOM_UPSTREAMS"), "comma-separated hosts which clients can set with the url and mempoolRPC params (all public hosts if empty)")
+	mempoolBroadcast       = flag.Bool("mempoolBroadcast", os.Getenv("MEMPOOL_BROADCAST") == "1", "send txs of users who set useMempool to the mempool RPC, besides the relay")
+	mempoolDelaySeconds    = flag.Int("mempoolBroadcastDelaySeconds", getEnvAsIntOrDefault("MEMPOOL_BROADCAST_DELAY_SECONDS", defaultMempoolDelaySeconds), "seconds to wait before sending useMempool txs to the mempool, unless the relay rejects them")
+	chainsConfigFile       = flag.String("chainsConfig", defaultChainsConfigFile, "JSON file of additional chains, served on /<name> and on their hosts")
+	relayUrl               = flag.String("relayUrl", getEnvAsStrOrDefault("RELAY_URL", defaultRelayUrl), "URL for relay")
+	relaySigningKey        = flag.String("signingKey", os.Getenv("RELAY_SIGNING_KEY"), "Signing key for relay requests")
+	psqlDsn                = flag.String("psql", os.Getenv("POSTGRES_DSN"), "Postgres DSN")
+	psqlAutoMigrate        = flag.Bool("psqlAutoMigrate", os.Getenv("POSTGRES_AUTO_MIGRATE") == "1", "apply pending Postgres migrations on startup")
+	debugPtr               = flag.Bool("debug", defaultDebug, "print debug output")
+	logJSONPtr             = flag.Bool("logJSON", defaultLogJSON, "log in JSON")
+	serviceName            = flag.String("serviceName", defaultServiceName, "name of the service which will be used in the logs")
+	recordQueueSize        = flag.Int("recordQueueSize", getEnvAsIntOrDefault("RECORD_QUEUE_SIZE", server.DefaultRecordQueueSize), "max number of request records waiting to be saved")
+	recordWorkers          = flag.Int("recordWorkers", getEnvAsIntOrDefault("RECORD_WORKERS", server.DefaultRecordWorkers), "number of workers saving request records")
+	recordDrainSeconds     = flag.Int("recordDrainSeconds", getEnvAsIntOrDefault("RECORD_DRAIN_SECONDS", int(server.DefaultRecordDrainTimeout/time.Second)), "seconds to wait for pending request records to be saved on shutdown")
+	inclusionWindow        = flag.Int("inclusionWindowMinutes", getEnvAsIntOrDefault("INCLUSION_WINDOW_MINUTES", defaultInclusionWindowMinutes), "minutes to track inclusion of relayed txs before they expire (0 disables tracking, requires Postgres)")
 )
 
 func main() {
@@ -82,6 +122,14 @@ func main() {
 		return
 	}
 
+	// Perhaps only run migrations: migrate [up|down|status|baseline <version>]
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrateCommand(logger, flag.Args()[1:]); err != nil {
+			logger.Crit("Migration failed", "error", err)
+		}
+		return
+	}
+
 	logger.Info("Init rpc-endpoint", "version", version)
 
 	if *relaySigningKey == "" {
@@ -102,10 +150,34 @@ func main() {
 
 	// Setup database
 	var db database.Store
+	var inclusionStore database.InclusionStore
 	if *psqlDsn == "" {
 		db = database.NewMockStore()
 	} else {
-		db = database.NewPostgresStore(*psqlDsn)
+		pgStore := database.NewPostgresStore(*psqlDsn)
+		migrator, err := newMigrator(pgStore.DB)
+		if err != nil {
+			logger.Crit("Loading migrations failed", "error", err)
+		}
+		if *psqlAutoMigrate {
+			applied, err := migrator.Up(context.Background())
+			if err != nil {
+				logger.Crit("Applying migrations failed", "error", err)
+			}
+			logger.Info("Applied migrations", "versions", applied)
+		}
+		if err := migrator.CheckVersion(context.Background()); err != nil {
+			logger.Crit("Refusing to start, run the migrate command or set -psqlAutoMigrate", "error", err)
+		}
+		bufferedStore := database.NewBufferedStore(pgStore, database.DefaultBufferedStoreOptions)
+		defer bufferedStore.Close()
+		db = bufferedStore
+		inclusionStore = pgStore
+	}
+
+	auditSink, err := server.NewAuditSink(logger, *auditSinkTarget)
+	if err != nil {
+		logger.Crit("Invalid audit sink", "error", err)
 	}
 
 	logger.Info("Reading customer config from file", "file", defaultCustomerConfigFile)
@@ -116,26 +188,85 @@ func main() {
 
 	metrics.InitCustomersConfigMetric(configurationWatcher.Customers()...)
 
+	chains, err := server.ReadChainsConfigFromFile(*chainsConfigFile)
+	if err != nil {
+		logger.Crit("Chains config file is set, but file is invalid", "error", err)
+	}
+
 	// todo: setup configuration watcher
 
+	allowedCustomUpstreams, err := server.ParseUpstreamHosts(*customUpstreams)
+	if err != nil {
+		logger.Crit("Invalid allowed custom upstreams", "error", err)
+	}
+
+	txExpiry := time.Duration(*stateTxSeconds) * time.Second
+	stateOptions := server.StateOptions{
+		Namespace:                     *redisNamespace,
+		TxSentToRelayExpiry:           txExpiry,
+		TxHashForSenderAndNonceExpiry: txExpiry,
+		NonceFixForAccountExpiry:      time.Duration(*stateNonceFixSeconds) * time.Second,
+		SenderAndNonceOfTxHashExpiry:  txExpiry,
+		WhitehatBundleExpiry:          time.Duration(*stateWhitehatSeconds) * time.Second,
+		BlockedTxHashExpiry:           txExpiry,
+		BlockTime:                     time.Duration(*blockTimeSeconds) * time.Second,
+		DefaultBlockRange:             *defaultBlockRange,
+	}
+	if err := stateOptions.Validate(); err != nil {
+		logger.Crit("Invalid state options", "error", err)
+	}
+
 	// Start the endpoint
 	s, err := server.NewRpcEndPointServer(server.Configuration{
-		DB:                   db,
-		DrainAddress:         *drainAddress,
-		DrainSeconds:         *drainSeconds,
-		ListenAddress:        *listenAddress,
-		Logger:               logger,
-		ProxyTimeoutSeconds:  *proxyTimeoutSeconds,
-		ProxyUrl:             *proxyUrl,
-		RedisUrl:             *redisUrl,
-		RelaySigningKey:      key,
-		RelayUrl:             *relayUrl,
-		Version:              version,
-		BuilderInfoSource:    *builderInfoSource,
-		FetchInfoInterval:    *fetchIntervalSeconds,
-		TTLCacheSeconds:      int64(*ttlCacheSeconds),
-		DefaultMempoolRPC:    defaultMempoolRPC,
-		ConfigurationWatcher: configurationWatcher,
+		DB:                  db,
+		DrainAddress:        *drainAddress,
+		DrainSeconds:        *drainSeconds,
+		AdminAddress:        *adminAddress,
+		AdminToken:          *adminToken,
+		AdminTLSCertFile:    *adminTLSCert,
+		AdminTLSKeyFile:     *adminTLSKey,
+		AdminClientCAFile:   *adminClientCA,
+		ListenAddress:       *listenAddress,
+		Logger:              logger,
+		ProxyTimeoutSeconds: *proxyTimeoutSeconds,
+		Tracing: server.TracingConfiguration{
+			Exporter:     *tracingExporter,
+			OTLPEndpoint: *tracingEndpoint,
+			SampleRatio:  *tracingSampleRatio,
+		},
+		RequestTimeouts: server.RequestTimeouts{
+			Request:  time.Duration(*requestTimeoutSeconds) * time.Second,
+			Relay:    time.Duration(*relayTimeoutSeconds) * time.Second,
+			TxStatus: time.Duration(*txStatusTimeoutSeconds) * time.Second,
+		},
+		ProxyUrl:               *proxyUrl,
+		RedisUrl:               *redisUrl,
+		StateOptions:           stateOptions,
+		RelaySigningKey:        key,
+		RelayUrl:               *relayUrl,
+		Version:                version,
+		BuilderInfoSource:      *builderInfoSource,
+		FetchInfoInterval:      *fetchIntervalSeconds,
+		TTLCacheSeconds:        int64(*ttlCacheSeconds),
+		DefaultMempoolRPC:      defaultMempoolRPC,
+		RejectUnprotectedTxs:   *rejectUnprotected,
+		MempoolBroadcast:       *mempoolBroadcast,
+		MempoolBroadcastDelay:  time.Duration(*mempoolDelaySeconds) * time.Second,
+		ChainName:              *chainName,
+		Chains:                 chains,
+		AuditSink:              auditSink,
+		ConfigurationWatcher:   configurationWatcher,
+		CustomerConfigFile:     defaultCustomerConfigFile,
+		AllowedCustomUpstreams: allowedCustomUpstreams,
+		MethodPolicy: server.MethodPolicy{
+			Allow: server.ParseMethodPatterns(*allowMethods),
+			Deny:  server.ParseMethodPatterns(*denyMethods),
+		},
+		RecordQueueSize:    *recordQueueSize,
+		RecordWorkers:      *recordWorkers,
+		RecordDrainTimeout: time.Duration(*recordDrainSeconds) * time.Second,
+		InclusionStore:     inclusionStore,
+		InclusionWindow:    time.Duration(*inclusionWindow) * time.Minute,
 	})
 	if err != nil {
 		logger.Crit("Server init error", "error", err)
@@ -153,6 +284,67 @@ func main() {
 	s.Start()
 }
 
+func newMigrator(db *sqlx.DB) (*database.Migrator, error) {
+	migrations, err := database.LoadPsqlMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return database.NewMigrator(db, migrations), nil
+}
+
+func runMigrateCommand(logger log.Logger, args []string) error {
+	if *psqlDsn == "" {
+		return errors.New("no Postgres DSN set")
+	}
+	pgStore := database.NewPostgresStore(*psqlDsn)
+	defer pgStore.Close()
+	migrator, err := newMigrator(pgStore.DB)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("Applied migrations", "versions", applied)
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("Reverted migration", "version", reverted)
+	case "status":
+		current, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("Migration status", "version", current, "latest", migrator.LatestVersion())
+	case "baseline":
+		if len(args) < 2 {
+			return errors.New("baseline requires a version")
+		}
+		baseline, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		if err = migrator.Baseline(ctx, baseline); err != nil {
+			return err
+		}
+		logger.Info("Marked migrations as applied", "version", baseline)
+	default:
+		return fmt.Errorf("unknown migrate command %s", command)
+	}
+	return nil
+}
+
 func getEnvAsStrOrDefault(key string, defaultValue string) string {
 	ret := os.Getenv(key)
 	if ret == "" {
@@ -169,3 +361,12 @@ func getEnvAsIntOrDefault(name string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvAsFloatOrDefault(name string, defaultValue float64) float64 {
+	if valueStr, exists := os.LookupEnv(name); exists {
+		if value, err := strconv.ParseFloat(valueStr, 64); err == nil {
+			return value
+		}
+	}
+	return defaultValue
+}
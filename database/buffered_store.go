@@ -0,0 +1,294 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/metrics"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var ErrBufferFull = errors.New("store buffer is full")
+
+type BufferedStoreOptions struct {
+	// MaxBatchSize is the max number of entries written in a single transaction, reaching it triggers a flush
+	MaxBatchSize int
+	// MaxPendingEntries bounds the buffer, saves are rejected with ErrBufferFull above it
+	MaxPendingEntries int
+	// FlushInterval triggers a flush of a partially filled batch
+	FlushInterval time.Duration
+	// MaxRetries is the number of retries of a batch on transient errors
+	MaxRetries int
+	// RetryBackoff is the initial backoff between retries, doubled on each retry
+	RetryBackoff time.Duration
+	// WriteTimeout bounds a single write attempt of a batch
+	WriteTimeout time.Duration
+}
+
+var DefaultBufferedStoreOptions = BufferedStoreOptions{
+	MaxBatchSize:      500,
+	MaxPendingEntries: 20000,
+	FlushInterval:     time.Second,
+	MaxRetries:        3,
+	RetryBackoff:      100 * time.Millisecond,
+	WriteTimeout:      connTimeOut,
+}
+
+// bufferedStore buffers entries in memory and writes them in batches, on a size or time trigger.
+// A request entry and its raw tx entries are always written in the same transaction.
+// Reads are served by the underlying store, so entries are visible only after they are flushed.
+type bufferedStore struct {
+	saver   BatchStore
+	opts    BufferedStoreOptions
+	logger  log.Logger
+	mu      sync.Mutex
+	pending Batch
+	flushCh chan struct{}
+	stopCh  chan struct{}
+	// loopDone is closed when the flush loop has exited
+	loopDone chan struct{}
+	stopOnce sync.Once
+	// writeMx serializes batch writes, so entries are written in order
+	writeMx sync.Mutex
+}
+
+func NewBufferedStore(saver BatchStore, opts BufferedStoreOptions) *bufferedStore {
+	s := &bufferedStore{
+		saver:    saver,
+		opts:     opts,
+		logger:   log.New("component", "bufferedStore"),
+		flushCh:  make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+		loopDone: make(chan struct{}),
+	}
+	go s.flushLoop()
+	return s
+}
+
+func (s *bufferedStore) SaveRequestEntry(entry RequestEntry) error {
+	return s.SaveRecord(entry, nil)
+}
+
+func (s *bufferedStore) SaveRawTxEntries(entries []*EthSendRawTxEntry) error {
+	return s.add(nil, entries)
+}
+
+func (s *bufferedStore) SaveRecord(entry RequestEntry, rawTxEntries []*EthSendRawTxEntry) error {
+	return s.add(&entry, rawTxEntries)
+}
+
+func (s *bufferedStore) GetRawTxEntries(ctx context.Context, filter RawTxEntryFilter) ([]*EthSendRawTxEntry, error) {
+	return s.saver.GetRawTxEntries(ctx, filter)
+}
+
+func (s *bufferedStore) CountRawTxEntriesByErrorCode(ctx context.Context, filter RawTxEntryFilter) ([]ErrorCodeCount, error) {
+	return s.saver.CountRawTxEntriesByErrorCode(ctx, filter)
+}
+
+// Ping checks the connectivity of the underlying store, if it is backed by a remote database
+func (s *bufferedStore) Ping(ctx context.Context) error {
+	if pinger, ok := s.saver.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Close closes the underlying store. Stop must be called before, so pending entries are written.
+func (s *bufferedStore) Close() {
+	if closer, ok := s.saver.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Stop stops the background flushes and writes all pending entries, or until ctx is done. Entries which are not
+// written are counted as dropped.
+func (s *bufferedStore) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	select {
+	case <-s.loopDone:
+	case <-ctx.Done():
+		s.mu.Lock()
+		dropped := s.pending.Len()
+		s.pending = Batch{}
+		s.mu.Unlock()
+		metrics.AddDatabaseDroppedEntries(dropped)
+		s.logger.Error("pending entries dropped, flush loop did not stop", "entries", dropped)
+		return ctx.Err()
+	}
+	return s.Flush(ctx)
+}
+
+func (s *bufferedStore) add(entry *RequestEntry, rawTxEntries []*EthSendRawTxEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(rawTxEntries)
+	if entry != nil {
+		n++
+	}
+	if s.pending.Len()+n > s.opts.MaxPendingEntries {
+		return ErrBufferFull
+	}
+	if entry != nil {
+		s.pending.RequestEntries = append(s.pending.RequestEntries, *entry)
+	}
+	s.pending.RawTxEntries = append(s.pending.RawTxEntries, rawTxEntries...)
+	if s.pending.Len() >= s.opts.MaxBatchSize {
+		select {
+		case s.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *bufferedStore) flushLoop() {
+	defer close(s.loopDone)
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.flushCh:
+		case <-s.stopCh:
+			return
+		}
+		if err := s.Flush(context.Background()); err != nil {
+			s.logger.Error("flush failed", "error", err)
+		}
+	}
+}
+
+// Flush writes all pending entries, in batches of at most MaxBatchSize entries
+func (s *bufferedStore) Flush(ctx context.Context) error {
+	s.writeMx.Lock()
+	defer s.writeMx.Unlock()
+
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = Batch{}
+	s.mu.Unlock()
+
+	var lastErr error
+	for _, batch := range splitBatch(pending, s.opts.MaxBatchSize) {
+		err := s.writeWithRetry(ctx, batch)
+		if err == nil {
+			continue
+		}
+		records := splitBatch(batch, 1)
+		if len(records) > 1 && !IsTransientError(err) && ctx.Err() == nil {
+			// A bad entry fails the whole batch, write each record on its own so only the bad one is dropped
+			s.logger.Warn("batch write failed, writing records one by one", "entries", batch.Len(), "error", err)
+			err = s.writeRecords(ctx, records)
+		} else {
+			s.drop(batch, err)
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// writeRecords writes each record, a request entry together with its raw tx entries, and drops the ones which fail
+func (s *bufferedStore) writeRecords(ctx context.Context, records []Batch) (lastErr error) {
+	for _, record := range records {
+		if err := s.writeWithRetry(ctx, record); err != nil {
+			s.drop(record, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (s *bufferedStore) drop(batch Batch, err error) {
+	metrics.IncDatabaseErr()
+	metrics.AddDatabaseDroppedEntries(batch.Len())
+	s.logger.Error("entries dropped", "entries", batch.Len(), "error", err)
+}
+
+func (s *bufferedStore) writeWithRetry(ctx context.Context, batch Batch) error {
+	backoff := s.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		writeCtx, cancel := context.WithTimeout(ctx, s.opts.WriteTimeout)
+		err := s.saver.SaveBatch(writeCtx, batch)
+		cancel()
+		if err == nil || attempt >= s.opts.MaxRetries || !IsTransientError(err) {
+			return err
+		}
+		s.logger.Warn("batch write failed, retrying", "attempt", attempt+1, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// splitBatch splits the batch into batches of at most maxSize entries, keeping request entries
+// together with their raw tx entries
+func splitBatch(batch Batch, maxSize int) []Batch {
+	rawTxsByRequest := make(map[uuid.UUID][]*EthSendRawTxEntry, len(batch.RequestEntries))
+	orphanRawTxs := make([]*EthSendRawTxEntry, 0)
+	requestIds := make(map[uuid.UUID]struct{}, len(batch.RequestEntries))
+	for _, entry := range batch.RequestEntries {
+		requestIds[entry.Id] = struct{}{}
+	}
+	for _, rawTx := range batch.RawTxEntries {
+		if _, ok := requestIds[rawTx.RequestId]; ok {
+			rawTxsByRequest[rawTx.RequestId] = append(rawTxsByRequest[rawTx.RequestId], rawTx)
+		} else {
+			orphanRawTxs = append(orphanRawTxs, rawTx)
+		}
+	}
+
+	var batches []Batch
+	current := Batch{}
+	for _, entry := range batch.RequestEntries {
+		rawTxs := rawTxsByRequest[entry.Id]
+		if current.Len() > 0 && current.Len()+1+len(rawTxs) > maxSize {
+			batches = append(batches, current)
+			current = Batch{}
+		}
+		current.RequestEntries = append(current.RequestEntries, entry)
+		current.RawTxEntries = append(current.RawTxEntries, rawTxs...)
+	}
+	for _, rawTx := range orphanRawTxs {
+		if current.Len() >= maxSize {
+			batches = append(batches, current)
+			current = Batch{}
+		}
+		current.RawTxEntries = append(current.RawTxEntries, rawTx)
+	}
+	if current.Len() > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
+
+// IsTransientError reports whether a failed write may succeed when retried
+func IsTransientError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection exception
+			"40", // transaction rollback, e.g. serialization failure or deadlock
+			"53", // insufficient resources
+			"57": // operator intervention, e.g. admin shutdown
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	vmetrics "github.com/VictoriaMetrics/metrics"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+// flakySaver fails the first failures writes with err, then writes to the mem store
+type flakySaver struct {
+	mu       sync.Mutex
+	mem      *memStore
+	failures int
+	err      error
+	calls    int
+	batches  []Batch
+	block    chan struct{} // if set, writes wait until it is closed
+}
+
+func (f *flakySaver) SaveBatch(ctx context.Context, batch Batch) error {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		return f.err
+	}
+	f.batches = append(f.batches, batch)
+	return f.mem.SaveBatch(ctx, batch)
+}
+
+func (f *flakySaver) GetRawTxEntries(ctx context.Context, filter RawTxEntryFilter) ([]*EthSendRawTxEntry, error) {
+	return f.mem.GetRawTxEntries(ctx, filter)
+}
+
+func (f *flakySaver) CountRawTxEntriesByErrorCode(ctx context.Context, filter RawTxEntryFilter) ([]ErrorCodeCount, error) {
+	return f.mem.CountRawTxEntriesByErrorCode(ctx, filter)
+}
+
+func testOptions() BufferedStoreOptions {
+	return BufferedStoreOptions{
+		MaxBatchSize:      10,
+		MaxPendingEntries: 100,
+		FlushInterval:     time.Hour,
+		MaxRetries:        2,
+		RetryBackoff:      time.Millisecond,
+		WriteTimeout:      time.Second,
+	}
+}
+
+func newRecord(numRawTxs int) (RequestEntry, []*EthSendRawTxEntry) {
+	entry := RequestEntry{Id: uuid.New()}
+	rawTxs := make([]*EthSendRawTxEntry, numRawTxs)
+	for i := range rawTxs {
+		rawTxs[i] = &EthSendRawTxEntry{Id: uuid.New(), RequestId: entry.Id}
+	}
+	return entry, rawTxs
+}
+
+func TestBufferedStoreMatchesMemStore(t *testing.T) {
+	reference := NewMemStore()
+	saver := &flakySaver{mem: NewMemStore()}
+	store := NewBufferedStore(saver, testOptions())
+
+	for i := 0; i < 7; i++ {
+		entry, rawTxs := newRecord(2)
+		require.NoError(t, reference.SaveRequestEntry(entry))
+		require.NoError(t, reference.SaveRawTxEntries(rawTxs))
+		require.NoError(t, store.SaveRecord(entry, rawTxs))
+	}
+	require.NoError(t, store.Flush(context.Background()))
+
+	require.Equal(t, reference.Requests, saver.mem.Requests)
+	require.Equal(t, reference.EthSendRawTxs, saver.mem.EthSendRawTxs)
+
+	// Every request is written in the same batch as its raw txs, and no batch exceeds the max size
+	for _, batch := range saver.batches {
+		require.LessOrEqual(t, batch.Len(), 10)
+		for _, rawTx := range batch.RawTxEntries {
+			found := false
+			for _, entry := range batch.RequestEntries {
+				found = found || entry.Id == rawTx.RequestId
+			}
+			require.True(t, found)
+		}
+	}
+}
+
+func TestBufferedStoreRetriesTransientErrors(t *testing.T) {
+	saver := &flakySaver{mem: NewMemStore(), failures: 2, err: &pq.Error{Code: "40001"}}
+	store := NewBufferedStore(saver, testOptions())
+
+	entry, rawTxs := newRecord(1)
+	require.NoError(t, store.SaveRecord(entry, rawTxs))
+	require.NoError(t, store.Flush(context.Background()))
+	require.Equal(t, 3, saver.calls)
+	require.Len(t, saver.mem.Requests, 1)
+}
+
+func TestBufferedStoreDropsOnPermanentErrors(t *testing.T) {
+	saver := &flakySaver{mem: NewMemStore(), failures: 1, err: &pq.Error{Code: "23505"}}
+	store := NewBufferedStore(saver, testOptions())
+
+	entry, rawTxs := newRecord(1)
+	require.NoError(t, store.SaveRecord(entry, rawTxs))
+	require.Error(t, store.Flush(context.Background()))
+	require.Equal(t, 1, saver.calls)
+	require.Len(t, saver.mem.Requests, 0)
+}
+
+func TestBufferedStoreDropsOnlyBadRecord(t *testing.T) {
+	saver := &flakySaver{mem: NewMemStore(), failures: 2, err: &pq.Error{Code: "22001"}}
+	store := NewBufferedStore(saver, testOptions())
+
+	// The batch fails, and so does the first record written on its own
+	bad, badRawTxs := newRecord(1)
+	require.NoError(t, store.SaveRecord(bad, badRawTxs))
+	for i := 0; i < 2; i++ {
+		entry, rawTxs := newRecord(1)
+		require.NoError(t, store.SaveRecord(entry, rawTxs))
+	}
+	before := vmetrics.GetOrCreateCounter("postgres_dropped_entries_total").Get()
+	require.Error(t, store.Flush(context.Background()))
+	require.Equal(t, 4, saver.calls)
+	require.Len(t, saver.mem.Requests, 2)
+	require.Len(t, saver.mem.EthSendRawTxs, 2)
+	require.NotContains(t, saver.mem.Requests, bad.Id)
+	require.Equal(t, before+2, vmetrics.GetOrCreateCounter("postgres_dropped_entries_total").Get())
+}
+
+func TestBufferedStoreSizeTrigger(t *testing.T) {
+	saver := &flakySaver{mem: NewMemStore()}
+	store := NewBufferedStore(saver, testOptions())
+
+	entry, rawTxs := newRecord(9)
+	require.NoError(t, store.SaveRecord(entry, rawTxs))
+	require.Eventually(t, func() bool {
+		saver.mu.Lock()
+		defer saver.mu.Unlock()
+		return len(saver.mem.Requests) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestBufferedStoreBufferFull(t *testing.T) {
+	opts := testOptions()
+	opts.MaxBatchSize = 1000
+	opts.MaxPendingEntries = 3
+	store := NewBufferedStore(&flakySaver{mem: NewMemStore()}, opts)
+
+	entry, rawTxs := newRecord(2)
+	require.NoError(t, store.SaveRecord(entry, rawTxs))
+	entry, rawTxs = newRecord(0)
+	require.ErrorIs(t, store.SaveRecord(entry, rawTxs), ErrBufferFull)
+}
+
+// remoteSaver is a flakySaver backed by a remote database
+type remoteSaver struct {
+	*flakySaver
+	pingErr error
+	closed  bool
+}
+
+func (r *remoteSaver) Ping(ctx context.Context) error {
+	return r.pingErr
+}
+
+func (r *remoteSaver) Close() {
+	r.closed = true
+}
+
+func TestBufferedStoreForwardsPingAndClose(t *testing.T) {
+	saver := &remoteSaver{flakySaver: &flakySaver{mem: NewMemStore()}, pingErr: errors.New("connection refused")}
+	store := NewBufferedStore(saver, testOptions())
+
+	var pinger Pinger = store
+	require.ErrorIs(t, pinger.Ping(context.Background()), saver.pingErr)
+	store.Close()
+	require.True(t, saver.closed)
+
+	// A store without remote database is always reachable
+	require.NoError(t, NewBufferedStore(&flakySaver{mem: NewMemStore()}, testOptions()).Ping(context.Background()))
+}
+
+func TestBufferedStoreStop(t *testing.T) {
+	saver := &flakySaver{mem: NewMemStore()}
+	store := NewBufferedStore(saver, testOptions())
+
+	entry, rawTxs := newRecord(1)
+	require.NoError(t, store.SaveRecord(entry, rawTxs))
+	require.NoError(t, store.Stop(context.Background()))
+	require.Len(t, saver.mem.Requests, 1)
+
+	// The flush loop has exited, and stopping again is a no-op
+	select {
+	case <-store.loopDone:
+	default:
+		t.Fatal("flush loop still running")
+	}
+	require.NoError(t, store.Stop(context.Background()))
+}
+
+func TestBufferedStoreStopTimeoutCountsDropped(t *testing.T) {
+	saver := &flakySaver{mem: NewMemStore(), block: make(chan struct{})}
+	defer close(saver.block)
+	store := NewBufferedStore(saver, testOptions())
+
+	// A full batch makes the flush loop write, which blocks
+	entry, rawTxs := newRecord(9)
+	require.NoError(t, store.SaveRecord(entry, rawTxs))
+	require.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.pending.Len() == 0
+	}, time.Second, time.Millisecond)
+
+	entry, rawTxs = newRecord(1)
+	require.NoError(t, store.SaveRecord(entry, rawTxs))
+	before := vmetrics.GetOrCreateCounter("postgres_dropped_entries_total").Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, store.Stop(ctx), context.DeadlineExceeded)
+	require.Equal(t, before+2, vmetrics.GetOrCreateCounter("postgres_dropped_entries_total").Get())
+}
+
+func TestIsTransientError(t *testing.T) {
+	require.True(t, IsTransientError(&pq.Error{Code: "08006"}))
+	require.True(t, IsTransientError(&net.OpError{Op: "dial", Err: errors.New("refused")}))
+	require.True(t, IsTransientError(context.DeadlineExceeded))
+	require.False(t, IsTransientError(&pq.Error{Code: "23505"}))
+	require.False(t, IsTransientError(errors.New("some error")))
+}
@@ -1,21 +1,31 @@
 package database
 
 import (
-	"github.com/google/uuid"
+	"context"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type memStore struct {
 	Requests      map[uuid.UUID]RequestEntry
 	EthSendRawTxs map[uuid.UUID][]*EthSendRawTxEntry
-	mutex         sync.Mutex
+	Inclusions    map[uuid.UUID]InclusionOutcome
+	// InclusionClaims has the time until which unresolved txs are claimed by a tracker
+	InclusionClaims map[uuid.UUID]time.Time
+	mutex           sync.Mutex
 }
 
 func NewMemStore() *memStore {
 	return &memStore{
-		Requests:      make(map[uuid.UUID]RequestEntry),
-		EthSendRawTxs: make(map[uuid.UUID][]*EthSendRawTxEntry),
-		mutex:         sync.Mutex{},
+		Requests:        make(map[uuid.UUID]RequestEntry),
+		EthSendRawTxs:   make(map[uuid.UUID][]*EthSendRawTxEntry),
+		Inclusions:      make(map[uuid.UUID]InclusionOutcome),
+		InclusionClaims: make(map[uuid.UUID]time.Time),
+		mutex:           sync.Mutex{},
 	}
 }
 
@@ -34,3 +44,127 @@ func (m *memStore) SaveRawTxEntries(entries []*EthSendRawTxEntry) error {
 	}
 	return nil
 }
+
+func (m *memStore) SaveBatch(ctx context.Context, batch Batch) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, entry := range batch.RequestEntries {
+		m.Requests[entry.Id] = entry
+	}
+	for _, entry := range batch.RawTxEntries {
+		m.EthSendRawTxs[entry.RequestId] = append(m.EthSendRawTxs[entry.RequestId], entry)
+	}
+	return nil
+}
+
+func (m *memStore) GetRawTxEntries(ctx context.Context, filter RawTxEntryFilter) ([]*EthSendRawTxEntry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entries := m.filterRawTxEntries(filter)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return m.Requests[entries[i].RequestId].ReceivedAt.After(m.Requests[entries[j].RequestId].ReceivedAt)
+	})
+	if limit := filter.EffectiveLimit(); len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (m *memStore) CountRawTxEntriesByErrorCode(ctx context.Context, filter RawTxEntryFilter) ([]ErrorCodeCount, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	counts := make(map[int]int64)
+	for _, entry := range m.filterRawTxEntries(filter) {
+		counts[entry.ErrorCode]++
+	}
+	res := make([]ErrorCodeCount, 0, len(counts))
+	for code, count := range counts {
+		res = append(res, ErrorCodeCount{ErrorCode: code, Count: count})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ErrorCode < res[j].ErrorCode })
+	return res, nil
+}
+
+func (m *memStore) ClaimUnresolvedRelayedTxs(ctx context.Context, chain string, since, now, leaseUntil time.Time, limit int) ([]RelayedTx, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var txs []RelayedTx
+	for requestId, entries := range m.EthSendRawTxs {
+		request := m.Requests[requestId]
+		receivedAt := request.ReceivedAt
+		if receivedAt.Before(since) || request.Chain != chain {
+			continue
+		}
+		for _, entry := range entries {
+			_, resolved := m.Inclusions[entry.Id]
+			claimedUntil, claimed := m.InclusionClaims[entry.Id]
+			if entry.WasSentToRelay && !resolved && (!claimed || !claimedUntil.After(now)) {
+				txs = append(txs, RelayedTx{RawTxEntryId: entry.Id, TxHash: entry.TxHash, OriginId: entry.OriginId, ReceivedAt: receivedAt})
+			}
+		}
+	}
+	// Never claimed txs first, then by claim time and age
+	sort.SliceStable(txs, func(i, j int) bool {
+		claimedI, okI := m.InclusionClaims[txs[i].RawTxEntryId]
+		claimedJ, okJ := m.InclusionClaims[txs[j].RawTxEntryId]
+		if okI != okJ {
+			return !okI
+		}
+		if !claimedI.Equal(claimedJ) {
+			return claimedI.Before(claimedJ)
+		}
+		return txs[i].ReceivedAt.Before(txs[j].ReceivedAt)
+	})
+	if len(txs) > limit {
+		txs = txs[:limit]
+	}
+	for _, tx := range txs {
+		m.InclusionClaims[tx.RawTxEntryId] = leaseUntil
+	}
+	sort.SliceStable(txs, func(i, j int) bool { return txs[i].ReceivedAt.Before(txs[j].ReceivedAt) })
+	return txs, nil
+}
+
+func (m *memStore) SaveInclusionOutcomes(ctx context.Context, outcomes []InclusionOutcome) ([]uuid.UUID, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var inserted []uuid.UUID
+	for _, outcome := range outcomes {
+		if _, ok := m.Inclusions[outcome.RawTxEntryId]; !ok {
+			m.Inclusions[outcome.RawTxEntryId] = outcome
+			inserted = append(inserted, outcome.RawTxEntryId)
+		}
+		delete(m.InclusionClaims, outcome.RawTxEntryId)
+	}
+	return inserted, nil
+}
+
+// filterRawTxEntries must be called with the mutex held
+func (m *memStore) filterRawTxEntries(filter RawTxEntryFilter) []*EthSendRawTxEntry {
+	var res []*EthSendRawTxEntry
+	for requestId, entries := range m.EthSendRawTxs {
+		request := m.Requests[requestId]
+		if filter.Origin != "" && request.Origin != filter.Origin {
+			continue
+		}
+		if !filter.Since.IsZero() && request.ReceivedAt.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !request.ReceivedAt.Before(filter.Until) {
+			continue
+		}
+		for _, entry := range entries {
+			if filter.TxHash != "" && !strings.EqualFold(entry.TxHash, filter.TxHash) {
+				continue
+			}
+			if filter.TxFrom != "" && !strings.EqualFold(entry.TxFrom, filter.TxFrom) {
+				continue
+			}
+			if filter.OriginId != "" && entry.OriginId != filter.OriginId {
+				continue
+			}
+			res = append(res, entry)
+		}
+	}
+	return res
+}
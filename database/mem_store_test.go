@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStoreQueries(t *testing.T) {
+	store := NewMemStore()
+	now := time.Now()
+	save := func(origin string, receivedAt time.Time, rawTxs ...*EthSendRawTxEntry) {
+		entry := RequestEntry{Id: uuid.New(), Origin: origin, ReceivedAt: receivedAt}
+		for _, rawTx := range rawTxs {
+			rawTx.Id = uuid.New()
+			rawTx.RequestId = entry.Id
+		}
+		require.NoError(t, store.SaveRequestEntry(entry))
+		require.NoError(t, store.SaveRawTxEntries(rawTxs))
+	}
+	save("wallet-a", now.Add(-time.Hour), &EthSendRawTxEntry{TxHash: "0xaa", TxFrom: "0xAbC", WasSentToRelay: true})
+	save("wallet-a", now, &EthSendRawTxEntry{TxHash: "0xbb", TxFrom: "0xabc", ErrorCode: -32603})
+	save("wallet-b", now, &EthSendRawTxEntry{TxHash: "0xcc", TxFrom: "0xdef", ErrorCode: -32603})
+
+	ctx := context.Background()
+	entries, err := store.GetRawTxEntries(ctx, RawTxEntryFilter{TxHash: "0xAA"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.True(t, entries[0].WasSentToRelay)
+
+	// Newest first
+	entries, err = store.GetRawTxEntries(ctx, RawTxEntryFilter{TxFrom: "0xABC"})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "0xbb", entries[0].TxHash)
+
+	entries, err = store.GetRawTxEntries(ctx, RawTxEntryFilter{Origin: "wallet-a", Since: now.Add(-time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "0xbb", entries[0].TxHash)
+
+	entries, err = store.GetRawTxEntries(ctx, RawTxEntryFilter{Until: now.Add(-time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "0xaa", entries[0].TxHash)
+
+	entries, err = store.GetRawTxEntries(ctx, RawTxEntryFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	counts, err := store.CountRawTxEntriesByErrorCode(ctx, RawTxEntryFilter{})
+	require.NoError(t, err)
+	require.Equal(t, []ErrorCodeCount{{ErrorCode: -32603, Count: 2}, {ErrorCode: 0, Count: 1}}, counts)
+}
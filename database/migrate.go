@@ -0,0 +1,283 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	schema "github.com/flashbots/rpc-endpoint/sql"
+)
+
+var (
+	ErrSchemaMismatch = errors.New("database schema version does not match the migrations of this build")
+	ErrNoMigration    = errors.New("no migration to apply")
+
+	migrationFileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+	// migration files wrap themselves in BEGIN/COMMIT for manual use, the runner provides the transaction instead
+	transactionStatementRe = regexp.MustCompile(`(?im)^\s*(BEGIN|COMMIT)\s*;\s*$`)
+	// files with this line run outside of a transaction, statement by statement, e.g. for CREATE INDEX CONCURRENTLY
+	noTransactionRe = regexp.MustCompile(`(?m)^\s*--\s*migrate:no-transaction\s*$`)
+	statementEndRe  = regexp.MustCompile(`;\s*(\n|$)`)
+	commentLineRe   = regexp.MustCompile(`(?m)^\s*--.*$`)
+)
+
+const (
+	migrationsTable = "schema_migrations"
+	// migrationLockId is the advisory lock id held while migrating, so concurrently starting instances don't race
+	migrationLockId = 7310244618
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// UpNoTransaction and DownNoTransaction are set for files marked with "-- migrate:no-transaction"
+	UpNoTransaction   bool
+	DownNoTransaction bool
+}
+
+// LoadPsqlMigrations returns the embedded Postgres migrations
+func LoadPsqlMigrations() ([]Migration, error) {
+	return LoadMigrations(schema.Psql, "psql")
+}
+
+// LoadMigrations reads <version>_<name>.up.sql and .down.sql files from dir, ordered by version.
+// Versions must be contiguous, starting at 1, and every migration must have an up and a down file.
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		match := migrationFileRe.FindStringSubmatch(file.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, path.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, m.Name, match[2])
+		}
+		statements := transactionStatementRe.ReplaceAllString(string(content), "")
+		noTransaction := noTransactionRe.Match(content)
+		if match[3] == "up" {
+			m.Up, m.UpNoTransaction = statements, noTransaction
+		} else {
+			m.Down, m.DownNoTransaction = statements, noTransaction
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("missing migration version %d", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator applies migrations and tracks the schema version in the schema_migrations table
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sqlx.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// LatestVersion is the schema version this build expects
+func (m *Migrator) LatestVersion() int {
+	return len(m.migrations)
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+migrationsTable+` (
+	version integer not null primary key,
+	name text not null,
+	applied_at timestamp with time zone not null default now()
+)`)
+	return err
+}
+
+// Version returns the current schema version, 0 if no migration was applied
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+	var version int
+	err := m.db.GetContext(ctx, &version, `SELECT coalesce(max(version), 0) FROM `+migrationsTable)
+	return version, err
+}
+
+// CheckVersion returns ErrSchemaMismatch unless the database is at the latest version
+func (m *Migrator) CheckVersion(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version != m.LatestVersion() {
+		return errors.Wrapf(ErrSchemaMismatch, "database is at version %d, expected %d", version, m.LatestVersion())
+	}
+	return nil
+}
+
+// Up applies all pending migrations, each in its own transaction unless it is marked otherwise, and returns the
+// applied versions
+func (m *Migrator) Up(ctx context.Context) (applied []int, err error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	for {
+		version, err := m.step(ctx, true)
+		if errors.Is(err, ErrNoMigration) {
+			return applied, nil
+		} else if err != nil {
+			return applied, err
+		}
+		applied = append(applied, version)
+	}
+}
+
+// Down reverts the latest applied migration and returns its version
+func (m *Migrator) Down(ctx context.Context) (int, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+	return m.step(ctx, false)
+}
+
+// Baseline marks all migrations up to version as applied without running them,
+// for databases which were migrated manually before the version table existed.
+func (m *Migrator) Baseline(ctx context.Context, version int) error {
+	if version < 1 || version > m.LatestVersion() {
+		return fmt.Errorf("invalid baseline version %d", version)
+	}
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	for _, migration := range m.migrations[:version] {
+		_, err = tx.ExecContext(ctx, `INSERT INTO `+migrationsTable+` (version, name) VALUES ($1, $2) ON CONFLICT (version) DO NOTHING`, migration.Version, migration.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// step applies the next migration (up) or reverts the current one (down), in a transaction unless the migration
+// file is marked with "-- migrate:no-transaction". A failed migration without transaction may be partly applied,
+// e.g. leave an invalid index behind which must be dropped before it is retried.
+func (m *Migrator) step(ctx context.Context, up bool) (int, error) {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockId); err != nil {
+		return 0, err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockId) //nolint:errcheck
+
+	var current int
+	if err = conn.GetContext(ctx, &current, `SELECT coalesce(max(version), 0) FROM `+migrationsTable); err != nil {
+		return 0, err
+	}
+
+	var (
+		migration     Migration
+		direction     string
+		statements    string
+		noTransaction bool
+		record        func(exec sqlx.ExecerContext) error
+	)
+	if up {
+		if current >= m.LatestVersion() {
+			return 0, ErrNoMigration
+		}
+		migration = m.migrations[current]
+		direction, statements, noTransaction = "up", migration.Up, migration.UpNoTransaction
+		record = func(exec sqlx.ExecerContext) error {
+			_, err := exec.ExecContext(ctx, `INSERT INTO `+migrationsTable+` (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			return err
+		}
+	} else {
+		if current == 0 {
+			return 0, ErrNoMigration
+		}
+		if current > m.LatestVersion() {
+			return 0, errors.Wrapf(ErrSchemaMismatch, "database is at unknown version %d", current)
+		}
+		migration = m.migrations[current-1]
+		direction, statements, noTransaction = "down", migration.Down, migration.DownNoTransaction
+		record = func(exec sqlx.ExecerContext) error {
+			_, err := exec.ExecContext(ctx, `DELETE FROM `+migrationsTable+` WHERE version = $1`, migration.Version)
+			return err
+		}
+	}
+
+	if noTransaction {
+		// Statements sent together run in an implicit transaction, so each one is sent on its own
+		for _, statement := range splitStatements(statements) {
+			if _, err = conn.ExecContext(ctx, statement); err != nil {
+				return 0, errors.Wrapf(err, "migration %d_%s %s failed", migration.Version, migration.Name, direction)
+			}
+		}
+		return migration.Version, record(conn)
+	}
+
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if _, err = tx.ExecContext(ctx, statements); err != nil {
+		return 0, errors.Wrapf(err, "migration %d_%s %s failed", migration.Version, migration.Name, direction)
+	}
+	if err = record(tx); err != nil {
+		return 0, err
+	}
+	return migration.Version, tx.Commit()
+}
+
+// splitStatements splits a migration into its statements, which end with a semicolon at the end of a line
+func splitStatements(statements string) []string {
+	var result []string
+	for _, statement := range statementEndRe.Split(statements, -1) {
+		if strings.TrimSpace(commentLineRe.ReplaceAllString(statement, "")) == "" {
+			continue
+		}
+		result = append(result, strings.TrimSpace(statement))
+	}
+	return result
+}
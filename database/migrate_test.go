@@ -0,0 +1,63 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPsqlMigrations(t *testing.T) {
+	migrations, err := LoadPsqlMigrations()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(migrations), 3)
+	require.Equal(t, "initial.schema", migrations[0].Name)
+	require.Contains(t, migrations[0].Up, "CREATE TABLE rpc_endpoint_requests")
+	require.Contains(t, migrations[2].Up, "is_blocked")
+
+	// The lookup indexes are created concurrently, outside of a transaction
+	require.True(t, migrations[7].UpNoTransaction)
+	require.True(t, migrations[7].DownNoTransaction)
+	require.False(t, migrations[0].UpNoTransaction)
+	require.Len(t, splitStatements(migrations[7].Up), 3)
+
+	// Transaction statements are stripped, the runner wraps each migration in a transaction
+	for _, m := range migrations {
+		require.NotContains(t, strings.ToUpper(m.Up), "BEGIN;")
+		require.NotContains(t, strings.ToUpper(m.Down), "COMMIT;")
+	}
+}
+
+func TestLoadMigrationsValidation(t *testing.T) {
+	_, err := LoadMigrations(fstest.MapFS{
+		"m/001_a.up.sql":   {Data: []byte("select 1;")},
+		"m/001_a.down.sql": {Data: []byte("select 1;")},
+		"m/003_c.up.sql":   {Data: []byte("select 1;")},
+		"m/003_c.down.sql": {Data: []byte("select 1;")},
+	}, "m")
+	require.ErrorContains(t, err, "missing migration version 2")
+
+	_, err = LoadMigrations(fstest.MapFS{
+		"m/001_a.up.sql": {Data: []byte("select 1;")},
+	}, "m")
+	require.ErrorContains(t, err, "must have both up and down files")
+
+	migrations, err := LoadMigrations(fstest.MapFS{
+		"m/002_b.up.sql":   {Data: []byte("select 2;")},
+		"m/002_b.down.sql": {Data: []byte("select 2;")},
+		"m/001_a.up.sql":   {Data: []byte("BEGIN;\nselect 1;\nCOMMIT;")},
+		"m/001_a.down.sql": {Data: []byte("select 1;")},
+		"m/README.md":      {Data: []byte("ignored")},
+	}, "m")
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	require.Equal(t, 1, migrations[0].Version)
+	require.Equal(t, "\nselect 1;\n", migrations[0].Up)
+	require.Equal(t, "b", migrations[1].Name)
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements := splitStatements("-- migrate:no-transaction\n-- a comment\nCREATE INDEX CONCURRENTLY a ON t (x);\n\nDROP INDEX CONCURRENTLY b;\n")
+	require.Equal(t, []string{"-- migrate:no-transaction\n-- a comment\nCREATE INDEX CONCURRENTLY a ON t (x)", "DROP INDEX CONCURRENTLY b"}, statements)
+}
@@ -1,5 +1,7 @@
 package database
 
+import "context"
+
 type mockStore struct{}
 
 func NewMockStore() Store {
@@ -12,3 +14,11 @@ func (m *mockStore) SaveRequestEntry(entry RequestEntry) error {
 func (m *mockStore) SaveRawTxEntries(entries []*EthSendRawTxEntry) error {
 	return nil
 }
+
+func (m *mockStore) GetRawTxEntries(ctx context.Context, filter RawTxEntryFilter) ([]*EthSendRawTxEntry, error) {
+	return nil, nil
+}
+
+func (m *mockStore) CountRawTxEntriesByErrorCode(ctx context.Context, filter RawTxEntryFilter) ([]ErrorCodeCount, error) {
+	return nil, nil
+}
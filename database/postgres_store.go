@@ -2,14 +2,45 @@ package database
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 const (
 	connTimeOut = 10 * time.Second
+
+	insertRequestEntryQuery = `INSERT INTO rpc_endpoint_requests
+	(id, received_at, request_duration_ms, is_batch_request, num_request_in_batch, http_method, http_url, http_query_param, http_response_status, ip_hash, origin, host, chain, error, cancel_reason) VALUES (:id, :received_at, :request_duration_ms, :is_batch_request, :num_request_in_batch, :http_method, :http_url, :http_query_param, :http_response_status, :ip_hash, :origin, :host, :chain, :error, :cancel_reason)`
+	insertRawTxEntryQuery = `INSERT INTO rpc_endpoint_eth_send_raw_txs (id, request_id, is_on_oafc_list, is_white_hat_bundle_collection, white_hat_bundle_id, is_cancel_tx, needs_front_running_protection, was_sent_to_relay, was_sent_to_mempool, is_blocked, error, error_code, tx_raw, tx_hash, tx_from, tx_to, tx_nonce, tx_data, tx_smart_contract_method, fast, origin_id, preset_applied, hints, builders, refund, block_range, auction_timeout, use_mempool, allow_tee) VALUES (:id, :request_id, :is_on_oafc_list, :is_white_hat_bundle_collection, :white_hat_bundle_id, :is_cancel_tx, :needs_front_running_protection, :was_sent_to_relay, :was_sent_to_mempool, :is_blocked, :error, :error_code, :tx_raw, :tx_hash, :tx_from, :tx_to, :tx_nonce, :tx_data, :tx_smart_contract_method, :fast, :origin_id, :preset_applied, :hints, :builders, :refund, :block_range, :auction_timeout, :use_mempool, :allow_tee)`
+	// zero values of outcomes without a receipt are stored as NULL
+	insertInclusionOutcomeQuery = `INSERT INTO rpc_endpoint_tx_inclusions (raw_tx_entry_id, tx_hash, status, block_number, gas_used, effective_gas_price, fee_wei, time_to_inclusion_ms) VALUES (:raw_tx_entry_id, :tx_hash, :status, NULLIF(:block_number, 0), NULLIF(:gas_used, 0), NULLIF(:effective_gas_price, '')::numeric, NULLIF(:fee_wei, '')::numeric, NULLIF(:time_to_inclusion_ms, 0)) ON CONFLICT (raw_tx_entry_id) DO NOTHING RETURNING raw_tx_entry_id`
+	// claims unresolved txs which are not claimed by another tracker, rows locked by a concurrent claim are skipped
+	claimUnresolvedRelayedTxsQuery = `WITH candidates AS (
+		SELECT t.id FROM rpc_endpoint_eth_send_raw_txs t
+		JOIN rpc_endpoint_requests r ON r.id = t.request_id
+		LEFT JOIN rpc_endpoint_tx_inclusions i ON i.raw_tx_entry_id = t.id
+		LEFT JOIN rpc_endpoint_tx_inclusion_claims c ON c.raw_tx_entry_id = t.id
+		WHERE t.was_sent_to_relay AND i.raw_tx_entry_id IS NULL AND r.received_at >= $1 AND COALESCE(r.chain, '') = $2
+			AND (c.claimed_until IS NULL OR c.claimed_until <= $3)
+		ORDER BY c.claimed_until NULLS FIRST, r.received_at
+		LIMIT $5
+		FOR UPDATE OF t SKIP LOCKED
+	), claimed AS (
+		INSERT INTO rpc_endpoint_tx_inclusion_claims AS c (raw_tx_entry_id, claimed_until)
+		SELECT id, $4::timestamptz FROM candidates
+		ON CONFLICT (raw_tx_entry_id) DO UPDATE SET claimed_until = EXCLUDED.claimed_until WHERE c.claimed_until <= $3
+		RETURNING c.raw_tx_entry_id
+	)
+	SELECT t.id AS raw_tx_entry_id, t.tx_hash, coalesce(t.origin_id, '') AS origin_id, r.received_at
+	FROM claimed
+	JOIN rpc_endpoint_eth_send_raw_txs t ON t.id = claimed.raw_tx_entry_id
+	JOIN rpc_endpoint_requests r ON r.id = t.request_id
+	ORDER BY r.received_at`
 )
 
 type postgresStore struct {
@@ -30,19 +61,174 @@ func (d *postgresStore) Close() {
 	d.DB.Close()
 }
 
+func (d *postgresStore) Ping(ctx context.Context) error {
+	return d.DB.PingContext(ctx)
+}
+
 func (d *postgresStore) SaveRequestEntry(entry RequestEntry) error {
-	query := `INSERT INTO rpc_endpoint_requests
-	(id, received_at, request_duration_ms, is_batch_request, num_request_in_batch, http_method, http_url, http_query_param, http_response_status, ip_hash, origin, host, error) VALUES (:id, :received_at, :request_duration_ms, :is_batch_request, :num_request_in_batch, :http_method, :http_url, :http_query_param, :http_response_status, :ip_hash, :origin, :host, :error)`
 	ctx, cancel := context.WithTimeout(context.Background(), connTimeOut)
 	defer cancel()
-	_, err := d.DB.NamedExecContext(ctx, query, entry)
+	_, err := d.DB.NamedExecContext(ctx, insertRequestEntryQuery, entry)
 	return err
 }
 
 func (d *postgresStore) SaveRawTxEntries(entries []*EthSendRawTxEntry) error {
-	query := `INSERT INTO rpc_endpoint_eth_send_raw_txs (id, request_id, is_on_oafc_list, is_white_hat_bundle_collection, white_hat_bundle_id, is_cancel_tx, needs_front_running_protection, was_sent_to_relay, was_sent_to_mempool, is_blocked, error, error_code, tx_raw, tx_hash, tx_from, tx_to, tx_nonce, tx_data, tx_smart_contract_method,fast) VALUES (:id, :request_id, :is_on_oafc_list, :is_white_hat_bundle_collection, :white_hat_bundle_id, :is_cancel_tx, :needs_front_running_protection, :was_sent_to_relay, :was_sent_to_mempool, :is_blocked, :error, :error_code, :tx_raw, :tx_hash, :tx_from, :tx_to, :tx_nonce, :tx_data, :tx_smart_contract_method, :fast)`
 	ctx, cancel := context.WithTimeout(context.Background(), connTimeOut)
 	defer cancel()
-	_, err := d.DB.NamedExecContext(ctx, query, entries)
+	_, err := d.DB.NamedExecContext(ctx, insertRawTxEntryQuery, entries)
 	return err
 }
+
+// SaveBatch inserts all entries of the batch with multi-row inserts in a single transaction
+func (d *postgresStore) SaveBatch(ctx context.Context, batch Batch) error {
+	tx, err := d.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if len(batch.RequestEntries) > 0 {
+		if _, err = tx.NamedExecContext(ctx, insertRequestEntryQuery, batch.RequestEntries); err != nil {
+			return err
+		}
+	}
+	if len(batch.RawTxEntries) > 0 {
+		if _, err = tx.NamedExecContext(ctx, insertRawTxEntryQuery, batch.RawTxEntries); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// rawTxEntryColumns selects all raw tx columns, replacing NULLs of older rows with zero values
+const rawTxEntryColumns = `t.id, t.request_id, t.inserted_at,
+	coalesce(t.is_on_oafc_list, false) AS is_on_oafc_list,
+	coalesce(t.is_white_hat_bundle_collection, false) AS is_white_hat_bundle_collection,
+	coalesce(t.white_hat_bundle_id, '') AS white_hat_bundle_id,
+	coalesce(t.is_cancel_tx, false) AS is_cancel_tx,
+	coalesce(t.needs_front_running_protection, false) AS needs_front_running_protection,
+	coalesce(t.was_sent_to_relay, false) AS was_sent_to_relay,
+	coalesce(t.was_sent_to_mempool, false) AS was_sent_to_mempool,
+	coalesce(t.is_blocked, false) AS is_blocked,
+	coalesce(t.error, '') AS error,
+	coalesce(t.error_code, 0) AS error_code,
+	coalesce(t.tx_raw, '') AS tx_raw,
+	coalesce(t.tx_hash, '') AS tx_hash,
+	coalesce(t.tx_from, '') AS tx_from,
+	coalesce(t.tx_to, '') AS tx_to,
+	coalesce(t.tx_nonce, 0) AS tx_nonce,
+	coalesce(t.tx_data, '') AS tx_data,
+	coalesce(t.tx_smart_contract_method, '') AS tx_smart_contract_method,
+	coalesce(t.fast, false) AS fast,
+	coalesce(t.origin_id, '') AS origin_id,
+	coalesce(t.preset_applied, false) AS preset_applied,
+	coalesce(t.hints, '') AS hints,
+	coalesce(t.builders, '') AS builders,
+	coalesce(t.refund, '') AS refund,
+	coalesce(t.block_range, 0) AS block_range,
+	coalesce(t.auction_timeout, 0) AS auction_timeout,
+	coalesce(t.use_mempool, false) AS use_mempool,
+	coalesce(t.allow_tee, false) AS allow_tee`
+
+// rawTxEntryWhere builds the join and where clause for the filter, with positional arguments.
+// tx_hash and tx_from are saved in lowercase, so they are compared directly to use their indexes.
+func rawTxEntryWhere(filter RawTxEntryFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.TxHash != "" {
+		addCondition("t.tx_hash = $%d", strings.ToLower(filter.TxHash))
+	}
+	if filter.TxFrom != "" {
+		addCondition("t.tx_from = $%d", strings.ToLower(filter.TxFrom))
+	}
+	if filter.Origin != "" {
+		addCondition("r.origin = $%d", filter.Origin)
+	}
+	if filter.OriginId != "" {
+		addCondition("t.origin_id = $%d", filter.OriginId)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("r.received_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		addCondition("r.received_at < $%d", filter.Until)
+	}
+
+	clause := " FROM rpc_endpoint_eth_send_raw_txs t JOIN rpc_endpoint_requests r ON r.id = t.request_id"
+	if len(conditions) > 0 {
+		clause += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return clause, args
+}
+
+func (d *postgresStore) GetRawTxEntries(ctx context.Context, filter RawTxEntryFilter) ([]*EthSendRawTxEntry, error) {
+	where, args := rawTxEntryWhere(filter)
+	query := fmt.Sprintf("SELECT %s%s ORDER BY r.received_at DESC LIMIT %d", rawTxEntryColumns, where, filter.EffectiveLimit())
+	ctx, cancel := context.WithTimeout(ctx, connTimeOut)
+	defer cancel()
+	var entries []*EthSendRawTxEntry
+	err := d.DB.SelectContext(ctx, &entries, query, args...)
+	return entries, err
+}
+
+func (d *postgresStore) CountRawTxEntriesByErrorCode(ctx context.Context, filter RawTxEntryFilter) ([]ErrorCodeCount, error) {
+	where, args := rawTxEntryWhere(filter)
+	query := fmt.Sprintf("SELECT coalesce(t.error_code, 0) AS error_code, count(*) AS count%s GROUP BY 1 ORDER BY 1", where)
+	ctx, cancel := context.WithTimeout(ctx, connTimeOut)
+	defer cancel()
+	var counts []ErrorCodeCount
+	err := d.DB.SelectContext(ctx, &counts, query, args...)
+	return counts, err
+}
+
+func (d *postgresStore) ClaimUnresolvedRelayedTxs(ctx context.Context, chain string, since, now, leaseUntil time.Time, limit int) ([]RelayedTx, error) {
+	ctx, cancel := context.WithTimeout(ctx, connTimeOut)
+	defer cancel()
+	var txs []RelayedTx
+	err := d.DB.SelectContext(ctx, &txs, claimUnresolvedRelayedTxsQuery, since, chain, now, leaseUntil, limit)
+	return txs, err
+}
+
+func (d *postgresStore) SaveInclusionOutcomes(ctx context.Context, outcomes []InclusionOutcome) ([]uuid.UUID, error) {
+	if len(outcomes) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, connTimeOut)
+	defer cancel()
+	tx, err := d.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	rows, err := sqlx.NamedQueryContext(ctx, tx, insertInclusionOutcomeQuery, outcomes)
+	if err != nil {
+		return nil, err
+	}
+	var inserted []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		inserted = append(inserted, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(outcomes))
+	for i, outcome := range outcomes {
+		ids[i] = outcome.RawTxEntryId.String()
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM rpc_endpoint_tx_inclusion_claims WHERE raw_tx_entry_id = ANY($1::uuid[])`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	return inserted, tx.Commit()
+}
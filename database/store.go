@@ -1,6 +1,103 @@
 package database
 
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
 type Store interface {
 	SaveRequestEntry(entry RequestEntry) error
 	SaveRawTxEntries(entries []*EthSendRawTxEntry) error
+	Reader
+}
+
+// Reader queries saved raw tx entries
+type Reader interface {
+	// GetRawTxEntries returns the latest raw tx entries matching the filter, newest first
+	GetRawTxEntries(ctx context.Context, filter RawTxEntryFilter) ([]*EthSendRawTxEntry, error)
+	// CountRawTxEntriesByErrorCode returns the number of raw tx entries matching the filter per error code
+	CountRawTxEntriesByErrorCode(ctx context.Context, filter RawTxEntryFilter) ([]ErrorCodeCount, error)
+}
+
+// RawTxEntryFilter selects raw tx entries, empty fields match all entries.
+// Since and Until are matched against the time the request was received.
+type RawTxEntryFilter struct {
+	TxHash   string
+	TxFrom   string
+	Origin   string
+	OriginId string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// EffectiveLimit returns the limit clamped to MaxQueryLimit, or DefaultQueryLimit if not set
+func (f RawTxEntryFilter) EffectiveLimit() int {
+	if f.Limit <= 0 {
+		return DefaultQueryLimit
+	}
+	if f.Limit > MaxQueryLimit {
+		return MaxQueryLimit
+	}
+	return f.Limit
+}
+
+type ErrorCodeCount struct {
+	ErrorCode int   `db:"error_code" json:"errorCode"`
+	Count     int64 `db:"count" json:"count"`
+}
+
+// Pinger is implemented by stores backed by a remote database, so readiness checks can verify connectivity
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// RecordStore is implemented by stores which save a request entry together with its raw tx entries atomically
+type RecordStore interface {
+	SaveRecord(entry RequestEntry, rawTxEntries []*EthSendRawTxEntry) error
+}
+
+// Stopper is implemented by stores which buffer entries in the background, Stop writes the pending entries
+// and must be called before shutdown
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+// Batch is a set of request entries and raw tx entries which are written in a single transaction
+type Batch struct {
+	RequestEntries []RequestEntry
+	RawTxEntries   []*EthSendRawTxEntry
+}
+
+func (b *Batch) Len() int {
+	return len(b.RequestEntries) + len(b.RawTxEntries)
+}
+
+// BatchSaver writes all entries of a batch atomically
+type BatchSaver interface {
+	SaveBatch(ctx context.Context, batch Batch) error
+}
+
+// BatchStore is a store which is written in batches
+type BatchStore interface {
+	BatchSaver
+	Reader
+}
+
+// InclusionStore is implemented by stores which track the on-chain outcome of relayed txs
+type InclusionStore interface {
+	// ClaimUnresolvedRelayedTxs returns up to limit txs of the chain sent to the relay since the given time without an
+	// inclusion outcome, and claims them until leaseUntil so trackers of other replicas skip them. Txs which were never
+	// claimed come first, then the ones claimed longest ago, so unresolvable txs can't starve newer ones.
+	ClaimUnresolvedRelayedTxs(ctx context.Context, chain string, since, now, leaseUntil time.Time, limit int) ([]RelayedTx, error)
+	// SaveInclusionOutcomes saves the outcomes and releases their claims. It returns the raw tx entry ids of the
+	// outcomes which were inserted, outcomes saved before are skipped.
+	SaveInclusionOutcomes(ctx context.Context, outcomes []InclusionOutcome) ([]uuid.UUID, error)
 }
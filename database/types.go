@@ -18,9 +18,12 @@ type RequestEntry struct {
 	HttpUrl            string    `db:"http_url"`
 	HttpQueryParam     string    `db:"http_query_param"`
 	HttpResponseStatus int       `db:"http_response_status"`
+	IpHash             string    `db:"ip_hash"` // hourly rotating fingerprint, not reversible to the client IP
 	Origin             string    `db:"origin"`
 	Host               string    `db:"host"`
+	Chain              string    `db:"chain"` // name of the routed chain, empty for the default chain
 	Error              string    `db:"error"`
+	CancelReason       string    `db:"cancel_reason"` // set if the request was cancelled, e.g. "timeout" or "client_gone"
 }
 
 // EthSendRawTxEntry to store each eth_sendRawTransaction calls
@@ -46,4 +49,44 @@ type EthSendRawTxEntry struct {
 	TxData                      string    `db:"tx_data"`
 	TxSmartContractMethod       string    `db:"tx_smart_contract_method"`
 	Fast                        bool      `db:"fast"` // If set, fast preference gets called
+
+	// Resolved URL preferences of the request
+	OriginId       string `db:"origin_id"`
+	PresetApplied  bool   `db:"preset_applied"` // If set, preferences came from a customer preset instead of the URL
+	Hints          string `db:"hints"`          // comma separated
+	Builders       string `db:"builders"`       // comma separated
+	Refund         string `db:"refund"`         // comma separated address:percent
+	BlockRange     int    `db:"block_range"`
+	AuctionTimeout int64  `db:"auction_timeout"`
+	UseMempool     bool   `db:"use_mempool"`
+	AllowTEE       bool   `db:"allow_tee"`
+}
+
+// RelayedTx is a raw tx entry which was sent to the relay, pending an inclusion outcome
+type RelayedTx struct {
+	RawTxEntryId uuid.UUID `db:"raw_tx_entry_id"`
+	TxHash       string    `db:"tx_hash"`
+	OriginId     string    `db:"origin_id"`
+	ReceivedAt   time.Time `db:"received_at"`
+}
+
+type InclusionStatus string
+
+const (
+	InclusionStatusIncluded InclusionStatus = "included"
+	InclusionStatusReverted InclusionStatus = "reverted" // included, but execution failed
+	InclusionStatusFailed   InclusionStatus = "failed"   // reported as failed by the tx status api
+	InclusionStatusExpired  InclusionStatus = "expired"  // not included within the tracking window
+)
+
+// InclusionOutcome is the on-chain outcome of a relayed tx, keyed by the id of its EthSendRawTxEntry
+type InclusionOutcome struct {
+	RawTxEntryId      uuid.UUID       `db:"raw_tx_entry_id"`
+	TxHash            string          `db:"tx_hash"`
+	Status            InclusionStatus `db:"status"`
+	BlockNumber       int64           `db:"block_number"`
+	GasUsed           int64           `db:"gas_used"`
+	EffectiveGasPrice string          `db:"effective_gas_price"` // wei
+	FeeWei            string          `db:"fee_wei"`             // gas used * effective gas price
+	TimeToInclusionMs int64           `db:"time_to_inclusion_ms"`
 }
@@ -1,19 +1,21 @@
 package metrics
 
-import "github.com/VictoriaMetrics/metrics"
+import (
+	"fmt"
 
-// we might want to use getOrCreate instead of newCounter to dynamically handle all http statused with less code
-// used http statuses are limited (4) so it is safer and better in terms of performance
-var (
-	statusOK                  = metrics.NewCounter(`http_requests_total{status="200"}`)
-	statusBadRequest          = metrics.NewCounter(`http_requests_total{status="400"}`)
-	statusNotFound            = metrics.NewCounter(`http_requests_total{status="404"}`)
-	statusInternalServerError = metrics.NewCounter(`http_requests_total{status="500"}`)
-	UrlParamUsage             = metrics.NewCounter(`http_requests_params{name="url"}`)
+	"github.com/VictoriaMetrics/metrics"
 )
 
-func StatusOKInc()                  { statusOK.Inc() }
-func StatusBadRequestInc()          { statusBadRequest.Inc() }
-func StatusNotFoundInc()            { statusNotFound.Inc() }
-func StatusInternalServerErrorInc() { statusInternalServerError.Inc() }
-func UrlParamUsageInc()             { UrlParamUsage.Inc() }
+var UrlParamUsage = metrics.NewCounter(`http_requests_params{name="url"}`)
+
+func UrlParamUsageInc() { UrlParamUsage.Inc() }
+
+// IncHttpRequest counts served HTTP requests by status code
+func IncHttpRequest(status int) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`http_requests_total{status="%d"}`, status)).Inc()
+}
+
+// IncRequestCancelled counts requests which were cancelled before they were processed, per reason
+func IncRequestCancelled(reason string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`http_requests_cancelled_total{reason=%q}`, reason)).Inc()
+}
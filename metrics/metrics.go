@@ -8,21 +8,32 @@ import (
 )
 
 var (
-	databaseErr = metrics.NewCounter("postgres_error_total")
-	redisErr    = metrics.NewCounter("redis_error_total")
-	ethNodeErr  = metrics.NewCounter("eth_node_cluster_error_total")
+	databaseErr            = metrics.NewCounter("postgres_error_total")
+	databaseDroppedEntries = metrics.NewCounter("postgres_dropped_entries_total")
+	redisErr               = metrics.NewCounter("redis_error_total")
+	ethNodeErr             = metrics.NewCounter("eth_node_cluster_error_total")
 
 	rpcNodeProxyClientErr = metrics.NewCounter("rpc_node_proxy_client_error_total")
 	rpcNodeProxyServerErr = metrics.NewCounter("rpc_node_proxy_server_error_total")
 
 	relayServerErr = metrics.NewCounter("relay_server_error_total")
 	relayClientErr = metrics.NewCounter("relay_client_error_total")
+
+	recordQueueDepth = metrics.NewGauge("request_record_queue_depth", nil)
+	recordDropped    = metrics.NewCounter("request_record_dropped_total")
+
+	auditRecordErr     = metrics.NewCounter("audit_record_error_total")
+	auditRecordDropped = metrics.NewCounter("audit_record_dropped_total")
 )
 
 func IncDatabaseErr() {
 	databaseErr.Inc()
 }
 
+func AddDatabaseDroppedEntries(n int) {
+	databaseDroppedEntries.Add(n)
+}
+
 func IncRedisErr() {
 	redisErr.Inc()
 }
@@ -49,6 +60,28 @@ func IncRelayClientErr() {
 	relayClientErr.Inc()
 }
 
+func SetRecordQueueDepth(depth int) {
+	recordQueueDepth.Set(float64(depth))
+}
+
+func IncRecordDropped() {
+	recordDropped.Inc()
+}
+
+func AddRecordDropped(n int) {
+	recordDropped.Add(n)
+}
+
+// IncAuditRecordErr counts decision records which could not be written to the audit sink
+func IncAuditRecordErr() {
+	auditRecordErr.Inc()
+}
+
+// IncAuditRecordDropped counts decision records dropped because the audit sink is behind
+func IncAuditRecordDropped() {
+	auditRecordDropped.Inc()
+}
+
 func DefaultServer(addr string) *http.Server {
 	metricsMux := http.NewServeMux()
 	metricsMux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// OtherLabel replaces label values which are not in a known set, to bound the number of series
+const OtherLabel = "other"
+
+// knownRpcMethods are the methods which get their own series, clients can send any method name
+var knownRpcMethods = map[string]bool{}
+
+func init() {
+	for _, method := range []string{
+		"eth_accounts", "eth_blobBaseFee", "eth_blockNumber", "eth_call", "eth_chainId", "eth_coinbase",
+		"eth_createAccessList", "eth_estimateGas", "eth_feeHistory", "eth_gasPrice", "eth_getBalance",
+		"eth_getBlockByHash", "eth_getBlockByNumber", "eth_getBlockReceipts", "eth_getBlockTransactionCountByHash",
+		"eth_getBlockTransactionCountByNumber", "eth_getCode", "eth_getFilterChanges", "eth_getFilterLogs",
+		"eth_getLogs", "eth_getProof", "eth_getStorageAt", "eth_getTransactionByBlockHashAndIndex",
+		"eth_getTransactionByBlockNumberAndIndex", "eth_getTransactionByHash", "eth_getTransactionCount",
+		"eth_getTransactionReceipt", "eth_getUncleCountByBlockHash", "eth_getUncleCountByBlockNumber",
+		"eth_maxPriorityFeePerGas", "eth_newBlockFilter", "eth_newFilter", "eth_newPendingTransactionFilter",
+		"eth_sendPrivateTransaction", "eth_sendRawTransaction", "eth_sendTransaction", "eth_sign",
+		"eth_signTransaction", "eth_syncing", "eth_uninstallFilter",
+		"net_listening", "net_peerCount", "net_version", "web3_clientVersion", "web3_sha3",
+	} {
+		knownRpcMethods[method] = true
+	}
+}
+
+// RpcMethodLabel returns the method, or OtherLabel if it is not a known JSON-RPC method
+func RpcMethodLabel(method string) string {
+	if knownRpcMethods[method] {
+		return method
+	}
+	return OtherLabel
+}
+
+// ObserveRpcRequest counts a JSON-RPC request and records its duration, by method and whether the response was
+// an error. The method must be a label from RpcMethodLabel.
+func ObserveRpcRequest(method string, isError bool, d time.Duration) {
+	result := "success"
+	if isError {
+		result = "error"
+	}
+	metrics.GetOrCreateCounter(fmt.Sprintf(`rpc_requests_total{method=%q,result=%q}`, method, result)).Inc()
+	metrics.GetOrCreatePrometheusHistogram(fmt.Sprintf(`rpc_request_duration_seconds{method=%q}`, method)).Update(d.Seconds())
+}
+
+// ObserveProxyRequest records the duration of a request proxied to a node, by upstream and whether it failed
+func ObserveProxyRequest(upstream string, failed bool, d time.Duration) {
+	metrics.GetOrCreatePrometheusHistogram(fmt.Sprintf(`rpc_proxy_duration_seconds{upstream=%q,failed="%s"}`, upstream, strconv.FormatBool(failed))).Update(d.Seconds())
+}
+
+// ObserveRelayRequest records the duration of a relay call, by method and result: success or an error class
+func ObserveRelayRequest(method, result string, d time.Duration) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`relay_requests_total{method=%q,result=%q}`, method, result)).Inc()
+	metrics.GetOrCreatePrometheusHistogram(fmt.Sprintf(`relay_request_duration_seconds{method=%q}`, method)).Update(d.Seconds())
+}
+
+// IncPrivateTxOutcome counts private txs by origin and outcome. The origin must be bounded, e.g. to the
+// configured customers.
+func IncPrivateTxOutcome(origin, outcome string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`private_tx_outcome_total{origin=%q,outcome=%q}`, origin, outcome)).Inc()
+}
@@ -1,6 +1,11 @@
 package metrics
 
-import "github.com/VictoriaMetrics/metrics"
+import (
+	"fmt"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
 
 var (
 	privateTx = metrics.NewCounter("private_tx_total")
@@ -9,3 +14,28 @@ var (
 func IncPrivateTx() {
 	privateTx.Inc()
 }
+
+// IncTxRejected counts raw txs rejected before processing, per reason
+func IncTxRejected(reason string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`tx_rejected_total{reason=%q}`, reason)).Inc()
+}
+
+// IncMempoolBroadcastSkipped counts scheduled mempool broadcasts which were not sent, per reason
+func IncMempoolBroadcastSkipped(reason string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`mempool_broadcast_skipped_total{reason=%q}`, reason)).Inc()
+}
+
+// inclusionLatencyBuckets range from one to a few hundred blocks
+var inclusionLatencyBuckets = []float64{6, 12, 24, 36, 60, 120, 300, 600, 1800, 3600}
+
+var inclusionLatency = metrics.NewPrometheusHistogramExt("tx_inclusion_latency_seconds", inclusionLatencyBuckets)
+
+// ObserveInclusionLatency records the time from receiving a tx to the timestamp of the block including it
+func ObserveInclusionLatency(d time.Duration) {
+	inclusionLatency.Update(d.Seconds())
+}
+
+// IncInclusionOutcome counts resolved relayed txs per outcome status
+func IncInclusionOutcome(status string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`tx_inclusion_outcome_total{status=%q}`, status)).Inc()
+}
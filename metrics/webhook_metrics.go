@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// IncWebhookEvent counts lifecycle events queued for delivery to the webhook of an origin
+func IncWebhookEvent(origin, event string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`webhook_events_total{origin=%q,event=%q}`, origin, event)).Inc()
+}
+
+// ObserveWebhookDelivery counts a delivery attempt by origin and result: success, retry, failed or dropped,
+// and records its duration if the webhook was called
+func ObserveWebhookDelivery(origin, result string, d time.Duration) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`webhook_deliveries_total{origin=%q,result=%q}`, origin, result)).Inc()
+	if d > 0 {
+		metrics.GetOrCreatePrometheusHistogram(fmt.Sprintf(`webhook_delivery_duration_seconds{origin=%q}`, origin)).Update(d.Seconds())
+	}
+}
+
+// IncWebhookQueueErr counts failed reads and writes of the webhook delivery queue
+func IncWebhookQueueErr() {
+	metrics.GetOrCreateCounter(`webhook_queue_error_total`).Inc()
+}
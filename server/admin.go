@@ -0,0 +1,392 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/flashbots/rpc-endpoint/metrics"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrAdminTokenRequired   = errors.New("admin token or client CA is required when the admin listener is enabled")
+	ErrAdminTLSRequired     = errors.New("admin TLS certificate and key are required with a client CA")
+	ErrNoCustomerConfigFile = errors.New("no customer config file is set")
+)
+
+// newAdminTLSConfig checks the auth of the admin listener, and returns its TLS config if client certificates are
+// required
+func newAdminTLSConfig(cfg Configuration) (*tls.Config, error) {
+	if cfg.AdminAddress == "" {
+		return nil, nil
+	}
+	if cfg.AdminToken == "" && cfg.AdminClientCAFile == "" {
+		return nil, ErrAdminTokenRequired
+	}
+	if (cfg.AdminTLSCertFile == "") != (cfg.AdminTLSKeyFile == "") || (cfg.AdminClientCAFile != "" && cfg.AdminTLSCertFile == "") {
+		return nil, ErrAdminTLSRequired
+	}
+	if cfg.AdminClientCAFile == "" {
+		return nil, nil
+	}
+	caPEM, err := os.ReadFile(cfg.AdminClientCAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading admin client CA failed")
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("admin client CA contains no certificates")
+	}
+	return &tls.Config{
+		ClientCAs:  clientCAs,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// adminAuthMiddleware rejects requests without the expected bearer token
+func adminAuthMiddleware(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(respw http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		bearer, found := strings.CutPrefix(auth, "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			respw.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(respw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(respw, req)
+	})
+}
+
+func (s *RpcEndPointServer) startAdminServer() {
+	if s.adminAddress == "" {
+		return
+	}
+	if s.admin != nil {
+		panic("admin http server is already running")
+	}
+	var handler http.Handler = s.adminMux()
+	if s.adminToken != "" {
+		handler = adminAuthMiddleware(s.adminToken, handler)
+	}
+	s.admin = &http.Server{
+		Addr:              s.adminAddress,
+		Handler:           handler,
+		TLSConfig:         s.adminTLSConfig,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		var err error
+		if s.adminTLSCertFile != "" {
+			err = s.admin.ListenAndServeTLS(s.adminTLSCertFile, s.adminTLSKeyFile)
+		} else {
+			err = s.admin.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("admin http server failed", "error", err)
+		}
+	}()
+}
+
+func (s *RpcEndPointServer) adminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/txs", s.handleAdminGetTxs)
+	mux.HandleFunc("/txs/errors", s.handleAdminGetTxErrorCounts)
+	mux.HandleFunc("POST /drain", s.handleDrainRequest)
+	mux.HandleFunc("POST /undrain", s.handleUndrainRequest)
+	mux.HandleFunc("GET /config/customers", s.handleAdminGetCustomerConfig)
+	mux.HandleFunc("POST /config/reload", s.handleAdminReloadCustomerConfig)
+	mux.HandleFunc("GET /builders", s.handleAdminGetBuilders)
+	mux.HandleFunc("GET /state/tx", s.handleAdminGetTxState)
+	mux.HandleFunc("GET /state/sender", s.handleAdminGetSenderState)
+	mux.HandleFunc("DELETE /state/sender/nonce-fix", s.handleAdminDelNonceFix)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+func (s *RpcEndPointServer) stopAdminServer() {
+	if s.admin != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.admin.Shutdown(ctx); err != nil {
+			s.logger.Error("admin http server shutdown failed", "error", err)
+		}
+		s.logger.Info("admin http server stopped")
+		s.admin = nil
+	}
+}
+
+// handleAdminGetTxs answers "did this tx reach you?", e.g. GET /txs?hash=0x...
+func (s *RpcEndPointServer) handleAdminGetTxs(respw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		respw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseRawTxEntryFilter(req)
+	if err != nil {
+		http.Error(respw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := s.db.GetRawTxEntries(req.Context(), filter)
+	if err != nil {
+		s.logger.Error("[admin] GetRawTxEntries failed", "error", err)
+		respw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []*database.EthSendRawTxEntry{}
+	}
+	writeJson(respw, map[string]interface{}{"entries": entries})
+}
+
+func (s *RpcEndPointServer) handleAdminGetTxErrorCounts(respw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		respw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseRawTxEntryFilter(req)
+	if err != nil {
+		http.Error(respw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	counts, err := s.db.CountRawTxEntriesByErrorCode(req.Context(), filter)
+	if err != nil {
+		s.logger.Error("[admin] CountRawTxEntriesByErrorCode failed", "error", err)
+		respw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if counts == nil {
+		counts = []database.ErrorCodeCount{}
+	}
+	writeJson(respw, map[string]interface{}{"counts": counts})
+}
+
+func (s *RpcEndPointServer) handleAdminGetCustomerConfig(respw http.ResponseWriter, req *http.Request) {
+	watcher := s.configurationWatcher.Load()
+	if watcher == nil {
+		writeJson(respw, CustomersConfig{})
+		return
+	}
+	writeJson(respw, watcher.Config)
+}
+
+func (s *RpcEndPointServer) handleAdminReloadCustomerConfig(respw http.ResponseWriter, req *http.Request) {
+	watcher, err := s.reloadCustomerConfig()
+	if errors.Is(err, ErrNoCustomerConfigFile) {
+		http.Error(respw, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		s.logger.Error("[admin] Reloading customer config failed", "error", err)
+		http.Error(respw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(respw, map[string]interface{}{"customers": len(watcher.ParsedCustomersConfig), "presets": len(watcher.ParsedPresets), "webhooks": len(watcher.Config.Webhooks)})
+}
+
+// reloadCustomerConfig reads the customer config file again, requests which already started keep the old config
+func (s *RpcEndPointServer) reloadCustomerConfig() (*ConfigurationWatcher, error) {
+	if s.customerConfigFile == "" {
+		return nil, ErrNoCustomerConfigFile
+	}
+	watcher, err := ReadCustomerConfigFromFile(s.customerConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	metrics.InitCustomersConfigMetric(watcher.Customers()...)
+	s.configurationWatcher.Store(watcher)
+	s.webhookNotifier.SetWebhooks(watcher.Config.Webhooks)
+	s.logger.Info("[admin] Customer config reloaded", "file", s.customerConfigFile, "customers", len(watcher.ParsedCustomersConfig))
+	return watcher, nil
+}
+
+type adminBuilderRegistry struct {
+	Chain       string     `json:"chain"`
+	Builders    []string   `json:"builders"`
+	LastFetched *time.Time `json:"lastFetched,omitempty"` // not set if the registry isn't fetched
+}
+
+func (s *RpcEndPointServer) handleAdminGetBuilders(respw http.ResponseWriter, req *http.Request) {
+	registries := []adminBuilderRegistry{}
+	for _, chain := range s.chains.chains() {
+		registry := adminBuilderRegistry{Chain: chain.displayName, Builders: chain.builderNameProvider.BuilderNames()}
+		if ageProvider, ok := chain.builderNameProvider.(BuilderRegistryAgeProvider); ok {
+			if lastFetched, enabled := ageProvider.LastFetched(); enabled && !lastFetched.IsZero() {
+				registry.LastFetched = &lastFetched
+			}
+		}
+		registries = append(registries, registry)
+	}
+	writeJson(respw, map[string]interface{}{"registries": registries})
+}
+
+// adminTxState is the state of a tx hash, missing entries are omitted
+type adminTxState struct {
+	TxHash             string     `json:"txHash"`
+	SentToRelayAt      *time.Time `json:"sentToRelayAt,omitempty"`
+	Sender             string     `json:"sender,omitempty"`
+	Nonce              *uint64    `json:"nonce,omitempty"`
+	BlockedReturnValue *string    `json:"blockedReturnValue,omitempty"`
+}
+
+// handleAdminGetTxState shows the Redis entries of a tx, e.g. GET /state/tx?hash=0x...&chain=sepolia
+func (s *RpcEndPointServer) handleAdminGetTxState(respw http.ResponseWriter, req *http.Request) {
+	chain, ok := s.adminChain(respw, req)
+	if !ok {
+		return
+	}
+	ctx, txHash := req.Context(), req.URL.Query().Get("hash")
+	if txHash == "" {
+		http.Error(respw, "hash is required", http.StatusBadRequest)
+		return
+	}
+	res := adminTxState{TxHash: txHash}
+	sentAt, found, err := chain.state.GetTxSentToRelay(ctx, txHash)
+	if found {
+		res.SentToRelayAt = &sentAt
+	}
+	if err == nil {
+		res.Sender, _, err = chain.state.GetSenderOfTxHash(ctx, txHash)
+	}
+	if err == nil {
+		var nonce uint64
+		if nonce, found, err = chain.state.GetNonceOfTxHash(ctx, txHash); found {
+			res.Nonce = &nonce
+		}
+	}
+	if err == nil {
+		var returnValue string
+		if returnValue, found, err = chain.state.GetBlockedTxHash(ctx, txHash); found {
+			res.BlockedReturnValue = &returnValue
+		}
+	}
+	if err != nil {
+		s.logger.Error("[admin] Reading tx state failed", "txHash", txHash, "error", err)
+		respw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJson(respw, res)
+}
+
+// adminSenderState is the state of a sender, missing entries are omitted
+type adminSenderState struct {
+	Address           string  `json:"address"`
+	NonceFixTimesSent *uint64 `json:"nonceFixTimesSent,omitempty"`
+	MaxNonce          *uint64 `json:"maxNonce,omitempty"`
+}
+
+// handleAdminGetSenderState shows the Redis entries of a sender, e.g. GET /state/sender?address=0x...
+func (s *RpcEndPointServer) handleAdminGetSenderState(respw http.ResponseWriter, req *http.Request) {
+	chain, ok := s.adminChain(respw, req)
+	if !ok {
+		return
+	}
+	ctx, address := req.Context(), req.URL.Query().Get("address")
+	if address == "" {
+		http.Error(respw, "address is required", http.StatusBadRequest)
+		return
+	}
+	res := adminSenderState{Address: address}
+	timesSent, found, err := chain.state.GetNonceFixForAccount(ctx, address)
+	if found {
+		res.NonceFixTimesSent = &timesSent
+	}
+	if err == nil {
+		var maxNonce uint64
+		if maxNonce, found, err = chain.state.GetSenderMaxNonce(ctx, address); found {
+			res.MaxNonce = &maxNonce
+		}
+	}
+	if err != nil {
+		s.logger.Error("[admin] Reading sender state failed", "address", address, "error", err)
+		respw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJson(respw, res)
+}
+
+// handleAdminDelNonceFix clears the nonce fix of a sender, e.g. DELETE /state/sender/nonce-fix?address=0x...
+func (s *RpcEndPointServer) handleAdminDelNonceFix(respw http.ResponseWriter, req *http.Request) {
+	chain, ok := s.adminChain(respw, req)
+	if !ok {
+		return
+	}
+	address := req.URL.Query().Get("address")
+	if address == "" {
+		http.Error(respw, "address is required", http.StatusBadRequest)
+		return
+	}
+	if err := chain.state.DelNonceFixForAccount(req.Context(), address); err != nil {
+		s.logger.Error("[admin] DelNonceFixForAccount failed", "address", address, "error", err)
+		respw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("[admin] Nonce fix cleared", "address", address, "chain", chain.name)
+	respw.WriteHeader(http.StatusNoContent)
+}
+
+// adminChain returns the chain of the chain query param, the default chain if it is empty
+func (s *RpcEndPointServer) adminChain(respw http.ResponseWriter, req *http.Request) (*Chain, bool) {
+	name := req.URL.Query().Get("chain")
+	chain, ok := s.chains.chain(name)
+	if !ok {
+		http.Error(respw, "unknown chain "+name, http.StatusNotFound)
+	}
+	return chain, ok
+}
+
+// parseRawTxEntryFilter reads the filter from the query params hash, from, origin, originId, since, until and limit.
+// Times are either RFC3339 or unix seconds.
+func parseRawTxEntryFilter(req *http.Request) (filter database.RawTxEntryFilter, err error) {
+	query := req.URL.Query()
+	filter.TxHash = query.Get("hash")
+	filter.TxFrom = query.Get("from")
+	filter.Origin = query.Get("origin")
+	filter.OriginId = query.Get("originId")
+	if filter.Since, err = parseAdminTime(query.Get("since")); err != nil {
+		return filter, errors.Wrap(err, "invalid since")
+	}
+	if filter.Until, err = parseAdminTime(query.Get("until")); err != nil {
+		return filter, errors.Wrap(err, "invalid until")
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return filter, errors.Wrap(err, "invalid limit")
+		}
+	}
+	return filter, nil
+}
+
+func parseAdminTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func writeJson(respw http.ResponseWriter, res interface{}) {
+	jsonResp, err := json.Marshal(res)
+	if err != nil {
+		respw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	respw.Header().Set("Content-Type", "application/json")
+	respw.WriteHeader(http.StatusOK)
+	respw.Write(jsonResp)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminAuth(t *testing.T) {
+	handler := adminAuthMiddleware("secret", http.HandlerFunc(func(respw http.ResponseWriter, req *http.Request) {
+		respw.WriteHeader(http.StatusOK)
+	}))
+
+	for token, expectedStatus := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/txs", nil)
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, expectedStatus, rec.Code, token)
+	}
+}
+
+func TestAdminGetTxs(t *testing.T) {
+	memStore := database.NewMemStore()
+	requestId := uuid.New()
+	require.NoError(t, memStore.SaveRequestEntry(database.RequestEntry{Id: requestId}))
+	require.NoError(t, memStore.SaveRawTxEntries([]*database.EthSendRawTxEntry{
+		{Id: uuid.New(), RequestId: requestId, TxHash: "0xaa", WasSentToRelay: true},
+		{Id: uuid.New(), RequestId: requestId, TxHash: "0xbb", ErrorCode: -32600},
+	}))
+	s := &RpcEndPointServer{db: memStore, logger: log.New()}
+
+	rec := httptest.NewRecorder()
+	s.handleAdminGetTxs(rec, httptest.NewRequest(http.MethodGet, "/txs?hash=0xaa", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var txsRes struct {
+		Entries []database.EthSendRawTxEntry `json:"entries"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &txsRes))
+	require.Len(t, txsRes.Entries, 1)
+	require.True(t, txsRes.Entries[0].WasSentToRelay)
+
+	rec = httptest.NewRecorder()
+	s.handleAdminGetTxErrorCounts(rec, httptest.NewRequest(http.MethodGet, "/txs/errors", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var countsRes struct {
+		Counts []database.ErrorCodeCount `json:"counts"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &countsRes))
+	require.Len(t, countsRes.Counts, 2)
+
+	rec = httptest.NewRecorder()
+	s.handleAdminGetTxs(rec, httptest.NewRequest(http.MethodGet, "/txs?since=yesterday", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAdminState(t *testing.T) {
+	ctx := context.Background()
+	state, err := NewMemState(DefaultStateOptions)
+	require.NoError(t, err)
+	require.NoError(t, state.SetTxSentToRelay(ctx, "0xaa"))
+	require.NoError(t, state.SetSenderAndNonceOfTxHash(ctx, "0xaa", "0xSender", 7))
+	require.NoError(t, state.SetNonceFixForAccount(ctx, "0xSender", 2))
+	s := &RpcEndPointServer{logger: log.New(), chains: newChainRouter(&Chain{state: state, builderNameProvider: staticBuilderNames{"flashbots"}})}
+	mux := s.adminMux()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/state/tx?hash=0xAA", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var txState adminTxState
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &txState))
+	require.NotNil(t, txState.SentToRelayAt)
+	require.Equal(t, "0xsender", txState.Sender)
+	require.Equal(t, uint64(7), *txState.Nonce)
+	require.Nil(t, txState.BlockedReturnValue)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/state/sender/nonce-fix?address=0xsender", nil))
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/state/sender?address=0xSender", nil))
+	var senderState adminSenderState
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &senderState))
+	require.Nil(t, senderState.NonceFixTimesSent)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/state/tx?hash=0xaa&chain=unknown", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/builders", nil))
+	require.Contains(t, rec.Body.String(), `"builders":["flashbots"]`)
+}
+
+func TestAdminDrainAndReloadCustomerConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "customers.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte("urls:\n  quicknode:\n    - /fast?originId=quicknode\n"), 0o600))
+	s := &RpcEndPointServer{logger: log.New(), drain: &DrainState{}, customerConfigFile: configFile}
+	mux := s.adminMux()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/drain", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, s.drain.IsDraining())
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/undrain", nil))
+	require.False(t, s.drain.IsDraining())
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/config/reload", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "quicknode", s.configurationWatcher.Load().OriginLabel("quicknode"))
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config/customers", nil))
+	require.JSONEq(t, `{"urls":{"quicknode":["/fast?originId=quicknode"]}}`, rec.Body.String())
+
+	// An invalid file keeps the current config
+	require.NoError(t, os.WriteFile(configFile, []byte("urls: ["), 0o600))
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/config/reload", nil))
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Equal(t, "quicknode", s.configurationWatcher.Load().OriginLabel("quicknode"))
+}
+
+func TestNewAdminTLSConfig(t *testing.T) {
+	_, err := newAdminTLSConfig(Configuration{AdminAddress: ":8081"})
+	require.ErrorIs(t, err, ErrAdminTokenRequired)
+	_, err = newAdminTLSConfig(Configuration{AdminAddress: ":8081", AdminClientCAFile: "ca.pem"})
+	require.ErrorIs(t, err, ErrAdminTLSRequired)
+	tlsConfig, err := newAdminTLSConfig(Configuration{AdminAddress: ":8081", AdminToken: "secret"})
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/flashbots/rpc-endpoint/metrics"
+)
+
+// Results of a DecisionCheck
+const (
+	DecisionPass  = "pass"  // the check passed, and the tx was processed further
+	DecisionStop  = "stop"  // the check decided how the tx is handled, e.g. rejected, blocked or collected
+	DecisionError = "error" // the check failed to run, and the tx was processed further
+)
+
+// DecisionCheck is a check of a tx and its result
+type DecisionCheck struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// DecisionRecord lists every check run on an eth_sendRawTransaction and its result, to audit why a tx was or
+// wasn't relayed. It is built alongside the EthSendRawTxEntry of the tx.
+type DecisionRecord struct {
+	Time          time.Time       `json:"time"`
+	RequestId     uuid.UUID       `json:"requestId"`
+	RawTxEntryId  uuid.UUID       `json:"rawTxEntryId"`
+	Chain         string          `json:"chain,omitempty"`
+	OriginId      string          `json:"originId,omitempty"`
+	PresetApplied bool            `json:"presetApplied"`
+	TxHash        string          `json:"txHash,omitempty"`
+	TxFrom        string          `json:"txFrom,omitempty"`
+	TxTo          string          `json:"txTo,omitempty"`
+	TxNonce       int             `json:"txNonce"`
+	Checks        []DecisionCheck `json:"checks"`
+	Outcome       string          `json:"outcome"` // relayed, mempool, blocked, error, whitehat, cancel or none
+	Error         string          `json:"error,omitempty"`
+	ErrorCode     int             `json:"errorCode,omitempty"`
+
+	entry *database.EthSendRawTxEntry
+}
+
+func newDecisionRecord(requestId uuid.UUID, chain string, entry *database.EthSendRawTxEntry) *DecisionRecord {
+	return &DecisionRecord{Time: Now(), RequestId: requestId, Chain: chain, Checks: []DecisionCheck{}, entry: entry}
+}
+
+// add appends a check, the record may be nil
+func (d *DecisionRecord) add(name, result, detail string) {
+	if d == nil {
+		return
+	}
+	d.Checks = append(d.Checks, DecisionCheck{Name: name, Result: result, Detail: detail})
+}
+
+// complete copies the tx and the outcome from the raw tx entry
+func (d *DecisionRecord) complete() {
+	entry := d.entry
+	d.RawTxEntryId = entry.Id
+	d.OriginId = entry.OriginId
+	d.PresetApplied = entry.PresetApplied
+	d.TxHash = entry.TxHash
+	d.TxFrom = entry.TxFrom
+	d.TxTo = entry.TxTo
+	d.TxNonce = entry.TxNonce
+	d.Outcome = privateTxOutcome(entry)
+	d.Error = entry.Error
+	d.ErrorCode = entry.ErrorCode
+}
+
+// AuditSink receives the decision record of every eth_sendRawTransaction. Emit may be called after Close, and must
+// not block the request for long.
+type AuditSink interface {
+	Emit(record *DecisionRecord)
+	// Close flushes pending records
+	Close(ctx context.Context) error
+}
+
+// NewAuditSink returns the sink of the target: stdout, file:<path>, or an http(s) webhook url. No sink is used
+// if the target is empty.
+func NewAuditSink(logger log.Logger, target string) (AuditSink, error) {
+	switch {
+	case target == "":
+		return nil, nil
+	case target == "stdout":
+		return &writerAuditSink{logger: logger, writer: os.Stdout}, nil
+	case strings.HasPrefix(target, "file:"):
+		file, err := os.OpenFile(strings.TrimPrefix(target, "file:"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+		if err != nil {
+			return nil, errors.Wrap(err, "opening audit file failed")
+		}
+		return &writerAuditSink{logger: logger, writer: file, closer: file}, nil
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		return NewWebhookAuditSink(logger, target, DefaultAuditQueueSize, DefaultAuditBatchSize, DefaultAuditEnqueueTimeout), nil
+	default:
+		return nil, errors.Errorf("unknown audit sink %q", target)
+	}
+}
+
+// writerAuditSink writes the records as JSON lines
+type writerAuditSink struct {
+	logger log.Logger
+	mu     sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+func (s *writerAuditSink) Emit(record *DecisionRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		s.logger.Error("[audit] Marshalling decision record failed", "error", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.writer.Write(append(line, '\n')); err != nil {
+		metrics.IncAuditRecordErr()
+		s.logger.Error("[audit] Writing decision record failed", "error", err)
+	}
+}
+
+func (s *writerAuditSink) Close(ctx context.Context) error {
+	if s.closer == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closer.Close()
+}
+
+// Defaults for the webhook audit sink
+var (
+	DefaultAuditQueueSize      = 10000
+	DefaultAuditBatchSize      = 100
+	DefaultAuditEnqueueTimeout = 100 * time.Millisecond
+)
+
+const (
+	auditPostAttempts   = 3
+	auditRetryBackoff   = 200 * time.Millisecond
+	auditWebhookTimeout = 5 * time.Second
+)
+
+// WebhookAuditSink posts the records as a JSON array to a url, in batches from a bounded queue. When the queue is
+// full, Emit waits for up to enqueueTimeout before the record is dropped, so a slow webhook doesn't hold up
+// requests. Failed posts are retried. Dropped records are logged, so they can be recovered from the logs.
+type WebhookAuditSink struct {
+	logger         log.Logger
+	url            string
+	httpClient     *http.Client
+	queue          chan *DecisionRecord
+	batchSize      int
+	enqueueTimeout time.Duration
+
+	done      chan struct{}
+	abort     chan struct{} // closed when Close gives up, drops the pending records
+	stopMx    sync.RWMutex
+	stopped   bool
+	closeOnce sync.Once
+	abortOnce sync.Once
+}
+
+func NewWebhookAuditSink(logger log.Logger, url string, queueSize, batchSize int, enqueueTimeout time.Duration) *WebhookAuditSink {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	s := &WebhookAuditSink{
+		logger:         logger,
+		url:            url,
+		httpClient:     &http.Client{Timeout: auditWebhookTimeout},
+		queue:          make(chan *DecisionRecord, queueSize),
+		batchSize:      batchSize,
+		enqueueTimeout: enqueueTimeout,
+		done:           make(chan struct{}),
+		abort:          make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *WebhookAuditSink) Emit(record *DecisionRecord) {
+	s.stopMx.RLock()
+	defer s.stopMx.RUnlock()
+	if s.stopped {
+		s.drop([]*DecisionRecord{record}, "sink is closed")
+		return
+	}
+
+	select {
+	case s.queue <- record:
+		return
+	default:
+	}
+
+	// Queue is full, wait for a free slot before dropping the record
+	timer := time.NewTimer(s.enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case s.queue <- record:
+	case <-timer.C:
+		s.drop([]*DecisionRecord{record}, "queue is full")
+	}
+}
+
+// drop counts and logs records which are not posted, with their content
+func (s *WebhookAuditSink) drop(records []*DecisionRecord, reason string) {
+	for _, record := range records {
+		metrics.IncAuditRecordDropped()
+		line, _ := json.Marshal(record)
+		s.logger.Error("[audit] Decision record dropped", "reason", reason, "requestId", record.RequestId, "record", string(line))
+	}
+}
+
+func (s *WebhookAuditSink) run() {
+	defer close(s.done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.abort:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for record := range s.queue {
+		batch := []*DecisionRecord{record}
+	collect:
+		for len(batch) < s.batchSize {
+			select {
+			case record, ok := <-s.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, record)
+			default:
+				break collect
+			}
+		}
+		if ctx.Err() != nil {
+			s.drop(batch, "sink is closed")
+			continue
+		}
+		if err := s.postWithRetries(ctx, batch); err != nil {
+			s.drop(batch, err.Error())
+		}
+	}
+}
+
+// postWithRetries posts the batch, and retries with a backoff if it fails
+func (s *WebhookAuditSink) postWithRetries(ctx context.Context, batch []*DecisionRecord) (err error) {
+	for attempt := 1; ; attempt++ {
+		if err = s.post(ctx, batch); err == nil {
+			return nil
+		}
+		metrics.IncAuditRecordErr()
+		s.logger.Warn("[audit] Posting decision records failed", "error", err, "records", len(batch), "attempt", attempt)
+		if attempt == auditPostAttempts {
+			return err
+		}
+		select {
+		case <-time.After(auditRetryBackoff * time.Duration(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *WebhookAuditSink) post(ctx context.Context, batch []*DecisionRecord) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode >= 300 {
+		return errors.Errorf("webhook returned status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Close stops accepting records and posts the queued ones. When ctx is done, the remaining records are dropped.
+func (s *WebhookAuditSink) Close(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		s.stopMx.Lock()
+		s.stopped = true
+		close(s.queue)
+		s.stopMx.Unlock()
+	})
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+	}
+	s.abortOnce.Do(func() { close(s.abort) })
+	<-s.done
+	return ctx.Err()
+}
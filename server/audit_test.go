@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	vmetrics "github.com/VictoriaMetrics/metrics"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flashbots/rpc-endpoint/database"
+)
+
+func testDecisionRecord() *DecisionRecord {
+	record := newDecisionRecord([16]byte{1}, "sepolia", &database.EthSendRawTxEntry{TxHash: "0xaa", IsOnOafcList: true, Error: "blocked tx due to ofac sanctioned address"})
+	record.add("ofac", DecisionStop, "sanctioned address")
+	record.complete()
+	return record
+}
+
+func TestAuditSinkFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewAuditSink(log.New(), "file:"+path)
+	require.NoError(t, err)
+	sink.Emit(testDecisionRecord())
+	sink.Emit(testDecisionRecord())
+	require.NoError(t, sink.Close(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var record DecisionRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	require.Equal(t, "error", record.Outcome)
+	require.Equal(t, []DecisionCheck{{Name: "ofac", Result: DecisionStop, Detail: "sanctioned address"}}, record.Checks)
+
+	_, err = NewAuditSink(log.New(), "kafka://audit")
+	require.Error(t, err)
+}
+
+func TestAuditSinkWebhook(t *testing.T) {
+	bodies := make(chan string, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer webhook.Close()
+
+	sink, err := NewAuditSink(log.New(), webhook.URL)
+	require.NoError(t, err)
+	sink.Emit(testDecisionRecord())
+	require.NoError(t, sink.Close(context.Background()))
+
+	require.Len(t, bodies, 1)
+	var records []DecisionRecord
+	require.NoError(t, json.Unmarshal([]byte(<-bodies), &records))
+	require.Len(t, records, 1)
+	require.Equal(t, "0xaa", records[0].TxHash)
+
+	// Records emitted after closing are dropped, not sent on the closed queue
+	sink.Emit(testDecisionRecord())
+	require.Empty(t, bodies)
+}
+
+func TestAuditSinkWebhookBatchesAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	var posted [][]DecisionRecord
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var records []DecisionRecord
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&records))
+		posted = append(posted, records)
+	}))
+	defer webhook.Close()
+
+	sink := NewWebhookAuditSink(log.New(), webhook.URL, 10, 2, time.Second)
+	for i := 0; i < 3; i++ {
+		sink.Emit(testDecisionRecord())
+	}
+	require.NoError(t, sink.Close(context.Background()))
+
+	// The first post failed and was retried, no batch has more than 2 records
+	mu.Lock()
+	defer mu.Unlock()
+	total := 0
+	for _, records := range posted {
+		require.LessOrEqual(t, len(records), 2)
+		total += len(records)
+	}
+	require.Equal(t, 3, total)
+	require.Greater(t, calls, len(posted))
+}
+
+func TestAuditSinkWebhookQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer webhook.Close()
+	defer close(release)
+
+	dropped := vmetrics.GetOrCreateCounter("audit_record_dropped_total")
+	before := dropped.Get()
+	sink := NewWebhookAuditSink(log.New(), webhook.URL, 1, 1, 10*time.Millisecond)
+
+	// The first record is being posted, the second one waits in the queue and the third one is dropped
+	sink.Emit(testDecisionRecord())
+	require.Eventually(t, func() bool { return len(sink.queue) == 0 }, time.Second, time.Millisecond)
+	sink.Emit(testDecisionRecord())
+	sink.Emit(testDecisionRecord())
+	require.Equal(t, before+1, dropped.Get())
+
+	// Closing gives up on the pending records when ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, sink.Close(ctx), context.DeadlineExceeded)
+	require.Equal(t, before+3, dropped.Get())
+}
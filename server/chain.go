@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/pkg/errors"
+
+	"github.com/flashbots/rpc-endpoint/adapters/webfile"
+	"github.com/flashbots/rpc-endpoint/application"
+)
+
+var (
+	chainNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	// reservedChainNames are paths of the main listener, which can't be used for routing to a chain
+	reservedChainNames = map[string]bool{"fast": true, "health": true, "readiness": true, "bundle": true, "chain": true}
+)
+
+// ChainConfiguration is a chain served next to the default chain of the Configuration, on the path /<name>
+// (e.g. /sepolia, /sepolia/fast) and on requests for any of its hosts
+type ChainConfiguration struct {
+	Name              string   `json:"name"`
+	Hosts             []string `json:"hosts"`
+	ProxyUrls         []string `json:"proxyUrls"` // requests are spread round-robin
+	RelayUrl          string   `json:"relayUrl"`
+	MempoolRPC        string   `json:"mempoolRpc"`
+	TxApiHost         string   `json:"txApiHost"`
+	BuilderInfoSource string   `json:"builderInfoSource"` // optional
+	RedisNamespace    string   `json:"redisNamespace"`    // defaults to the name
+	// RejectUnprotectedTxs rejects legacy txs without a chain id (pre EIP-155), which are valid on any chain
+	RejectUnprotectedTxs bool `json:"rejectUnprotectedTxs"`
+}
+
+func (c ChainConfiguration) Validate() error {
+	if !chainNameRegex.MatchString(c.Name) || reservedChainNames[c.Name] {
+		return fmt.Errorf("invalid chain name %q", c.Name)
+	}
+	if len(c.ProxyUrls) == 0 || c.RelayUrl == "" || c.MempoolRPC == "" || c.TxApiHost == "" {
+		return fmt.Errorf("chain %s requires proxyUrls, relayUrl, mempoolRpc and txApiHost", c.Name)
+	}
+	return nil
+}
+
+// ReadChainsConfigFromFile reads a JSON list of ChainConfiguration, no chains are added if the path is empty
+func ReadChainsConfigFromFile(path string) ([]ChainConfiguration, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading chains config failed")
+	}
+	var chains []ChainConfiguration
+	if err = json.Unmarshal(data, &chains); err != nil {
+		return nil, errors.Wrap(err, "parsing chains config failed")
+	}
+	return chains, nil
+}
+
+// Chain holds the upstreams and the state of a chain served by the endpoint
+type Chain struct {
+	name                string // empty for the default chain
+	displayName         string // name reported by GET /chain
+	chainID             []byte // net_version of the upstream node, as returned to clients
+	ethChainID          []byte // eth_chainId of the upstream node, as returned to clients
+	chainIDInt          *big.Int
+	proxyUrls           []string
+	nextProxy           atomic.Uint64
+	relayUrl            string
+	txApiHost           string
+	rejectUnprotected   bool
+	mempoolRPC          string
+	mempoolClients      *EthClientPool // shared by all chains, dials the mempool RPC on first use
+	state               StateStore
+	builderNameProvider BuilderNameProvider
+	rpcCache            *application.RpcCache
+	inclusionTracker    *InclusionTracker
+	webhooks            *WebhookNotifier
+}
+
+// newChain connects to the upstreams of a chain and fetches its chain id, which must match its network id
+func newChain(cfg Configuration, chainCfg ChainConfiguration, state StateStore, mempoolClients *EthClientPool, webhooks *WebhookNotifier) (*Chain, error) {
+	ethChainID, err := fetchRpcResult(cfg.Logger, chainCfg.ProxyUrls[0], cfg.ProxyTimeoutSeconds, "eth_chainId")
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch eth_chainId error")
+	}
+	var chainIDInt hexutil.Big
+	if err = json.Unmarshal(ethChainID, &chainIDInt); err != nil {
+		return nil, fmt.Errorf("invalid eth_chainId %s", string(ethChainID))
+	}
+	chainID, err := fetchNetworkIDBytes(cfg.Logger, chainCfg.ProxyUrls[0], cfg.ProxyTimeoutSeconds)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetchNetworkIDBytes error")
+	}
+	networkID, err := parseNetworkID(chainID)
+	if err != nil {
+		return nil, err
+	}
+	if networkID.Cmp(chainIDInt.ToInt()) != 0 {
+		return nil, fmt.Errorf("net_version %s does not match eth_chainId %s of %s", networkID, chainIDInt.ToInt(), chainCfg.ProxyUrls[0])
+	}
+
+	var builderInfoFetcher application.Fetcher
+	if chainCfg.BuilderInfoSource != "" {
+		builderInfoFetcher = webfile.NewFetcher(chainCfg.BuilderInfoSource)
+	}
+	bis, err := application.StartBuilderInfoService(context.Background(), builderInfoFetcher, time.Second*time.Duration(cfg.FetchInfoInterval))
+	if err != nil {
+		return nil, errors.Wrap(err, "BuilderInfoService init error")
+	}
+
+	chain := &Chain{
+		name:                chainCfg.Name,
+		displayName:         valueOrDefault(chainCfg.Name, cfg.ChainName),
+		chainID:             chainID,
+		ethChainID:          ethChainID,
+		chainIDInt:          chainIDInt.ToInt(),
+		proxyUrls:           chainCfg.ProxyUrls,
+		relayUrl:            chainCfg.RelayUrl,
+		txApiHost:           chainCfg.TxApiHost,
+		rejectUnprotected:   chainCfg.RejectUnprotectedTxs,
+		mempoolRPC:          chainCfg.MempoolRPC,
+		mempoolClients:      mempoolClients,
+		state:               state,
+		builderNameProvider: bis,
+		rpcCache:            application.NewRpcCache(cfg.TTLCacheSeconds),
+		webhooks:            webhooks,
+	}
+
+	if cfg.InclusionStore != nil && cfg.InclusionWindow > 0 {
+		upstreamCl, err := ethclient.Dial(chainCfg.ProxyUrls[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "upstream ethclient.Dial error")
+		}
+		chain.inclusionTracker = NewInclusionTracker(cfg.Logger.New("chain", chainCfg.Name), cfg.InclusionStore, chainCfg.Name, chainCfg.TxApiHost,
+			upstreamCl, cfg.InclusionWindow, valueOrDefault(cfg.InclusionPollInterval, DefaultInclusionPollInterval), DefaultInclusionBatchSize, webhooks)
+	}
+	return chain, nil
+}
+
+// ChainInfo is the metadata of a chain returned by GET /chain
+type ChainInfo struct {
+	Name      string   `json:"name"`
+	ChainID   string   `json:"chainId"`   // hex, as returned by eth_chainId
+	NetworkID string   `json:"networkId"` // decimal, as returned by net_version
+	RelayUrl  string   `json:"relayUrl"`
+	Builders  []string `json:"builders"`
+}
+
+func (c *Chain) info() ChainInfo {
+	builders := []string{}
+	if c.builderNameProvider != nil {
+		builders = append(builders, c.builderNameProvider.BuilderNames()...)
+	}
+	return ChainInfo{
+		Name:      c.displayName,
+		ChainID:   hexutil.EncodeBig(c.chainIDInt),
+		NetworkID: c.chainIDInt.String(),
+		RelayUrl:  c.relayUrl,
+		Builders:  builders,
+	}
+}
+
+// proxyUrl returns the next proxy url of the chain, round-robin
+func (c *Chain) proxyUrl() string {
+	i := c.nextProxy.Add(1) - 1
+	return c.proxyUrls[i%uint64(len(c.proxyUrls))]
+}
+
+// parseNetworkID parses the net_version result, a decimal string
+func parseNetworkID(netVersion []byte) (*big.Int, error) {
+	var s string
+	if err := json.Unmarshal(netVersion, &s); err != nil {
+		// Some nodes return a number
+		s = string(netVersion)
+	}
+	id, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid net_version %s", string(netVersion))
+	}
+	return id, nil
+}
+
+// chainRouter resolves the chain of a request by its first path segment, or else by its host
+type chainRouter struct {
+	defaultChain *Chain
+	byName       map[string]*Chain
+	byHost       map[string]*Chain
+}
+
+func newChainRouter(defaultChain *Chain) *chainRouter {
+	return &chainRouter{
+		defaultChain: defaultChain,
+		byName:       make(map[string]*Chain),
+		byHost:       make(map[string]*Chain),
+	}
+}
+
+func (r *chainRouter) add(chain *Chain, hosts []string) error {
+	if _, exists := r.byName[chain.name]; exists {
+		return fmt.Errorf("duplicate chain %s", chain.name)
+	}
+	for _, host := range hosts {
+		if _, exists := r.byHost[strings.ToLower(host)]; exists {
+			return fmt.Errorf("duplicate chain host %s", host)
+		}
+	}
+	r.byName[chain.name] = chain
+	for _, host := range hosts {
+		r.byHost[strings.ToLower(host)] = chain
+	}
+	return nil
+}
+
+// chains returns all chains, the default chain first and then by name
+func (r *chainRouter) chains() []*Chain {
+	chains := make([]*Chain, 0, len(r.byName)+1)
+	for _, chain := range r.byName {
+		chains = append(chains, chain)
+	}
+	sort.Slice(chains, func(i, j int) bool { return chains[i].name < chains[j].name })
+	return append([]*Chain{r.defaultChain}, chains...)
+}
+
+// chain returns the chain with the name, or the default chain for an empty name
+func (r *chainRouter) chain(name string) (*Chain, bool) {
+	if name == "" {
+		return r.defaultChain, true
+	}
+	chain, ok := r.byName[name]
+	return chain, ok
+}
+
+// route returns the chain of the request, and the path with the chain name removed
+func (r *chainRouter) route(req *http.Request) (chain *Chain, path string) {
+	segment, rest, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"), "/")
+	if chain, ok := r.byName[segment]; ok {
+		return chain, "/" + rest
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if chain, ok := r.byHost[strings.ToLower(host)]; ok {
+		return chain, req.URL.Path
+	}
+	return r.defaultChain, req.URL.Path
+}
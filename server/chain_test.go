@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/flashbots/rpc-endpoint/testutils"
+	"github.com/flashbots/rpc-endpoint/types"
+	"github.com/stretchr/testify/require"
+)
+
+// newChainBackend answers eth_chainId with the chain id, and all other JSON-RPC requests with the net version
+func newChainBackend(t *testing.T, netVersion, chainID string) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var jsonReq types.JsonRpcRequest
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&jsonReq))
+		result := netVersion
+		if jsonReq.Method == "eth_chainId" {
+			result = chainID
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":"%s"}`, result)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func newTestChainConfiguration(backend *httptest.Server) ChainConfiguration {
+	return ChainConfiguration{
+		Name:       "sepolia",
+		Hosts:      []string{"rpc-sepolia.example.com"},
+		ProxyUrls:  []string{backend.URL},
+		RelayUrl:   backend.URL,
+		MempoolRPC: backend.URL,
+		TxApiHost:  backend.URL,
+	}
+}
+
+func TestChainConfigurationValidate(t *testing.T) {
+	valid := ChainConfiguration{Name: "sepolia", ProxyUrls: []string{"http://node"}, RelayUrl: "http://relay", MempoolRPC: "http://node", TxApiHost: "http://api"}
+	require.NoError(t, valid.Validate())
+
+	for _, name := range []string{"", "Sepolia", "sepolia/fast", "fast", "bundle"} {
+		invalid := valid
+		invalid.Name = name
+		require.Error(t, invalid.Validate(), name)
+	}
+	invalid := valid
+	invalid.ProxyUrls = nil
+	require.Error(t, invalid.Validate())
+}
+
+func TestParseNetworkID(t *testing.T) {
+	id, err := parseNetworkID([]byte(`"11155111"`))
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(11155111), id)
+
+	id, err = parseNetworkID([]byte(`17000`))
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(17000), id)
+
+	_, err = parseNetworkID([]byte(`"0x1"`))
+	require.Error(t, err)
+}
+
+func TestChainRouter(t *testing.T) {
+	mainnet := &Chain{}
+	sepolia := &Chain{name: "sepolia", proxyUrls: []string{"a", "b"}}
+	router := newChainRouter(mainnet)
+	require.NoError(t, router.add(sepolia, []string{"RPC-Sepolia.example.com"}))
+	require.Error(t, router.add(&Chain{name: "holesky"}, []string{"rpc-sepolia.example.com"}))
+
+	for _, tc := range []struct {
+		url, host string
+		chain     *Chain
+		path      string
+	}{
+		{"/", "rpc.example.com", mainnet, "/"},
+		{"/fast?hint=hash", "rpc.example.com", mainnet, "/fast"},
+		{"/sepolia", "rpc.example.com", sepolia, "/"},
+		{"/sepolia/fast", "rpc.example.com", sepolia, "/fast"},
+		{"/sepoliafast", "rpc.example.com", mainnet, "/sepoliafast"},
+		{"/fast", "rpc-sepolia.example.com:443", sepolia, "/fast"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.url, nil)
+		req.Host = tc.host
+		chain, path := router.route(req)
+		require.Same(t, tc.chain, chain, tc.url)
+		require.Equal(t, tc.path, path, tc.url)
+	}
+
+	// Proxy urls are used round-robin
+	require.Equal(t, []string{"a", "b", "a"}, []string{sepolia.proxyUrl(), sepolia.proxyUrl(), sepolia.proxyUrl()})
+	require.Equal(t, []*Chain{mainnet, sepolia}, router.chains())
+}
+
+func TestMultiChainServer(t *testing.T) {
+	ctx := context.Background()
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redisServer.Close()
+	mainnet := httptest.NewServer(http.HandlerFunc(testutils.RpcBackendHandler))
+	defer mainnet.Close()
+	sepolia := newChainBackend(t, "11155111", "0xaa36a7")
+
+	memStore := database.NewMemStore()
+	s, err := NewRpcEndPointServer(Configuration{
+		DB:                  memStore,
+		Logger:              log.New(),
+		ProxyTimeoutSeconds: 1,
+		ProxyUrl:            mainnet.URL,
+		RedisUrl:            redisServer.Addr(),
+		RelayUrl:            mainnet.URL,
+		DefaultMempoolRPC:   mainnet.URL,
+		ChainName:           "mainnet",
+		Chains:              []ChainConfiguration{newTestChainConfiguration(sepolia)},
+	})
+	require.NoError(t, err)
+
+	send := func(url, host string, jsonReq *types.JsonRpcRequest) types.JsonRpcResponse {
+		body, err := json.Marshal(jsonReq)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if host != "" {
+			req.Host = host
+		}
+		rec := httptest.NewRecorder()
+		s.HandleHttpRequest(rec, req)
+		var res types.JsonRpcResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+
+	netVersion := types.NewJsonRpcRequest(1, "net_version", nil)
+	require.Equal(t, `"1"`, string(send("/", "", netVersion).Result))
+	require.Equal(t, `"11155111"`, string(send("/sepolia", "", netVersion).Result))
+	require.Equal(t, `"11155111"`, string(send("/fast", "rpc-sepolia.example.com", netVersion).Result))
+
+	// The chain id is served from the metadata fetched at startup
+	testutils.MockRpcBackendReset()
+	chainID := types.NewJsonRpcRequest(1, "eth_chainId", nil)
+	require.Equal(t, `"0x1"`, string(send("/", "", chainID).Result))
+	require.Equal(t, `"0xaa36a7"`, string(send("/sepolia", "", chainID).Result))
+	require.Nil(t, testutils.MockBackendLastJsonRpcRequest)
+
+	getChainInfo := func(url string) ChainInfo {
+		rec := httptest.NewRecorder()
+		s.HandleHttpRequest(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var info ChainInfo
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+		return info
+	}
+	require.Equal(t, ChainInfo{Name: "mainnet", ChainID: "0x1", NetworkID: "1", RelayUrl: mainnet.URL, Builders: []string{}}, getChainInfo("/chain"))
+	require.Equal(t, ChainInfo{Name: "sepolia", ChainID: "0xaa36a7", NetworkID: "11155111", RelayUrl: sepolia.URL, Builders: []string{}}, getChainInfo("/sepolia/chain"))
+
+	// A mainnet tx is rejected on sepolia
+	sendRawTx := types.NewJsonRpcRequest(1, "eth_sendRawTransaction", []interface{}{testutils.TestTx_BundleFailedTooManyTimes_RawTx})
+	res := send("/sepolia/fast", "", sendRawTx)
+	require.NotNil(t, res.Error)
+	require.Equal(t, types.JsonRpcWrongChainId, res.Error.Code)
+	require.Equal(t, "tx rejected - chain id 1 does not match 11155111", res.Error.Message)
+
+	// The state of each chain is kept in its own namespace
+	require.NoError(t, s.chains.byName["sepolia"].state.SetTxSentToRelay(ctx, "0xFoo"))
+	require.True(t, redisServer.Exists("rpc-endpoint:sepolia:tx-sent-to-relay:0xfoo"))
+	_, found, err := s.chains.defaultChain.state.GetTxSentToRelay(ctx, "0xFoo")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// The rejected tx is recorded with its chain
+	waitForRawTxEntries(t, memStore, 1)
+	require.Len(t, memStore.Requests, 1)
+	for _, entry := range memStore.Requests {
+		require.Equal(t, "sepolia", entry.Chain)
+	}
+}
+
+func TestNewChainRejectsChainIdMismatch(t *testing.T) {
+	backend := newChainBackend(t, "1", "0x5")
+	_, err := newChain(Configuration{Logger: log.New(), ProxyTimeoutSeconds: 1}, newTestChainConfiguration(backend), nil, nil, nil)
+	require.ErrorContains(t, err, "net_version 1 does not match eth_chainId 5")
+}
+
+// The mempool RPC is dialed on first use, so it being down doesn't stop the startup
+func TestNewChainWithMempoolRPCDown(t *testing.T) {
+	backend := newChainBackend(t, "11155111", "0xaa36a7")
+	chainCfg := newTestChainConfiguration(backend)
+	chainCfg.MempoolRPC = "http://127.0.0.1:1"
+	pool := NewEthClientPool(1, 0, func(ctx context.Context, url string) (*ethclient.Client, error) {
+		return ethclient.DialContext(ctx, url)
+	})
+	chain, err := newChain(Configuration{Logger: log.New(), ProxyTimeoutSeconds: 1}, chainCfg, nil, pool, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, pool.Len())
+
+	r := &RpcRequest{mempoolRPC: chain.mempoolRPC, mempoolClients: chain.mempoolClients}
+	_, err = r.blockNumber(context.Background())
+	require.Error(t, err)
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"crypto/ecdsa"
+	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/flashbots/rpc-endpoint/database"
@@ -11,11 +12,19 @@ type Configuration struct {
 	DB                   database.Store
 	DrainAddress         string
 	DrainSeconds         int
+	AdminAddress         string
+	AdminToken           string // bearer token of the admin API, optional with AdminClientCAFile
+	AdminTLSCertFile     string // serves the admin API over TLS if set, with AdminTLSKeyFile
+	AdminTLSKeyFile      string
+	AdminClientCAFile    string // requires admin clients to present a certificate signed by this CA
 	ListenAddress        string
 	Logger               log.Logger
 	ProxyTimeoutSeconds  int
+	RequestTimeouts      RequestTimeouts // deadlines of requests and their calls, defaults are used for zero values
 	ProxyUrl             string
 	RedisUrl             string
+	State                StateStore   // if set, RedisUrl and StateOptions are not used
+	StateOptions         StateOptions // defaults are used for zero values
 	RelaySigningKey      *ecdsa.PrivateKey
 	RelayUrl             string
 	Version              string
@@ -23,5 +32,29 @@ type Configuration struct {
 	FetchInfoInterval    int
 	TTLCacheSeconds      int64
 	DefaultMempoolRPC    string
-	ConfigurationWatcher *ConfigurationWatcher
+	ChainName            string // name of the default chain, reported by GET /chain
+	RejectUnprotectedTxs bool   // reject legacy txs without a chain id (pre EIP-155)
+	// Send txs of users who set useMempool to the mempool RPC after the delay, or right away if the relay rejects them
+	MempoolBroadcast      bool
+	MempoolBroadcastDelay time.Duration
+	Chains                []ChainConfiguration // served next to the default chain, which is set by the fields above
+	ConfigurationWatcher  *ConfigurationWatcher
+	CustomerConfigFile    string       // the customer config is reloaded from this file by the admin API
+	MethodPolicy          MethodPolicy // methods served by the proxy, customers can override it in their config
+	// Hosts which clients can set with the url and mempoolRPC params, all public hosts if empty
+	AllowedCustomUpstreams []string
+
+	// Background writer for request records, defaults are used for zero values
+	RecordQueueSize      int
+	RecordWorkers        int
+	RecordEnqueueTimeout time.Duration
+	RecordDrainTimeout   time.Duration
+
+	Tracing   TracingConfiguration // OpenTelemetry tracing, disabled if no exporter is set
+	AuditSink AuditSink            // receives a decision record of every eth_sendRawTransaction, if set
+
+	// Inclusion tracking of relayed txs, disabled if InclusionStore is nil or InclusionWindow is zero
+	InclusionStore        database.InclusionStore
+	InclusionWindow       time.Duration
+	InclusionPollInterval time.Duration
 }
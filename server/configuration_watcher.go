@@ -6,16 +6,25 @@ import (
 	"maps"
 	"net/url"
 	"os"
+	"strings"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/metrics"
 	"gopkg.in/yaml.v3"
 )
 
 var ErrCustomerNotConfigured = errors.New("customer is not configured")
 
 type CustomersConfig struct {
-	URLs    map[string][]string `yaml:"urls"`
-	Presets map[string]string   `yaml:"presets,omitempty"`
+	URLs    map[string][]string     `yaml:"urls" json:"urls"`
+	Presets map[string]string       `yaml:"presets,omitempty" json:"presets,omitempty"`
+	Methods map[string]MethodPolicy `yaml:"methods,omitempty" json:"methods,omitempty"` // overrides of the method policy per origin
+	// Addresses whose X-Flashbots-Signature authenticates the requests of an origin, the origin id alone is set by
+	// the client. Method policies of an origin only allow methods to authenticated requests.
+	Signers map[string][]string `yaml:"signers,omitempty" json:"signers,omitempty"`
+	// Webhooks which are notified of the lifecycle events of the private txs of an origin
+	Webhooks map[string]WebhookConfig `yaml:"webhooks,omitempty" json:"webhooks,omitempty"`
 }
 
 // ConfigurationWatcher
@@ -25,6 +34,12 @@ type ConfigurationWatcher struct {
 	ParsedCustomersConfig map[string][]URLParameters
 	// ParsedPresets contains pre-parsed preset configurations for header-based override
 	ParsedPresets map[string]URLParameters
+	// MethodPolicies overrides the method policy of the server per origin
+	MethodPolicies map[string]MethodPolicy
+	// Signers has the lowercase signing addresses which authenticate the requests of an origin
+	Signers map[string]map[string]bool
+	// Config is the config the watcher was created from, as shown by the admin API
+	Config CustomersConfig
 }
 
 // parseURLToParameters converts a raw URL string to URLParameters
@@ -69,9 +84,35 @@ func NewConfigurationWatcher(customersConfig CustomersConfig) (*ConfigurationWat
 		log.Info("Loaded preset configuration", "originID", originID)
 	}
 
+	for originID, policy := range customersConfig.Methods {
+		if err := policy.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid method policy for customer %s: %w", originID, err)
+		}
+	}
+
+	signers := make(map[string]map[string]bool)
+	for originID, addresses := range customersConfig.Signers {
+		signers[originID] = make(map[string]bool, len(addresses))
+		for _, address := range addresses {
+			if !common.IsHexAddress(address) {
+				return nil, fmt.Errorf("invalid signer address %q for customer %s", address, originID)
+			}
+			signers[originID][strings.ToLower(address)] = true
+		}
+	}
+
+	for originID, webhook := range customersConfig.Webhooks {
+		if err := webhook.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid webhook for customer %s: %w", originID, err)
+		}
+	}
+
 	return &ConfigurationWatcher{
 		ParsedCustomersConfig: parsedCustomersConfig,
 		ParsedPresets:         parsedPresets,
+		MethodPolicies:        customersConfig.Methods,
+		Signers:               signers,
+		Config:                customersConfig,
 	}, nil
 }
 
@@ -108,6 +149,25 @@ func (watcher *ConfigurationWatcher) IsConfigurationUpdated(customer string, url
 	return true
 }
 
+// MethodPolicy returns the method policy override of the customer, or nil
+func (watcher *ConfigurationWatcher) MethodPolicy(customer string) *MethodPolicy {
+	if watcher == nil {
+		return nil
+	}
+	if policy, ok := watcher.MethodPolicies[customer]; ok {
+		return &policy
+	}
+	return nil
+}
+
+// IsAuthenticated returns whether the request of the customer was signed by one of its signers
+func (watcher *ConfigurationWatcher) IsAuthenticated(customer, signingAddress string) bool {
+	if watcher == nil || signingAddress == "" {
+		return false
+	}
+	return watcher.Signers[customer][strings.ToLower(signingAddress)]
+}
+
 func (watcher *ConfigurationWatcher) Customers() []string {
 	customers := make([]string, 0, len(watcher.ParsedCustomersConfig))
 	for k := range watcher.ParsedCustomersConfig {
@@ -146,3 +206,14 @@ func EquivalentURLParams(left URLParameters, right URLParameters) bool {
 	}
 	return true
 }
+
+// OriginLabel returns the origin as a metrics label, bounded to the configured customers
+func (watcher *ConfigurationWatcher) OriginLabel(origin string) string {
+	if watcher == nil {
+		return metrics.OtherLabel
+	}
+	if _, ok := watcher.ParsedCustomersConfig[origin]; ok {
+		return origin
+	}
+	return metrics.OtherLabel
+}
@@ -52,3 +52,62 @@ func TestConfigurationWatcherInvalidPresets(t *testing.T) {
 	_, exists = watcher.ParsedPresets["invalid"]
 	require.False(t, exists)
 }
+
+func TestConfigurationWatcherMethodPolicies(t *testing.T) {
+	watcher, err := NewConfigurationWatcher(CustomersConfig{
+		Methods: map[string]MethodPolicy{
+			"quicknode": {Allow: []string{"debug_trace*"}},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, &MethodPolicy{Allow: []string{"debug_trace*"}}, watcher.MethodPolicy("quicknode"))
+	require.Nil(t, watcher.MethodPolicy("other"))
+
+	_, err = NewConfigurationWatcher(CustomersConfig{
+		Methods: map[string]MethodPolicy{"quicknode": {Deny: []string{"["}}},
+	})
+	require.Error(t, err)
+}
+
+func TestConfigurationWatcherSigners(t *testing.T) {
+	watcher, err := NewConfigurationWatcher(CustomersConfig{
+		Signers: map[string][]string{"quicknode": {"0x7Ae4E5e5b0Cd1a2a8F8b7b2E3b9E1C7e4C4a6E11"}},
+	})
+	require.NoError(t, err)
+	require.True(t, watcher.IsAuthenticated("quicknode", "0x7ae4e5e5b0cd1a2a8f8b7b2e3b9e1c7e4c4a6e11"))
+	require.False(t, watcher.IsAuthenticated("quicknode", ""))
+	require.False(t, watcher.IsAuthenticated("other", "0x7ae4e5e5b0cd1a2a8f8b7b2e3b9e1c7e4c4a6e11"))
+	require.False(t, (*ConfigurationWatcher)(nil).IsAuthenticated("quicknode", "0x7ae4e5e5b0cd1a2a8f8b7b2e3b9e1c7e4c4a6e11"))
+
+	_, err = NewConfigurationWatcher(CustomersConfig{Signers: map[string][]string{"quicknode": {"0x123"}}})
+	require.Error(t, err)
+}
+
+func TestConfigurationWatcherWebhooks(t *testing.T) {
+	_, err := NewConfigurationWatcher(CustomersConfig{
+		Webhooks: map[string]WebhookConfig{"quicknode": {URL: "https://example.com/hook", Secret: "s", Events: []string{"included"}}},
+	})
+	require.NoError(t, err)
+
+	for _, webhook := range []WebhookConfig{
+		{URL: "example.com/hook", Secret: "s"},
+		{URL: "https://example.com/hook"},
+		{URL: "https://example.com/hook", Secret: "s", Events: []string{"sent"}},
+	} {
+		_, err = NewConfigurationWatcher(CustomersConfig{Webhooks: map[string]WebhookConfig{"quicknode": webhook}})
+		require.Error(t, err, webhook)
+	}
+}
+
+func TestConfigurationWatcherOriginLabel(t *testing.T) {
+	watcher, err := NewConfigurationWatcher(CustomersConfig{
+		URLs: map[string][]string{"quicknode": {"/fast?originId=quicknode"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "quicknode", watcher.OriginLabel("quicknode"))
+	require.Equal(t, "other", watcher.OriginLabel("random-origin"))
+	require.Equal(t, "other", watcher.OriginLabel(""))
+
+	var noWatcher *ConfigurationWatcher
+	require.Equal(t, "other", noWatcher.OriginLabel("quicknode"))
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/flashbots/rpc-endpoint/types"
+)
+
+// DrainState tells the load balancer to stop sending requests, and refuses new txs. A drain ends with an undrain,
+// or at its until time. The zero value is not draining.
+type DrainState struct {
+	mu     sync.RWMutex
+	status types.DrainStatus
+}
+
+// Drain starts draining, until is optional. It replaces the reason and until of a current drain.
+func (d *DrainState) Drain(reason string, until *time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status = types.DrainStatus{Draining: true, DrainReason: reason, DrainUntil: until}
+}
+
+// Undrain ends the drain, and returns whether it was draining
+func (d *DrainState) Undrain() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wasDraining := d.status.Draining
+	d.status = types.DrainStatus{}
+	return wasDraining
+}
+
+// Status returns the current drain, a drain which is past its until time has ended
+func (d *DrainState) Status() types.DrainStatus {
+	if d == nil {
+		return types.DrainStatus{}
+	}
+	d.mu.RLock()
+	status := d.status
+	d.mu.RUnlock()
+	if status.DrainUntil != nil && !Now().Before(*status.DrainUntil) {
+		return types.DrainStatus{}
+	}
+	return status
+}
+
+func (d *DrainState) IsDraining() bool {
+	return d.Status().Draining
+}
+
+// handleDrain marks the server as draining and waits drainSeconds for the load balancer to notice, for pre-stop
+// hooks before a shutdown. It replaces a timed drain, so the server stays drained while shutting down.
+func (s *RpcEndPointServer) handleDrain(respw http.ResponseWriter, req *http.Request) {
+	wasDraining := s.drain.IsDraining()
+	s.drain.Drain("shutdown", nil)
+	s.logger.Info("Server marked as unhealthy")
+	if wasDraining {
+		return
+	}
+
+	// Give LB enough time to detect us unhealthy
+	time.Sleep(
+		time.Duration(s.drainSeconds) * time.Second,
+	)
+}
+
+// handleDrainRequest starts a drain without waiting, e.g. POST /drain?reason=relay+maintenance&until=1700000000.
+// The until time is RFC3339 or unix seconds, and optional.
+func (s *RpcEndPointServer) handleDrainRequest(respw http.ResponseWriter, req *http.Request) {
+	reason := req.URL.Query().Get("reason")
+	until, err := parseDrainUntil(req.URL.Query().Get("until"))
+	if err != nil {
+		http.Error(respw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.drain.Drain(reason, until)
+	s.logger.Info("Server marked as unhealthy", "reason", reason, "until", until)
+	writeJson(respw, s.drain.Status())
+}
+
+func (s *RpcEndPointServer) handleUndrainRequest(respw http.ResponseWriter, req *http.Request) {
+	if s.drain.Undrain() {
+		s.logger.Info("Server marked as healthy")
+	}
+	writeJson(respw, s.drain.Status())
+}
+
+func parseDrainUntil(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	until, err := parseAdminTime(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid until")
+	}
+	if !until.After(Now()) {
+		return nil, errors.New("until is not in the future")
+	}
+	return &until, nil
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/flashbots/rpc-endpoint/types"
+)
+
+func TestDrainUntil(t *testing.T) {
+	s := &RpcEndPointServer{logger: log.New(), drain: &DrainState{}}
+	until := Now().Add(time.Hour)
+
+	rec := httptest.NewRecorder()
+	s.handleDrainRequest(rec, httptest.NewRequest(http.MethodPost, "/drain?reason=relay+maintenance&until="+strconv.FormatInt(until.Unix(), 10), nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.handleHealthRequest(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	var health types.HealthResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &health))
+	require.True(t, health.Draining)
+	require.Equal(t, "relay maintenance", health.DrainReason)
+	require.Equal(t, until.Unix(), health.DrainUntil.Unix())
+
+	// The drain ends at the until time
+	setServerTimeNowOffset(2 * time.Hour)
+	defer setServerTimeNowOffset(0)
+	rec = httptest.NewRecorder()
+	s.handleHealthRequest(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.handleDrainRequest(rec, httptest.NewRequest(http.MethodPost, "/drain?until=2020-01-01T00:00:00Z", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestUndrain(t *testing.T) {
+	s := &RpcEndPointServer{logger: log.New(), drain: &DrainState{}}
+	s.handleDrainRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/drain", nil))
+	require.True(t, s.drain.IsDraining())
+
+	s.handleUndrainRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/undrain", nil))
+	require.False(t, s.drain.IsDraining())
+}
+
+func TestShutdownDrainReplacesTimedDrain(t *testing.T) {
+	s := &RpcEndPointServer{logger: log.New(), drain: &DrainState{}}
+	s.handleDrainRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/drain?reason=maintenance&until="+strconv.FormatInt(Now().Add(time.Hour).Unix(), 10), nil))
+
+	// Already draining, so the pre-stop hook doesn't wait, but the drain doesn't end at the until time anymore
+	s.handleDrain(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	status := s.drain.Status()
+	require.True(t, status.Draining)
+	require.Equal(t, "shutdown", status.DrainReason)
+	require.Nil(t, status.DrainUntil)
+	setServerTimeNowOffset(2 * time.Hour)
+	defer setServerTimeNowOffset(0)
+	require.True(t, s.drain.IsDraining())
+}
+
+func TestRpcRequestHandler_DrainingRefusesTxs(t *testing.T) {
+	drain := &DrainState{}
+	drain.Drain("relay maintenance", nil)
+	chain := &Chain{proxyUrls: []string{""}, builderNameProvider: staticBuilderNames{}}
+
+	wrec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`))
+	var rw http.ResponseWriter = wrec
+	NewRpcRequestHandler(log.New(), &rw, req, chain, 0, nil, database.NewMockStore(), nil, nil, nil, nil, nil, RequestTimeouts{}, drain, nil).process()
+
+	var res types.JsonRpcResponse
+	require.NoError(t, json.Unmarshal(wrec.Body.Bytes(), &res))
+	require.Equal(t, types.JsonRpcDraining, res.Error.Code)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+var (
+	DefaultCustomEthClientPoolSize = 256
+	DefaultCustomEthClientTTL      = 10 * time.Minute
+	// DefaultEthClientMaxFailures is the number of consecutive connection failures after which a client is redialed
+	DefaultEthClientMaxFailures = 3
+)
+
+type DialEthClientFunc func(ctx context.Context, url string) (*ethclient.Client, error)
+
+// EthClientPool keeps ethclients by url, so they are reused between requests. Clients are dialed on first use,
+// closed when unused for the ttl (zero keeps them) or when the pool is full, and dialed again after
+// consecutive connection failures.
+type EthClientPool struct {
+	dial        DialEthClientFunc
+	maxSize     int
+	ttl         time.Duration
+	maxFailures int
+
+	mu      sync.Mutex
+	clients map[string]*pooledEthClient
+}
+
+type pooledEthClient struct {
+	client   *ethclient.Client
+	lastUsed time.Time
+	failures int
+}
+
+func NewEthClientPool(maxSize int, ttl time.Duration, dial DialEthClientFunc) *EthClientPool {
+	return &EthClientPool{
+		dial:        dial,
+		maxSize:     maxSize,
+		ttl:         ttl,
+		maxFailures: DefaultEthClientMaxFailures,
+		clients:     make(map[string]*pooledEthClient),
+	}
+}
+
+// Get returns the client of the url, and dials it if needed
+func (p *EthClientPool) Get(ctx context.Context, url string) (*ethclient.Client, error) {
+	if url == "" {
+		return nil, errors.New("no rpc url")
+	}
+	p.mu.Lock()
+	p.evictExpired(Now())
+	if c, ok := p.clients[url]; ok {
+		c.lastUsed = Now()
+		p.mu.Unlock()
+		return c.client, nil
+	}
+	p.mu.Unlock()
+
+	// Dialing may resolve the host, so it's done without holding the lock
+	client, err := p.dial(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.clients[url]; ok { // dialed concurrently
+		client.Close()
+		return c.client, nil
+	}
+	if len(p.clients) >= p.maxSize {
+		p.evictLeastRecentlyUsed()
+	}
+	p.clients[url] = &pooledEthClient{client: client, lastUsed: Now()}
+	return client, nil
+}
+
+// ReportResult tracks the health of the client of the url by the result of a call. Errors returned by the node
+// don't count as failures, the client is closed after consecutive connection failures.
+func (p *EthClientPool) ReportResult(url string, err error) {
+	var rpcErr rpc.Error
+	healthy := err == nil || errors.As(err, &rpcErr)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.clients[url]
+	if !ok {
+		return
+	}
+	if healthy {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= p.maxFailures {
+		c.client.Close()
+		delete(p.clients, url)
+	}
+}
+
+// Len returns the number of open clients
+func (p *EthClientPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.clients)
+}
+
+// Close closes all clients
+func (p *EthClientPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for url, c := range p.clients {
+		c.client.Close()
+		delete(p.clients, url)
+	}
+}
+
+func (p *EthClientPool) evictExpired(now time.Time) {
+	if p.ttl == 0 {
+		return
+	}
+	for url, c := range p.clients {
+		if now.Sub(c.lastUsed) >= p.ttl {
+			c.client.Close()
+			delete(p.clients, url)
+		}
+	}
+}
+
+func (p *EthClientPool) evictLeastRecentlyUsed() {
+	var oldestUrl string
+	var oldest *pooledEthClient
+	for url, c := range p.clients {
+		if oldest == nil || c.lastUsed.Before(oldest.lastUsed) {
+			oldestUrl, oldest = url, c
+		}
+	}
+	if oldest != nil {
+		oldest.client.Close()
+		delete(p.clients, oldestUrl)
+	}
+}
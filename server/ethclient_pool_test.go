@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/stretchr/testify/require"
+)
+
+type testRpcError struct{}
+
+func (testRpcError) Error() string  { return "nonce too low" }
+func (testRpcError) ErrorCode() int { return -32000 }
+
+func newTestEthClientPool(maxSize int, ttl time.Duration) (*EthClientPool, map[string]int) {
+	dials := make(map[string]int)
+	pool := NewEthClientPool(maxSize, ttl, func(ctx context.Context, url string) (*ethclient.Client, error) {
+		dials[url]++
+		return ethclient.DialContext(ctx, url)
+	})
+	return pool, dials
+}
+
+func TestEthClientPoolReusesClients(t *testing.T) {
+	defer setServerTimeNowOffset(0)
+	pool, dials := newTestEthClientPool(2, time.Minute)
+	defer pool.Close()
+	ctx := context.Background()
+
+	a, err := pool.Get(ctx, "http://a")
+	require.NoError(t, err)
+	a2, err := pool.Get(ctx, "http://a")
+	require.NoError(t, err)
+	require.Same(t, a, a2)
+	_, err = pool.Get(ctx, "")
+	require.Error(t, err)
+
+	// The least recently used client is closed when the pool is full
+	setServerTimeNowOffset(time.Second)
+	_, err = pool.Get(ctx, "http://b")
+	require.NoError(t, err)
+	setServerTimeNowOffset(2 * time.Second)
+	_, err = pool.Get(ctx, "http://a")
+	require.NoError(t, err)
+	_, err = pool.Get(ctx, "http://c")
+	require.NoError(t, err)
+	require.Equal(t, 2, pool.Len())
+	_, err = pool.Get(ctx, "http://b")
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"http://a": 1, "http://b": 2, "http://c": 1}, dials)
+
+	// Unused clients expire
+	setServerTimeNowOffset(2 * time.Minute)
+	_, err = pool.Get(ctx, "http://a")
+	require.NoError(t, err)
+	require.Equal(t, 1, pool.Len())
+	require.Equal(t, 2, dials["http://a"])
+}
+
+func TestEthClientPoolHealth(t *testing.T) {
+	pool, dials := newTestEthClientPool(10, 0)
+	defer pool.Close()
+	ctx := context.Background()
+	_, err := pool.Get(ctx, "http://a")
+	require.NoError(t, err)
+
+	// Errors of the node don't count as failures
+	for i := 0; i < DefaultEthClientMaxFailures; i++ {
+		pool.ReportResult("http://a", testRpcError{})
+	}
+	pool.ReportResult("http://a", errors.New("connection refused"))
+	pool.ReportResult("http://a", nil)
+	require.Equal(t, 1, pool.Len())
+
+	// The client is redialed after consecutive connection failures
+	for i := 0; i < DefaultEthClientMaxFailures; i++ {
+		pool.ReportResult("http://a", errors.New("connection refused"))
+	}
+	require.Equal(t, 0, pool.Len())
+	_, err = pool.Get(ctx, "http://a")
+	require.NoError(t, err)
+	require.Equal(t, 2, dials["http://a"])
+}
@@ -2,22 +2,31 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/flashbots/rpc-endpoint/metrics"
 )
 
+// customUpstreamLabel is the upstream metrics label of urls set by clients
+const customUpstreamLabel = "custom"
+
 type RPCProxyClient interface {
-	ProxyRequest(body []byte) (*http.Response, error)
+	ProxyRequest(ctx context.Context, body []byte) (*http.Response, error)
 }
 
 type rpcProxyClient struct {
 	logger      log.Logger
 	httpClient  http.Client
 	proxyURL    string
+	upstream    string // metrics label, the host of proxyURL
 	fingerprint Fingerprint
+	traced      bool // whether the trace context is passed on, only to configured upstreams
 }
 
 func NewRPCProxyClient(logger log.Logger, proxyURL string, timeoutSeconds int, fingerprint Fingerprint) RPCProxyClient {
@@ -25,13 +34,27 @@ func NewRPCProxyClient(logger log.Logger, proxyURL string, timeoutSeconds int, f
 		logger:      logger,
 		httpClient:  http.Client{Timeout: time.Second * time.Duration(timeoutSeconds)},
 		proxyURL:    proxyURL,
+		upstream:    upstreamLabel(proxyURL),
 		fingerprint: fingerprint,
+		traced:      true,
 	}
 }
 
-// ProxyRequest using http client to make http post request
-func (n *rpcProxyClient) ProxyRequest(body []byte) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodPost, n.proxyURL, bytes.NewBuffer(body))
+// NewCustomRPCProxyClient returns a proxy client for an upstream set by the client, which only connects to
+// addresses allowed by the guard, and doesn't get the trace context
+func NewCustomRPCProxyClient(logger log.Logger, proxyURL string, timeoutSeconds int, fingerprint Fingerprint, guard *UpstreamGuard) RPCProxyClient {
+	return &rpcProxyClient{
+		logger:      logger,
+		httpClient:  *guard.HTTPClient(time.Second * time.Duration(timeoutSeconds)),
+		proxyURL:    proxyURL,
+		upstream:    customUpstreamLabel,
+		fingerprint: fingerprint,
+	}
+}
+
+// ProxyRequest using http client to make http post request, which is cancelled with ctx
+func (n *rpcProxyClient) ProxyRequest(ctx context.Context, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.proxyURL, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
@@ -41,11 +64,25 @@ func (n *rpcProxyClient) ProxyRequest(body []byte) (*http.Response, error) {
 			n.fingerprint.ToIPv6().String(),
 		)
 	}
+	if n.traced {
+		injectTraceContext(ctx, req.Header)
+	}
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
 	start := time.Now()
 	res, err := n.httpClient.Do(req)
-	n.logger.Info("[ProxyRequest] completed", "timeNeeded", time.Since(start))
+	timeNeeded := time.Since(start)
+	n.logger.Info("[ProxyRequest] completed", "timeNeeded", timeNeeded)
+	metrics.ObserveProxyRequest(n.upstream, err != nil, timeNeeded)
 	return res, err
 }
+
+// upstreamLabel returns the host of a configured proxy url, without the path which may contain an api key
+func upstreamLabel(proxyURL string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return metrics.OtherLabel
+	}
+	return u.Host
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/flashbots/rpc-endpoint/metrics"
+	"github.com/flashbots/rpc-endpoint/types"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Defaults for the inclusion tracker
+var (
+	DefaultInclusionPollInterval = 12 * time.Second
+	DefaultInclusionBatchSize    = 200
+)
+
+// ReceiptFetcher is the subset of the eth client used to look up inclusion of txs
+type ReceiptFetcher interface {
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*ethtypes.Receipt, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*ethtypes.Header, error)
+}
+
+// InclusionTracker periodically resolves the on-chain outcome of txs sent to the relay within the tracking window.
+// A tx with a receipt is included (or reverted), a tx the tx status api reports as failed is failed, and a tx
+// without a receipt after the window is expired. Txs are claimed until the next poll, so the trackers of all replicas
+// share the work, and unresolved txs are checked again once their claim expired.
+type InclusionTracker struct {
+	logger    log.Logger
+	store     database.InclusionStore
+	chain     string
+	txApiHost string
+	client    ReceiptFetcher
+	window    time.Duration
+	interval  time.Duration
+	batchSize int
+	webhooks  *WebhookNotifier
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewInclusionTracker tracks the relayed txs of a chain, with the receipts of client and the tx status api at txApiHost.
+// Outcomes are sent to the webhooks of the origins of the txs, if webhooks is set.
+func NewInclusionTracker(logger log.Logger, store database.InclusionStore, chain, txApiHost string, client ReceiptFetcher, window, interval time.Duration, batchSize int, webhooks *WebhookNotifier) *InclusionTracker {
+	return &InclusionTracker{
+		logger:    logger,
+		store:     store,
+		chain:     chain,
+		txApiHost: txApiHost,
+		client:    client,
+		window:    window,
+		interval:  interval,
+		batchSize: batchSize,
+		webhooks:  webhooks,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+func (t *InclusionTracker) Start() {
+	// A poll cancelled by Stop leaves its txs claimed, they are checked again once the claim expired
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-t.stopCh
+		cancel()
+	}()
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := t.Poll(ctx); err != nil {
+					t.logger.Error("[InclusionTracker] poll failed", "error", err)
+				}
+			case <-t.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop cancels a running poll and waits for it to return
+func (t *InclusionTracker) Stop() {
+	t.stopOnce.Do(func() { close(t.stopCh) })
+	t.wg.Wait()
+}
+
+// Poll resolves unresolved txs and saves their outcomes. Txs are looked up for twice the window,
+// so txs which were not included in time are still seen once to be marked as expired.
+func (t *InclusionTracker) Poll(ctx context.Context) error {
+	now := Now()
+	txs, err := t.store.ClaimUnresolvedRelayedTxs(ctx, t.chain, now.Add(-2*t.window), now, now.Add(t.interval), t.batchSize)
+	if err != nil {
+		return errors.Wrap(err, "ClaimUnresolvedRelayedTxs failed")
+	}
+	outcomes := make([]database.InclusionOutcome, 0, len(txs))
+	resolvedTxs := make([]database.RelayedTx, 0, len(txs))
+	for _, tx := range txs {
+		outcome, resolved := t.resolve(ctx, tx)
+		if resolved {
+			outcomes = append(outcomes, outcome)
+			resolvedTxs = append(resolvedTxs, tx)
+		}
+	}
+	inserted, err := t.store.SaveInclusionOutcomes(ctx, outcomes)
+	if err != nil {
+		metrics.IncDatabaseErr()
+		return errors.Wrap(err, "SaveInclusionOutcomes failed")
+	}
+	// Outcomes saved by another tracker in the meantime were already counted and notified
+	isInserted := make(map[uuid.UUID]bool, len(inserted))
+	for _, id := range inserted {
+		isInserted[id] = true
+	}
+	for i, outcome := range outcomes {
+		if !isInserted[outcome.RawTxEntryId] {
+			continue
+		}
+		metrics.IncInclusionOutcome(string(outcome.Status))
+		if outcome.BlockNumber > 0 {
+			metrics.ObserveInclusionLatency(time.Duration(outcome.TimeToInclusionMs) * time.Millisecond)
+		}
+		// The outcome is saved, so its event is queued even if the poll is cancelled
+		t.webhooks.Notify(context.WithoutCancel(ctx), inclusionWebhookEvent(t.chain, resolvedTxs[i], outcome))
+	}
+	return nil
+}
+
+func (t *InclusionTracker) resolve(ctx context.Context, tx database.RelayedTx) (outcome database.InclusionOutcome, resolved bool) {
+	outcome = database.InclusionOutcome{RawTxEntryId: tx.RawTxEntryId, TxHash: tx.TxHash}
+	receipt, err := t.client.TransactionReceipt(ctx, common.HexToHash(tx.TxHash))
+	if err == nil {
+		return t.includedOutcome(ctx, tx, receipt)
+	}
+	if !errors.Is(err, ethereum.NotFound) {
+		metrics.IncEthNodeClusterErr()
+		t.logger.Error("[InclusionTracker] TransactionReceipt failed", "txHash", tx.TxHash, "error", err)
+		return outcome, false
+	}
+
+	status, err := GetTxStatus(ctx, t.txApiHost, tx.TxHash)
+	if err != nil {
+		t.logger.Error("[InclusionTracker] GetTxStatus failed", "txHash", tx.TxHash, "error", err)
+	} else if status.Status == types.TxStatusFailed {
+		outcome.Status = database.InclusionStatusFailed
+		return outcome, true
+	}
+	if Now().Sub(tx.ReceivedAt) > t.window {
+		outcome.Status = database.InclusionStatusExpired
+		return outcome, true
+	}
+	return outcome, false
+}
+
+func (t *InclusionTracker) includedOutcome(ctx context.Context, tx database.RelayedTx, receipt *ethtypes.Receipt) (outcome database.InclusionOutcome, resolved bool) {
+	outcome = database.InclusionOutcome{
+		RawTxEntryId: tx.RawTxEntryId,
+		TxHash:       tx.TxHash,
+		Status:       database.InclusionStatusIncluded,
+		BlockNumber:  receipt.BlockNumber.Int64(),
+		GasUsed:      int64(receipt.GasUsed),
+	}
+	if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+		outcome.Status = database.InclusionStatusReverted
+	}
+	if receipt.EffectiveGasPrice != nil {
+		outcome.EffectiveGasPrice = receipt.EffectiveGasPrice.String()
+		outcome.FeeWei = new(big.Int).Mul(receipt.EffectiveGasPrice, new(big.Int).SetUint64(receipt.GasUsed)).String()
+	}
+
+	header, err := t.client.HeaderByNumber(ctx, receipt.BlockNumber)
+	if err != nil {
+		metrics.IncEthNodeClusterErr()
+		t.logger.Error("[InclusionTracker] HeaderByNumber failed", "block", receipt.BlockNumber, "error", err)
+		return outcome, false
+	}
+	latency := time.Unix(int64(header.Time), 0).Sub(tx.ReceivedAt)
+	if latency < 0 { // block timestamps have second precision
+		latency = 0
+	}
+	outcome.TimeToInclusionMs = latency.Milliseconds()
+	return outcome, true
+}
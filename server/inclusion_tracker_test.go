@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/flashbots/rpc-endpoint/testutils"
+	"github.com/flashbots/rpc-endpoint/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// mockReceiptFetcher returns the receipts by tx hash, all blocks have blockTime
+type mockReceiptFetcher struct {
+	receipts  map[common.Hash]*ethtypes.Receipt
+	blockTime time.Time
+}
+
+func (m *mockReceiptFetcher) TransactionReceipt(ctx context.Context, txHash common.Hash) (*ethtypes.Receipt, error) {
+	if receipt, ok := m.receipts[txHash]; ok {
+		return receipt, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (m *mockReceiptFetcher) HeaderByNumber(ctx context.Context, number *big.Int) (*ethtypes.Header, error) {
+	return &ethtypes.Header{Number: number, Time: uint64(m.blockTime.Unix())}, nil
+}
+
+func addRelayedTx(t *testing.T, store database.Store, txHash string, receivedAt time.Time) uuid.UUID {
+	t.Helper()
+	request := database.RequestEntry{Id: uuid.New(), ReceivedAt: receivedAt}
+	entry := &database.EthSendRawTxEntry{Id: uuid.New(), RequestId: request.Id, TxHash: txHash, WasSentToRelay: true}
+	require.NoError(t, store.SaveRequestEntry(request))
+	require.NoError(t, store.SaveRawTxEntries([]*database.EthSendRawTxEntry{entry}))
+	return entry.Id
+}
+
+func TestInclusionTrackerPoll(t *testing.T) {
+	setupMockTxApi()
+	defer func() { Now = time.Now }()
+	now := time.Unix(1700000000, 0)
+	Now = func() time.Time { return now }
+
+	store := database.NewMemStore()
+	includedHash := common.HexToHash("0x01")
+	revertedHash := common.HexToHash("0x02")
+	failedHash := common.HexToHash("0x03")
+	expiredHash := common.HexToHash("0x04")
+	pendingHash := common.HexToHash("0x05")
+
+	includedId := addRelayedTx(t, store, includedHash.Hex(), now.Add(-30*time.Second))
+	revertedId := addRelayedTx(t, store, revertedHash.Hex(), now.Add(-30*time.Second))
+	failedId := addRelayedTx(t, store, failedHash.Hex(), now.Add(-time.Minute))
+	expiredId := addRelayedTx(t, store, expiredHash.Hex(), now.Add(-15*time.Minute))
+	pendingId := addRelayedTx(t, store, pendingHash.Hex(), now.Add(-time.Minute))
+	testutils.MockTxApiStatusForHash[failedHash.Hex()] = types.TxStatusFailed
+
+	fetcher := &mockReceiptFetcher{
+		blockTime: now.Add(-18 * time.Second),
+		receipts: map[common.Hash]*ethtypes.Receipt{
+			includedHash: {Status: ethtypes.ReceiptStatusSuccessful, BlockNumber: big.NewInt(100), GasUsed: 21000, EffectiveGasPrice: big.NewInt(2e9)},
+			revertedHash: {Status: ethtypes.ReceiptStatusFailed, BlockNumber: big.NewInt(100), GasUsed: 50000, EffectiveGasPrice: big.NewInt(1e9)},
+		},
+	}
+	tracker := NewInclusionTracker(log.New(), store, "", ProtectTxApiHost, fetcher, 10*time.Minute, time.Second, 100, nil)
+	require.NoError(t, tracker.Poll(context.Background()))
+
+	require.Len(t, store.Inclusions, 4)
+	included := store.Inclusions[includedId]
+	require.Equal(t, database.InclusionStatusIncluded, included.Status)
+	require.Equal(t, int64(100), included.BlockNumber)
+	require.Equal(t, int64(21000), included.GasUsed)
+	require.Equal(t, "2000000000", included.EffectiveGasPrice)
+	require.Equal(t, "42000000000000", included.FeeWei)
+	require.Equal(t, int64(12000), included.TimeToInclusionMs)
+
+	require.Equal(t, database.InclusionStatusReverted, store.Inclusions[revertedId].Status)
+	require.Equal(t, database.InclusionStatusFailed, store.Inclusions[failedId].Status)
+	require.Equal(t, database.InclusionStatusExpired, store.Inclusions[expiredId].Status)
+	require.NotContains(t, store.Inclusions, pendingId)
+
+	// The pending tx is claimed until the next poll, so the tracker of another replica skips it
+	fetcher.receipts[pendingHash] = &ethtypes.Receipt{Status: ethtypes.ReceiptStatusSuccessful, BlockNumber: big.NewInt(101), GasUsed: 21000}
+	otherTracker := NewInclusionTracker(log.New(), store, "", ProtectTxApiHost, fetcher, 10*time.Minute, time.Second, 100, nil)
+	require.NoError(t, otherTracker.Poll(context.Background()))
+	require.Len(t, store.Inclusions, 4)
+
+	// The pending tx is resolved once it has a receipt
+	now = now.Add(time.Second)
+	require.NoError(t, tracker.Poll(context.Background()))
+	require.Len(t, store.Inclusions, 5)
+	require.Empty(t, store.InclusionClaims)
+	require.Equal(t, database.InclusionStatusIncluded, store.Inclusions[pendingId].Status)
+}
+
+// blockingReceiptFetcher blocks until the ctx of the call is done
+type blockingReceiptFetcher struct {
+	called chan struct{}
+}
+
+func (m *blockingReceiptFetcher) TransactionReceipt(ctx context.Context, txHash common.Hash) (*ethtypes.Receipt, error) {
+	select {
+	case m.called <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (m *blockingReceiptFetcher) HeaderByNumber(ctx context.Context, number *big.Int) (*ethtypes.Header, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestInclusionTrackerStopCancelsPoll(t *testing.T) {
+	store := database.NewMemStore()
+	addRelayedTx(t, store, common.HexToHash("0x01").Hex(), time.Now().Add(-time.Minute))
+	fetcher := &blockingReceiptFetcher{called: make(chan struct{}, 1)}
+	tracker := NewInclusionTracker(log.New(), store, "", ProtectTxApiHost, fetcher, 10*time.Minute, 10*time.Millisecond, 100, nil)
+	tracker.Start()
+	select {
+	case <-fetcher.called:
+	case <-time.After(time.Second):
+		t.Fatal("tracker did not poll")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		tracker.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not cancel the running poll")
+	}
+	require.Empty(t, store.Inclusions)
+}
+
+func TestInclusionTrackerClaimsOldestCheckedFirst(t *testing.T) {
+	setupMockTxApi()
+	defer func() { Now = time.Now }()
+	now := time.Unix(1700000000, 0)
+	Now = func() time.Time { return now }
+
+	store := database.NewMemStore()
+	oldId := addRelayedTx(t, store, common.HexToHash("0x11").Hex(), now.Add(-2*time.Minute))
+	newId := addRelayedTx(t, store, common.HexToHash("0x12").Hex(), now.Add(-time.Minute))
+	fetcher := &mockReceiptFetcher{receipts: map[common.Hash]*ethtypes.Receipt{}}
+	tracker := NewInclusionTracker(log.New(), store, "", ProtectTxApiHost, fetcher, 10*time.Minute, time.Second, 1, nil)
+
+	// A tx which can't be resolved doesn't keep newer txs from being checked
+	require.NoError(t, tracker.Poll(context.Background()))
+	require.Contains(t, store.InclusionClaims, oldId)
+	require.NotContains(t, store.InclusionClaims, newId)
+
+	now = now.Add(time.Second)
+	require.NoError(t, tracker.Poll(context.Background()))
+	require.Equal(t, now.Add(time.Second), store.InclusionClaims[newId])
+	require.Equal(t, now, store.InclusionClaims[oldId])
+}
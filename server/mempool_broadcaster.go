@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/metrics"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Defaults for the mempool broadcaster
+var (
+	DefaultMempoolBroadcastPollInterval = time.Second
+	DefaultMempoolBroadcastBatchSize    = 100
+	DefaultMempoolBroadcastLease        = time.Minute // must be longer than customMempoolTimeout
+)
+
+// mempoolBroadcast is a scheduled broadcast of a tx which was sent to the relay
+type mempoolBroadcast struct {
+	Chain        string    `json:"chain,omitempty"`
+	RawTxEntryId uuid.UUID `json:"rawTxEntryId"`
+	RawTx        string    `json:"rawTx"`
+	TxFrom       string    `json:"txFrom"`
+	MempoolRPC   string    `json:"mempoolRpc,omitempty"` // set by the user, else the mempool RPC of the chain is used
+}
+
+// MempoolBroadcaster sends the txs of users who set useMempool to the mempool, after a delay which gives the
+// relay the chance to include them privately first. Broadcasts are scheduled in the StateStore, so they are sent
+// by any instance sharing the Redis, also if the instance which scheduled them was stopped in the meantime.
+// The broadcasts of all chains are scheduled in the state of the default chain.
+type MempoolBroadcaster struct {
+	logger        log.Logger
+	state         StateStore
+	chains        *chainRouter
+	upstreamGuard *UpstreamGuard
+	delay         time.Duration
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewMempoolBroadcaster(logger log.Logger, state StateStore, chains *chainRouter, upstreamGuard *UpstreamGuard, delay time.Duration) *MempoolBroadcaster {
+	return &MempoolBroadcaster{
+		logger:        logger,
+		state:         state,
+		chains:        chains,
+		upstreamGuard: upstreamGuard,
+		delay:         delay,
+		stopCh:        make(chan struct{}),
+	}
+}
+
+// Schedule adds the broadcast to the StateStore, it is due after the delay
+func (b *MempoolBroadcaster) Schedule(ctx context.Context, broadcast mempoolBroadcast) error {
+	data, err := json.Marshal(broadcast)
+	if err != nil {
+		return errors.Wrap(err, "marshal failed")
+	}
+	if err = b.state.AddMempoolBroadcast(ctx, string(data), Now().Add(b.delay)); err != nil {
+		metrics.IncRedisErr()
+		return errors.Wrap(err, "AddMempoolBroadcast failed")
+	}
+	return nil
+}
+
+func (b *MempoolBroadcaster) Start() {
+	if b == nil {
+		return
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ticker := time.NewTicker(DefaultMempoolBroadcastPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := b.Broadcast(context.Background()); err != nil {
+					metrics.IncRedisErr()
+					b.logger.Error("[MempoolBroadcaster] broadcast failed", "error", err)
+				}
+			case <-b.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for running broadcasts to finish, or until ctx is done. Broadcasts which are not due yet stay
+// scheduled, and claimed ones which didn't finish are due again after DefaultMempoolBroadcastLease.
+func (b *MempoolBroadcaster) Stop(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	b.stopOnce.Do(func() { close(b.stopCh) })
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Broadcast sends the due txs to the mempool, in parallel
+func (b *MempoolBroadcaster) Broadcast(ctx context.Context) error {
+	now := Now()
+	broadcasts, err := b.state.ClaimMempoolBroadcasts(ctx, now, now.Add(DefaultMempoolBroadcastLease), DefaultMempoolBroadcastBatchSize)
+	if err != nil {
+		return errors.Wrap(err, "ClaimMempoolBroadcasts failed")
+	}
+	var wg sync.WaitGroup
+	for _, broadcast := range broadcasts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sendCtx, cancel := context.WithTimeout(ctx, customMempoolTimeout)
+			defer cancel()
+			b.broadcast(sendCtx, broadcast)
+			b.delete(ctx, broadcast)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// broadcast sends the tx to the mempool, unless it was cancelled, replaced or included in the meantime
+func (b *MempoolBroadcaster) broadcast(ctx context.Context, rawBroadcast string) {
+	var broadcast mempoolBroadcast
+	if err := json.Unmarshal([]byte(rawBroadcast), &broadcast); err != nil {
+		b.logger.Error("[MempoolBroadcaster] invalid broadcast", "error", err, "broadcast", rawBroadcast)
+		metrics.IncMempoolBroadcastSkipped("error")
+		return
+	}
+	logger := b.logger.New("chain", broadcast.Chain, "rawTxEntryId", broadcast.RawTxEntryId)
+	chain, ok := b.chains.chain(broadcast.Chain)
+	if !ok {
+		logger.Error("[MempoolBroadcaster] unknown chain")
+		metrics.IncMempoolBroadcastSkipped("error")
+		return
+	}
+	tx, err := GetTx(broadcast.RawTx)
+	if err != nil {
+		logger.Error("[MempoolBroadcaster] invalid raw tx", "error", err)
+		metrics.IncMempoolBroadcastSkipped("error")
+		return
+	}
+	txHash := strings.ToLower(tx.Hash().Hex())
+	logger = logger.New("txHash", txHash)
+
+	cancelled, err := chain.state.GetTxCancelled(ctx, txHash)
+	if err != nil {
+		metrics.IncRedisErr()
+		logger.Error("[MempoolBroadcaster] Redis:GetTxCancelled failed", "error", err)
+		metrics.IncMempoolBroadcastSkipped("error")
+		return
+	}
+	if cancelled {
+		logger.Info("[MempoolBroadcaster] tx was cancelled")
+		metrics.IncMempoolBroadcastSkipped("cancelled")
+		return
+	}
+	latestTxHash, found, err := chain.state.GetTxHashForSenderAndNonce(ctx, strings.ToLower(broadcast.TxFrom), tx.Nonce())
+	if err != nil {
+		metrics.IncRedisErr()
+		logger.Error("[MempoolBroadcaster] Redis:GetTxHashForSenderAndNonce failed", "error", err)
+		metrics.IncMempoolBroadcastSkipped("error")
+		return
+	}
+	if found && latestTxHash != txHash {
+		logger.Info("[MempoolBroadcaster] tx was replaced", "replacementTxHash", latestTxHash)
+		metrics.IncMempoolBroadcastSkipped("replaced")
+		return
+	}
+
+	client, reportResult, err := mempoolClient(ctx, chain.mempoolClients, chain.mempoolRPC, b.upstreamGuard, broadcast.MempoolRPC)
+	if err != nil {
+		logger.Error("[MempoolBroadcaster] mempool client failed", "error", err)
+		metrics.IncMempoolBroadcastSkipped("error")
+		return
+	}
+	if receipt, err := client.TransactionReceipt(ctx, tx.Hash()); err == nil && receipt != nil {
+		logger.Info("[MempoolBroadcaster] tx already included", "block", receipt.BlockNumber)
+		metrics.IncMempoolBroadcastSkipped("included")
+		return
+	}
+	if err = sendTxToMempool(ctx, client, reportResult, tx); err != nil {
+		logger.Error("[MempoolBroadcaster] Sending to mempool failed", "error", err)
+		return
+	}
+	logger.Info("[MempoolBroadcaster] Sent to mempool")
+}
+
+func (b *MempoolBroadcaster) delete(ctx context.Context, broadcast string) {
+	if err := b.state.DelMempoolBroadcast(ctx, broadcast); err != nil {
+		metrics.IncRedisErr()
+		b.logger.Error("[MempoolBroadcaster] DelMempoolBroadcast failed", "error", err)
+	}
+}
+
+// mempoolClient returns the client of the mempoolRPC set by the user, or else of the mempool RPC of the chain,
+// and a func to report the result of using it
+func mempoolClient(ctx context.Context, mempoolClients *EthClientPool, mempoolRPC string, upstreamGuard *UpstreamGuard, customMempoolRPC string) (client *ethclient.Client, reportResult func(error), err error) {
+	if customMempoolRPC != "" {
+		client, err = upstreamGuard.EthClient(ctx, customMempoolRPC)
+		return client, func(err error) { upstreamGuard.ReportEthClientResult(customMempoolRPC, err) }, err
+	}
+	client, err = mempoolClients.Get(ctx, mempoolRPC)
+	return client, func(err error) { mempoolClients.ReportResult(mempoolRPC, err) }, err
+}
+
+// sendTxToMempool sends the tx with the client, a tx which is already known counts as sent
+func sendTxToMempool(ctx context.Context, client *ethclient.Client, reportResult func(error), tx *ethtypes.Transaction) error {
+	err := client.SendTransaction(ctx, tx)
+	reportResult(err)
+	if err != nil && !strings.Contains(err.Error(), "already known") {
+		metrics.IncEthNodeClusterErr()
+		return err
+	}
+	return nil
+}
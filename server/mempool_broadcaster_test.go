@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/flashbots/rpc-endpoint/testutils"
+	"github.com/flashbots/rpc-endpoint/types"
+	"github.com/stretchr/testify/require"
+)
+
+// newMempoolBroadcastTestServer returns a server with the mempool broadcast enabled which was sent a tx, its store,
+// and the methods called on the mempool RPC
+func newMempoolBroadcastTestServer(t *testing.T, relayRejects bool, delay time.Duration) (*RpcEndPointServer, database.Reader, func() []string) {
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(redisServer.Close)
+	node := httptest.NewServer(http.HandlerFunc(testutils.RpcBackendHandler))
+	t.Cleanup(node.Close)
+
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if relayRejects {
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"tx rejected"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":"%s"}`, testutils.TestTx_BundleFailedTooManyTimes_Hash)
+	}))
+	t.Cleanup(relay.Close)
+
+	var mu sync.Mutex
+	var mempoolCalls []string
+	mempool := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var jsonReq types.JsonRpcRequest
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&jsonReq))
+		mu.Lock()
+		mempoolCalls = append(mempoolCalls, jsonReq.Method)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if jsonReq.Method == "eth_sendRawTransaction" {
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%v,"result":"%s"}`, jsonReq.Id, testutils.TestTx_BundleFailedTooManyTimes_Hash)
+			return
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%v,"result":null}`, jsonReq.Id)
+	}))
+	t.Cleanup(mempool.Close)
+
+	signingKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	memStore := database.NewMemStore()
+	s, err := NewRpcEndPointServer(Configuration{
+		RelaySigningKey:       signingKey,
+		DB:                    memStore,
+		Logger:                log.New(),
+		ProxyTimeoutSeconds:   1,
+		ProxyUrl:              node.URL,
+		RedisUrl:              redisServer.Addr(),
+		RelayUrl:              relay.URL,
+		DefaultMempoolRPC:     mempool.URL,
+		MempoolBroadcast:      true,
+		MempoolBroadcastDelay: delay,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.mempoolBroadcaster.Stop(context.Background()) })
+
+	body, err := json.Marshal(types.NewJsonRpcRequest(1, "eth_sendRawTransaction", []interface{}{testutils.TestTx_BundleFailedTooManyTimes_RawTx}))
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	s.HandleHttpRequest(rec, httptest.NewRequest(http.MethodPost, "/?useMempool=true", bytes.NewReader(body)))
+	var res types.JsonRpcResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Nil(t, res.Error)
+
+	getMempoolCalls := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, mempoolCalls...)
+	}
+	return s, memStore, getMempoolCalls
+}
+
+func TestMempoolBroadcastAfterDelay(t *testing.T) {
+	defer setServerTimeNowOffset(0)
+	s, store, mempoolCalls := newMempoolBroadcastTestServer(t, false, time.Hour)
+
+	// The record is saved right away, before the broadcast
+	entry := waitForRawTxEntries(t, store, 1)[0]
+	require.True(t, entry.WasSentToRelay)
+	require.False(t, entry.WasSentToMempool)
+
+	require.NoError(t, s.mempoolBroadcaster.Broadcast(context.Background()))
+	require.Empty(t, mempoolCalls())
+
+	setServerTimeNowOffset(time.Hour + time.Second)
+	require.NoError(t, s.mempoolBroadcaster.Broadcast(context.Background()))
+	require.Equal(t, []string{"eth_getTransactionReceipt", "eth_sendRawTransaction"}, mempoolCalls())
+
+	// A sent broadcast is removed from the state
+	require.NoError(t, s.mempoolBroadcaster.Broadcast(context.Background()))
+	require.Len(t, mempoolCalls(), 2)
+}
+
+func TestMempoolBroadcastOnRelayError(t *testing.T) {
+	_, store, mempoolCalls := newMempoolBroadcastTestServer(t, true, time.Hour)
+	entry := waitForRawTxEntries(t, store, 1)[0]
+	require.Equal(t, []string{"eth_sendRawTransaction"}, mempoolCalls())
+	require.True(t, entry.WasSentToMempool)
+	require.Zero(t, entry.ErrorCode)
+}
+
+func TestMempoolBroadcastSurvivesRestart(t *testing.T) {
+	defer setServerTimeNowOffset(0)
+	s, _, mempoolCalls := newMempoolBroadcastTestServer(t, false, time.Hour)
+	require.NoError(t, s.mempoolBroadcaster.Stop(context.Background()))
+	require.Empty(t, mempoolCalls())
+
+	// The broadcast is scheduled in the state, so it is sent by the next instance
+	next := NewMempoolBroadcaster(log.New(), s.chains.defaultChain.state, s.chains, s.upstreamGuard, time.Hour)
+	setServerTimeNowOffset(time.Hour + time.Second)
+	require.NoError(t, next.Broadcast(context.Background()))
+	require.Equal(t, []string{"eth_getTransactionReceipt", "eth_sendRawTransaction"}, mempoolCalls())
+}
+
+func TestMempoolBroadcastSkipsCancelledOrReplacedTx(t *testing.T) {
+	defer setServerTimeNowOffset(0)
+	txFrom := strings.ToLower(testutils.TestTx_BundleFailedTooManyTimes_From)
+	nonce, err := hexutil.DecodeUint64(testutils.TestTx_BundleFailedTooManyTimes_Nonce)
+	require.NoError(t, err)
+	tests := map[string]func(ctx context.Context, state StateStore) error{
+		"cancelled": func(ctx context.Context, state StateStore) error {
+			return state.SetTxCancelled(ctx, testutils.TestTx_BundleFailedTooManyTimes_Hash)
+		},
+		"replaced": func(ctx context.Context, state StateStore) error {
+			return state.SetTxHashForSenderAndNonce(ctx, txFrom, nonce, testutils.TestTx_CancelAtRelay_Cancel_Hash)
+		},
+	}
+	for name, update := range tests {
+		t.Run(name, func(t *testing.T) {
+			setServerTimeNowOffset(0)
+			s, _, mempoolCalls := newMempoolBroadcastTestServer(t, false, time.Hour)
+			require.NoError(t, update(context.Background(), s.chains.defaultChain.state))
+			setServerTimeNowOffset(time.Hour + time.Second)
+			require.NoError(t, s.mempoolBroadcaster.Broadcast(context.Background()))
+			require.Empty(t, mempoolCalls())
+		})
+	}
+}
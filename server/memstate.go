@@ -0,0 +1,320 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memStateSweepInterval is the min time between removals of expired entries, which are otherwise only
+// dropped when they are read
+var memStateSweepInterval = time.Minute
+
+type memStateEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// MemState is an in-process StateStore with the same keys and expiries as RedisState.
+// The state is not shared between instances, so it is meant for development and tests.
+type MemState struct {
+	mu        sync.Mutex
+	entries   map[string]memStateEntry
+	nextSweep time.Time
+	keys      RedisKeys
+	opts      StateOptions
+
+	webhookDeliveries map[string]time.Time // queued deliveries and the time they are due
+	mempoolBroadcasts map[string]time.Time // scheduled broadcasts and the time they are due
+}
+
+func NewMemState(opts StateOptions) (*MemState, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return &MemState{
+		entries:           make(map[string]memStateEntry),
+		nextSweep:         Now().Add(memStateSweepInterval),
+		webhookDeliveries: make(map[string]time.Time),
+		mempoolBroadcasts: make(map[string]time.Time),
+		keys:              NewRedisKeys(opts.Namespace),
+		opts:              opts,
+	}, nil
+}
+
+func (s *MemState) Ping(ctx context.Context) error {
+	return nil
+}
+
+// get returns the value of the key if it has not expired, must be called with the mutex held
+func (s *MemState) get(key string) (interface{}, bool) {
+	entry, found := s.entries[key]
+	if !found {
+		return nil, false
+	}
+	if !Now().Before(entry.expiresAt) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// set must be called with the mutex held
+func (s *MemState) set(key string, value interface{}, expiry time.Duration) {
+	now := Now()
+	s.entries[key] = memStateEntry{value: value, expiresAt: now.Add(expiry)}
+	if now.After(s.nextSweep) {
+		for k, entry := range s.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.nextSweep = now.Add(memStateSweepInterval)
+	}
+}
+
+func (s *MemState) getString(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, found := s.get(key)
+	if !found {
+		return "", false
+	}
+	return val.(string), true
+}
+
+func (s *MemState) getUint64(key string) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, found := s.get(key)
+	if !found {
+		return 0, false
+	}
+	return val.(uint64), true
+}
+
+func (s *MemState) setValue(key string, value interface{}, expiry time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, value, expiry)
+	return nil
+}
+
+func (s *MemState) del(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemState) SetTxSentToRelay(ctx context.Context, txHash string) error {
+	// Second precision, like the unix timestamp stored in redis
+	return s.setValue(s.keys.TxSentToRelay(txHash), time.Unix(Now().Unix(), 0), s.opts.TxSentToRelayExpiry)
+}
+
+func (s *MemState) GetTxSentToRelay(ctx context.Context, txHash string) (timeSent time.Time, found bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, found := s.get(s.keys.TxSentToRelay(txHash))
+	if !found {
+		return time.Time{}, false, nil
+	}
+	return val.(time.Time), true, nil
+}
+
+func (s *MemState) SetTxCancelled(ctx context.Context, txHash string) error {
+	return s.setValue(s.keys.TxCancelled(txHash), true, s.opts.TxSentToRelayExpiry)
+}
+
+func (s *MemState) GetTxCancelled(ctx context.Context, txHash string) (found bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, found = s.get(s.keys.TxCancelled(txHash))
+	return found, nil
+}
+
+func (s *MemState) SetTxHashForSenderAndNonce(ctx context.Context, txFrom string, nonce uint64, txHash string) error {
+	return s.setValue(s.keys.TxHashForSenderAndNonce(txFrom, nonce), strings.ToLower(txHash), s.opts.TxHashForSenderAndNonceExpiry)
+}
+
+func (s *MemState) GetTxHashForSenderAndNonce(ctx context.Context, txFrom string, nonce uint64) (txHash string, found bool, err error) {
+	txHash, found = s.getString(s.keys.TxHashForSenderAndNonce(txFrom, nonce))
+	return txHash, found, nil
+}
+
+func (s *MemState) SetNonceFixForAccount(ctx context.Context, txFrom string, numTimesSent uint64) error {
+	return s.setValue(s.keys.NonceFixForAccount(txFrom), numTimesSent, s.opts.NonceFixForAccountExpiry)
+}
+
+func (s *MemState) IncNonceFixForAccount(ctx context.Context, txFrom string) (numTimesSent uint64, found bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.keys.NonceFixForAccount(txFrom)
+	val, found := s.get(key)
+	if !found {
+		return 0, false, nil
+	}
+	// Keep the expiry, like INCR
+	entry := s.entries[key]
+	numTimesSent = val.(uint64) + 1
+	entry.value = numTimesSent
+	s.entries[key] = entry
+	return numTimesSent, true, nil
+}
+
+func (s *MemState) DelNonceFixForAccount(ctx context.Context, txFrom string) error {
+	return s.del(s.keys.NonceFixForAccount(txFrom))
+}
+
+func (s *MemState) GetNonceFixForAccount(ctx context.Context, txFrom string) (numTimesSent uint64, found bool, err error) {
+	numTimesSent, found = s.getUint64(s.keys.NonceFixForAccount(txFrom))
+	return numTimesSent, found, nil
+}
+
+func (s *MemState) SetSenderAndNonceOfTxHash(ctx context.Context, txHash string, txFrom string, txNonce uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(s.keys.SenderOfTxHash(txHash), strings.ToLower(txFrom), s.opts.SenderAndNonceOfTxHashExpiry)
+	s.set(s.keys.NonceOfTxHash(txHash), txNonce, s.opts.SenderAndNonceOfTxHashExpiry)
+	return nil
+}
+
+func (s *MemState) GetSenderOfTxHash(ctx context.Context, txHash string) (txSender string, found bool, err error) {
+	txSender, found = s.getString(s.keys.SenderOfTxHash(txHash))
+	return txSender, found, nil
+}
+
+func (s *MemState) GetNonceOfTxHash(ctx context.Context, txHash string) (txNonce uint64, found bool, err error) {
+	txNonce, found = s.getUint64(s.keys.NonceOfTxHash(txHash))
+	return txNonce, found, nil
+}
+
+// AddTxToWhitehatBundle keeps the latest 16 txs, newest first, like the LPUSH and LTRIM of RedisState
+func (s *MemState) AddTxToWhitehatBundle(ctx context.Context, bundleId string, signedTx string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.keys.WhitehatBundleTransactions(bundleId)
+	var txs []string
+	if val, found := s.get(key); found {
+		txs = val.([]string)
+	}
+	for _, tx := range txs {
+		if tx == signedTx {
+			return nil
+		}
+	}
+	txs = append([]string{signedTx}, txs...)
+	if len(txs) > 16 {
+		txs = txs[:16]
+	}
+	s.set(key, txs, s.opts.WhitehatBundleExpiry)
+	return nil
+}
+
+func (s *MemState) GetWhitehatBundleTx(ctx context.Context, bundleId string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, found := s.get(s.keys.WhitehatBundleTransactions(bundleId))
+	if !found {
+		return []string{}, nil
+	}
+	return append([]string{}, val.([]string)...), nil
+}
+
+func (s *MemState) DelWhitehatBundleTx(ctx context.Context, bundleId string) error {
+	return s.del(s.keys.WhitehatBundleTransactions(bundleId))
+}
+
+func (s *MemState) SetSenderMaxNonce(ctx context.Context, txFrom string, nonce uint64, blockRange int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.keys.SenderMaxNonce(txFrom)
+	// Do nothing if current nonce is not higher than already existing
+	if prevMaxNonce, found := s.get(key); found && prevMaxNonce.(uint64) >= nonce {
+		return nil
+	}
+	s.set(key, nonce, s.opts.SenderMaxNonceExpiry(blockRange))
+	return nil
+}
+
+func (s *MemState) GetSenderMaxNonce(ctx context.Context, txFrom string) (senderMaxNonce uint64, found bool, err error) {
+	senderMaxNonce, found = s.getUint64(s.keys.SenderMaxNonce(txFrom))
+	return senderMaxNonce, found, nil
+}
+
+func (s *MemState) DelSenderMaxNonce(ctx context.Context, txFrom string) error {
+	return s.del(s.keys.SenderMaxNonce(txFrom))
+}
+
+func (s *MemState) SetBlockedTxHash(ctx context.Context, txHash string, returnValue string) error {
+	return s.setValue(s.keys.BlockedTxHash(txHash), returnValue, s.opts.BlockedTxHashExpiry)
+}
+
+func (s *MemState) GetBlockedTxHash(ctx context.Context, txHash string) (returnValue string, found bool, err error) {
+	returnValue, found = s.getString(s.keys.BlockedTxHash(txHash))
+	return returnValue, found, nil
+}
+
+func (s *MemState) AddWebhookDelivery(ctx context.Context, delivery string, due time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhookDeliveries[delivery] = due
+	return nil
+}
+
+// ClaimWebhookDeliveries returns the deliveries which are due first, like the sorted set of RedisState
+func (s *MemState) ClaimWebhookDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return claimDue(s.webhookDeliveries, now, leaseUntil, limit), nil
+}
+
+func (s *MemState) DelWebhookDelivery(ctx context.Context, delivery string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.webhookDeliveries, delivery)
+	return nil
+}
+
+func (s *MemState) AddMempoolBroadcast(ctx context.Context, broadcast string, due time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mempoolBroadcasts[broadcast] = due
+	return nil
+}
+
+func (s *MemState) ClaimMempoolBroadcasts(ctx context.Context, now, leaseUntil time.Time, limit int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return claimDue(s.mempoolBroadcasts, now, leaseUntil, limit), nil
+}
+
+func (s *MemState) DelMempoolBroadcast(ctx context.Context, broadcast string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mempoolBroadcasts, broadcast)
+	return nil
+}
+
+// claimDue returns up to limit members of the queue which are due, the earliest first, and makes them due again
+// at leaseUntil. The caller must hold s.mu.
+func claimDue(queue map[string]time.Time, now, leaseUntil time.Time, limit int) []string {
+	var due []string
+	for member, dueAt := range queue {
+		if !dueAt.After(now) {
+			due = append(due, member)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return queue[due[i]].Before(queue[due[j]])
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for _, member := range due {
+		queue[member] = leaseUntil
+	}
+	return due
+}
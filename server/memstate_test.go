@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMemState(t *testing.T) *MemState {
+	state, err := NewMemState(DefaultStateOptions)
+	require.NoError(t, err)
+	return state
+}
+
+func TestMemStateExpiry(t *testing.T) {
+	ctx := context.Background()
+	defer setServerTimeNowOffset(0)
+	state := newTestMemState(t)
+
+	require.NoError(t, state.SetTxSentToRelay(ctx, "0xFoo"))
+	require.NoError(t, state.SetTxCancelled(ctx, "0xFoo"))
+	require.NoError(t, state.SetBlockedTxHash(ctx, "0xFoo", "nonce too low"))
+	require.NoError(t, state.SetSenderMaxNonce(ctx, "0xSender", 5, 0))
+
+	timeSent, found, err := state.GetTxSentToRelay(ctx, "0xfoo")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.True(t, time.Since(timeSent) < time.Second)
+	found, err = state.GetTxCancelled(ctx, "0xfoo")
+	require.NoError(t, err)
+	require.True(t, found)
+
+	// Max nonce expires before the other entries
+	setServerTimeNowOffset(DefaultStateOptions.SenderMaxNonceExpiry(0))
+	_, found, err = state.GetSenderMaxNonce(ctx, "0xSender")
+	require.NoError(t, err)
+	require.False(t, found)
+	retVal, found, err := state.GetBlockedTxHash(ctx, "0xFOO")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "nonce too low", retVal)
+
+	setServerTimeNowOffset(DefaultStateOptions.TxSentToRelayExpiry)
+	_, found, err = state.GetTxSentToRelay(ctx, "0xFoo")
+	require.NoError(t, err)
+	require.False(t, found)
+	_, found, err = state.GetBlockedTxHash(ctx, "0xFoo")
+	require.NoError(t, err)
+	require.False(t, found)
+	found, err = state.GetTxCancelled(ctx, "0xFoo")
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestMemStateSweepsExpiredEntries(t *testing.T) {
+	ctx := context.Background()
+	defer setServerTimeNowOffset(0)
+	state := newTestMemState(t)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, state.SetTxSentToRelay(ctx, fmt.Sprintf("0x%d", i)))
+	}
+	require.Len(t, state.entries, 10)
+
+	setServerTimeNowOffset(DefaultStateOptions.TxSentToRelayExpiry + memStateSweepInterval)
+	require.NoError(t, state.SetTxSentToRelay(ctx, "0xNew"))
+	require.Len(t, state.entries, 1)
+}
+
+func TestMemStateSenderMaxNonce(t *testing.T) {
+	ctx := context.Background()
+	defer setServerTimeNowOffset(0)
+	state := newTestMemState(t)
+
+	require.NoError(t, state.SetSenderMaxNonce(ctx, "0xSender", 17, 0))
+	require.NoError(t, state.SetSenderMaxNonce(ctx, "0xSender", 16, 0))
+	nonce, found, err := state.GetSenderMaxNonce(ctx, "0xSENDER")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(17), nonce)
+
+	// The expiry follows the block range
+	require.NoError(t, state.SetSenderMaxNonce(ctx, "0xSender", 18, 100))
+	setServerTimeNowOffset(DefaultStateOptions.SenderMaxNonceExpiry(0) + time.Minute)
+	nonce, found, err = state.GetSenderMaxNonce(ctx, "0xSender")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(18), nonce)
+
+	require.NoError(t, state.DelSenderMaxNonce(ctx, "0xSender"))
+	_, found, err = state.GetSenderMaxNonce(ctx, "0xSender")
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestMemStateAccountState(t *testing.T) {
+	ctx := context.Background()
+	state := newTestMemState(t)
+
+	require.NoError(t, state.SetTxHashForSenderAndNonce(ctx, "0xSender", 3, "0xTxHash"))
+	txHash, found, err := state.GetTxHashForSenderAndNonce(ctx, "0xsender", 3)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "0xtxhash", txHash)
+
+	require.NoError(t, state.SetSenderAndNonceOfTxHash(ctx, "0xTxHash", "0xSender", 3))
+	sender, found, err := state.GetSenderOfTxHash(ctx, "0xtxhash")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "0xsender", sender)
+	nonce, found, err := state.GetNonceOfTxHash(ctx, "0xTXHASH")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(3), nonce)
+
+	require.NoError(t, state.SetNonceFixForAccount(ctx, "0xSender", 2))
+	numTimesSent, found, err := state.GetNonceFixForAccount(ctx, strings.ToUpper("0xSender"))
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(2), numTimesSent)
+	require.NoError(t, state.DelNonceFixForAccount(ctx, "0xSender"))
+	_, found, err = state.GetNonceFixForAccount(ctx, "0xSender")
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestMemStateWhitehatBundle(t *testing.T) {
+	ctx := context.Background()
+	state := newTestMemState(t)
+	bundleId := "123"
+
+	txs, err := state.GetWhitehatBundleTx(ctx, bundleId)
+	require.NoError(t, err)
+	require.Empty(t, txs)
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, state.AddTxToWhitehatBundle(ctx, bundleId, fmt.Sprintf("0x%d", i)))
+	}
+	require.NoError(t, state.AddTxToWhitehatBundle(ctx, bundleId, "0x19"))
+
+	txs, err = state.GetWhitehatBundleTx(ctx, bundleId)
+	require.NoError(t, err)
+	require.Len(t, txs, 16)
+	require.Equal(t, "0x19", txs[0])
+	require.Equal(t, "0x4", txs[15])
+
+	require.NoError(t, state.DelWhitehatBundleTx(ctx, bundleId))
+	txs, err = state.GetWhitehatBundleTx(ctx, bundleId)
+	require.NoError(t, err)
+	require.Empty(t, txs)
+}
+
+func TestMemStateMempoolBroadcasts(t *testing.T) {
+	ctx := context.Background()
+	state := newTestMemState(t)
+	now := time.Unix(1700000000, 0)
+
+	require.NoError(t, state.AddMempoolBroadcast(ctx, "a", now.Add(-time.Second)))
+	require.NoError(t, state.AddMempoolBroadcast(ctx, "b", now.Add(-2*time.Second)))
+	require.NoError(t, state.AddMempoolBroadcast(ctx, "c", now.Add(time.Second)))
+
+	broadcasts, err := state.ClaimMempoolBroadcasts(ctx, now, now.Add(time.Minute), 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, broadcasts)
+	broadcasts, err = state.ClaimMempoolBroadcasts(ctx, now, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, broadcasts)
+
+	require.NoError(t, state.DelMempoolBroadcast(ctx, "a"))
+	broadcasts, err = state.ClaimMempoolBroadcasts(ctx, now.Add(time.Minute), now.Add(2*time.Minute), 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "b"}, broadcasts)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// rejectedMethods would send a tx to the public mempool through the node, they are rejected regardless of any policy
+var rejectedMethods = map[string]bool{
+	"eth_sendTransaction":               true,
+	"personal_sendTransaction":          true,
+	"eth_sendRawTransactionConditional": true,
+	"eth_sendRawTransactionSync":        true,
+}
+
+// DefaultDeniedMethods are node internals which are not served by default
+var DefaultDeniedMethods = []string{"debug_*", "admin_*", "txpool_*", "personal_*", "miner_*", "engine_*"}
+
+// MethodPolicy decides which JSON-RPC methods are served, with glob patterns like debug_*.
+// Deny takes precedence over Allow, and if Allow is empty all methods which are not denied are served.
+type MethodPolicy struct {
+	Allow []string `yaml:"allow,omitempty" json:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty" json:"deny,omitempty"`
+}
+
+// ParseMethodPatterns splits a comma-separated list of method patterns
+func ParseMethodPatterns(s string) []string {
+	return parseCommaList(s)
+}
+
+// parseCommaList splits a comma-separated list, skipping empty items
+func parseCommaList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (p MethodPolicy) Validate() error {
+	for _, pattern := range append(append([]string{}, p.Allow...), p.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid method pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// Allows returns whether the method is served. The customer policy (may be nil) can only narrow this policy, unless
+// the customer is authenticated: then it overrides this policy, e.g. allowing the customer to use a denied method.
+func (p *MethodPolicy) Allows(method string, customer *MethodPolicy, authenticated bool) bool {
+	if rejectedMethods[method] {
+		return false
+	}
+	if customer != nil {
+		if matchesMethod(customer.Deny, method) {
+			return false
+		}
+		if matchesMethod(customer.Allow, method) {
+			if authenticated {
+				return true
+			}
+		} else if len(customer.Allow) > 0 && !authenticated {
+			return false
+		}
+	}
+	if p == nil {
+		return true
+	}
+	if matchesMethod(p.Deny, method) {
+		return false
+	}
+	return len(p.Allow) == 0 || matchesMethod(p.Allow, method)
+}
+
+func matchesMethod(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMethodPolicyAllows(t *testing.T) {
+	policy := &MethodPolicy{Deny: DefaultDeniedMethods}
+	customer := &MethodPolicy{Allow: []string{"debug_traceCall"}, Deny: []string{"eth_getLogs"}}
+
+	for _, tc := range []struct {
+		method        string
+		policy        *MethodPolicy
+		customer      *MethodPolicy
+		authenticated bool
+		allowed       bool
+	}{
+		{"eth_call", nil, nil, false, true},
+		{"eth_sendTransaction", nil, nil, false, false},
+		{"eth_sendTransaction", policy, &MethodPolicy{Allow: []string{"*"}}, true, false},
+		{"eth_call", policy, nil, false, true},
+		{"debug_traceCall", policy, nil, false, false},
+		{"txpool_content", policy, nil, false, false},
+		{"debug_traceCall", policy, customer, true, true},
+		{"debug_traceTransaction", policy, customer, true, false},
+		{"eth_getLogs", policy, customer, true, false},
+		{"eth_getLogs", policy, nil, false, true},
+		{"eth_call", &MethodPolicy{Allow: []string{"eth_*", "net_version"}}, nil, false, true},
+		{"web3_clientVersion", &MethodPolicy{Allow: []string{"eth_*", "net_version"}}, nil, false, false},
+		{"eth_getProof", &MethodPolicy{Allow: []string{"eth_*"}, Deny: []string{"eth_getProof"}}, nil, false, false},
+		// Customers which are not authenticated can only narrow the policy
+		{"debug_traceCall", policy, customer, false, false},
+		{"eth_getLogs", policy, customer, false, false},
+		{"eth_call", policy, customer, false, false},
+		{"eth_call", policy, &MethodPolicy{Deny: []string{"eth_getLogs"}}, false, true},
+	} {
+		require.Equal(t, tc.allowed, tc.policy.Allows(tc.method, tc.customer, tc.authenticated), tc.method)
+	}
+}
+
+func TestMethodPolicyValidate(t *testing.T) {
+	require.NoError(t, MethodPolicy{Allow: []string{"eth_*"}, Deny: DefaultDeniedMethods}.Validate())
+	require.Error(t, MethodPolicy{Deny: []string{"debug_[*"}}.Validate())
+	require.Equal(t, []string{"eth_*", "net_version"}, ParseMethodPatterns(" eth_*, net_version,"))
+	require.Nil(t, ParseMethodPatterns(""))
+}
@@ -12,25 +12,27 @@ type statusRecorder struct {
 }
 
 func (rec *statusRecorder) WriteHeader(code int) {
-	rec.status = code
+	if rec.status == 0 {
+		rec.status = code
+	}
 	rec.ResponseWriter.WriteHeader(code)
 }
 
+// Write sets the implicit 200 status if the handler didn't call WriteHeader
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
 func MetricsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rec := &statusRecorder{ResponseWriter: w}
 
 		next.ServeHTTP(rec, r)
 
-		switch rec.status {
-		case http.StatusOK:
-			metrics.StatusOKInc()
-		case http.StatusBadRequest:
-			metrics.StatusBadRequestInc()
-		case http.StatusInternalServerError:
-			metrics.StatusInternalServerErrorInc()
-		case http.StatusNotFound:
-			metrics.StatusNotFoundInc()
-		}
+		// net/http sends 200 if the handler wrote nothing
+		metrics.IncHttpRequest(valueOrDefault(rec.status, http.StatusOK))
 	})
 }
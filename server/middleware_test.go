@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsMiddlewareCountsAllStatuses(t *testing.T) {
+	counter := func(status string) uint64 {
+		return metrics.GetOrCreateCounter(`http_requests_total{status="` + status + `"}`).Get()
+	}
+	for _, tc := range []struct {
+		status  string
+		handler http.HandlerFunc
+	}{
+		{"429", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTooManyRequests) }},
+		{"503", func(w http.ResponseWriter, r *http.Request) { http.Error(w, "draining", http.StatusServiceUnavailable) }},
+		{"200", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }},
+		{"200", func(w http.ResponseWriter, r *http.Request) {}},
+	} {
+		before := counter(tc.status)
+		MetricsMiddleware(tc.handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+		require.Equal(t, before+1, counter(tc.status), tc.status)
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/flashbots/rpc-endpoint/types"
+	"github.com/pkg/errors"
+)
+
+var (
+	// readinessCheckTimeout bounds the total time spent on all dependency checks of a single readiness probe
+	readinessCheckTimeout = 3 * time.Second
+	// readinessMaxBlockAge is the max age of the upstream node's latest block before it is considered out of sync
+	readinessMaxBlockAge = 60 * time.Second
+	// readinessBuilderRegistryStaleFactor is how many fetch intervals may pass without a successful builder registry fetch
+	readinessBuilderRegistryStaleFactor = 3
+)
+
+type readinessCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) (status types.ReadinessStatus, message string)
+}
+
+// readinessChecks checks the dependencies of each chain, named with a ":<chain>" suffix for all but the default chain.
+// Only the Redis connection of the default chain is critical, an outage of a secondary chain must not stop the others.
+// Shared dependencies like the relay or the upstream node fail for all pods alike, so they only degrade readiness
+// instead of taking every pod out of rotation, and Postgres writes are buffered.
+func (s *RpcEndPointServer) readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{name: "postgres", critical: false, check: s.checkPostgres},
+		{name: "configWatcher", critical: false, check: s.checkConfigurationWatcher},
+	}
+	for _, chain := range s.chains.chains() {
+		chain := chain
+		suffix := ""
+		if chain.name != "" {
+			suffix = ":" + chain.name
+		}
+		checks = append(checks,
+			readinessCheck{name: "redis" + suffix, critical: chain == s.chains.defaultChain, check: func(ctx context.Context) (types.ReadinessStatus, string) { return s.checkRedis(ctx, chain) }},
+			readinessCheck{name: "upstream" + suffix, critical: false, check: func(ctx context.Context) (types.ReadinessStatus, string) { return s.checkUpstreamNode(ctx, chain) }},
+			readinessCheck{name: "relay" + suffix, critical: false, check: func(ctx context.Context) (types.ReadinessStatus, string) { return s.checkRelay(ctx, chain) }},
+			readinessCheck{name: "builderRegistry" + suffix, critical: false, check: func(ctx context.Context) (types.ReadinessStatus, string) { return s.checkBuilderRegistry(ctx, chain) }},
+		)
+	}
+	return checks
+}
+
+// handleReadinessRequest checks all dependencies of the server and reports per-component status.
+// It responds with 503 if the server is draining or a critical dependency is failing, so load balancers stop routing to it.
+func (s *RpcEndPointServer) handleReadinessRequest(respw http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), readinessCheckTimeout)
+	defer cancel()
+
+	res := s.checkReadiness(ctx)
+	jsonResp, err := json.Marshal(res)
+	if err != nil {
+		s.logger.Info("[readinessCheck] Json error", "error", err)
+		respw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respw.Header().Set("Content-Type", "application/json")
+	if res.Status == types.ReadinessFail {
+		respw.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		respw.WriteHeader(http.StatusOK)
+	}
+	respw.Write(jsonResp)
+}
+
+func (s *RpcEndPointServer) checkReadiness(ctx context.Context) types.ReadinessResponse {
+	draining := s.drain.IsDraining()
+
+	checks := s.readinessChecks()
+	components := make(map[string]types.ComponentStatus, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c readinessCheck) {
+			defer wg.Done()
+			start := time.Now()
+			status, message := c.check(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			components[c.name] = types.ComponentStatus{
+				Status:    status,
+				Critical:  c.critical,
+				LatencyMs: time.Since(start).Milliseconds(),
+				Message:   message,
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	overall := types.ReadinessOK
+	for _, c := range components {
+		switch {
+		case c.Status == types.ReadinessFail && c.Critical:
+			overall = types.ReadinessFail
+		case c.Status == types.ReadinessFail || c.Status == types.ReadinessDegraded:
+			if overall == types.ReadinessOK {
+				overall = types.ReadinessDegraded
+			}
+		}
+	}
+	if draining {
+		overall = types.ReadinessFail
+	}
+
+	return types.ReadinessResponse{
+		Status:     overall,
+		Now:        Now(),
+		Version:    s.version,
+		Draining:   draining,
+		Components: components,
+	}
+}
+
+func (s *RpcEndPointServer) checkRedis(ctx context.Context, chain *Chain) (types.ReadinessStatus, string) {
+	if chain.state == nil {
+		return types.ReadinessFail, "state store is not initialized"
+	}
+	if err := chain.state.Ping(ctx); err != nil {
+		return types.ReadinessFail, err.Error()
+	}
+	return types.ReadinessOK, ""
+}
+
+// checkUpstreamNode verifies that the upstream node responds and that its latest block is recent
+func (s *RpcEndPointServer) checkUpstreamNode(ctx context.Context, chain *Chain) (types.ReadinessStatus, string) {
+	blockNumber, blockTime, err := s.fetchLatestBlock(ctx, chain.proxyUrl())
+	if err != nil {
+		return types.ReadinessFail, err.Error()
+	}
+	age := Now().Sub(blockTime)
+	if age > readinessMaxBlockAge {
+		return types.ReadinessFail, fmt.Sprintf("latest block %d is %s old", blockNumber, age.Truncate(time.Second))
+	}
+	return types.ReadinessOK, fmt.Sprintf("latest block %d", blockNumber)
+}
+
+func (s *RpcEndPointServer) fetchLatestBlock(ctx context.Context, proxyUrl string) (blockNumber uint64, blockTime time.Time, err error) {
+	cl := NewRPCProxyClient(s.logger, proxyUrl, s.proxyTimeoutSeconds, 0)
+	_req := types.NewJsonRpcRequest(1, "eth_getBlockByNumber", []interface{}{"latest", false})
+	jsonData, err := json.Marshal(_req)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	httpRes, err := cl.ProxyRequest(ctx, jsonData)
+	if err != nil {
+		return 0, time.Time{}, errors.Wrap(err, "proxy request failed")
+	}
+	resBytes, err := io.ReadAll(httpRes.Body)
+	httpRes.Body.Close()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	_res, err := respBytesToJsonRPCResponse(resBytes)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if _res.Error != nil {
+		return 0, time.Time{}, errors.New(_res.Error.Message)
+	}
+
+	var block struct {
+		Number    hexutil.Uint64 `json:"number"`
+		Timestamp hexutil.Uint64 `json:"timestamp"`
+	}
+	if err = json.Unmarshal(_res.Result, &block); err != nil {
+		return 0, time.Time{}, errors.Wrap(err, "invalid block")
+	}
+	return uint64(block.Number), time.Unix(int64(block.Timestamp), 0), nil
+}
+
+// checkRelay only verifies that the relay answers HTTP requests; any status code counts as reachable
+func (s *RpcEndPointServer) checkRelay(ctx context.Context, chain *Chain) (types.ReadinessStatus, string) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, chain.relayUrl, nil)
+	if err != nil {
+		return types.ReadinessFail, err.Error()
+	}
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return types.ReadinessFail, err.Error()
+	}
+	resp.Body.Close()
+	return types.ReadinessOK, ""
+}
+
+func (s *RpcEndPointServer) checkPostgres(ctx context.Context) (types.ReadinessStatus, string) {
+	pinger, ok := s.db.(database.Pinger)
+	if !ok {
+		return types.ReadinessDisabled, "no database configured"
+	}
+	if err := pinger.Ping(ctx); err != nil {
+		return types.ReadinessFail, err.Error()
+	}
+	return types.ReadinessOK, ""
+}
+
+func (s *RpcEndPointServer) checkBuilderRegistry(ctx context.Context, chain *Chain) (types.ReadinessStatus, string) {
+	registry, ok := chain.builderNameProvider.(BuilderRegistryAgeProvider)
+	if !ok {
+		return types.ReadinessDisabled, ""
+	}
+	lastFetched, enabled := registry.LastFetched()
+	if !enabled {
+		return types.ReadinessDisabled, "no builder info source configured"
+	}
+	age := Now().Sub(lastFetched)
+	maxAge := time.Duration(readinessBuilderRegistryStaleFactor*s.fetchInfoIntervalSeconds) * time.Second
+	if maxAge > 0 && age > maxAge {
+		return types.ReadinessDegraded, fmt.Sprintf("builder registry last fetched %s ago", age.Truncate(time.Second))
+	}
+	return types.ReadinessOK, fmt.Sprintf("%d builders, fetched %s ago", len(chain.builderNameProvider.BuilderNames()), age.Truncate(time.Second))
+}
+
+func (s *RpcEndPointServer) checkConfigurationWatcher(ctx context.Context) (types.ReadinessStatus, string) {
+	watcher := s.configurationWatcher.Load()
+	if watcher == nil {
+		return types.ReadinessDisabled, "no customer config loaded"
+	}
+	return types.ReadinessOK, fmt.Sprintf("%d customers, %d presets", len(watcher.ParsedCustomersConfig), len(watcher.ParsedPresets))
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/flashbots/rpc-endpoint/testutils"
+	"github.com/flashbots/rpc-endpoint/types"
+	"github.com/stretchr/testify/require"
+)
+
+type staticBuilderNames []string
+
+func (b staticBuilderNames) BuilderNames() []string { return b }
+
+func newReadinessTestServer(t *testing.T) (*RpcEndPointServer, *miniredis.Miniredis) {
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(redisServer.Close)
+	state, err := NewRedisState(redisServer.Addr(), DefaultStateOptions)
+	require.NoError(t, err)
+
+	backend := httptest.NewServer(http.HandlerFunc(testutils.RpcBackendHandler))
+	t.Cleanup(backend.Close)
+
+	return &RpcEndPointServer{
+		db:                  database.NewMockStore(),
+		drain:               &DrainState{},
+		logger:              log.New(),
+		proxyTimeoutSeconds: 1,
+		version:             "test",
+		chains: newChainRouter(&Chain{
+			proxyUrls:           []string{backend.URL},
+			relayUrl:            backend.URL,
+			builderNameProvider: staticBuilderNames{"flashbots"},
+			state:               state,
+		}),
+	}, redisServer
+}
+
+func TestReadiness(t *testing.T) {
+	s, redisServer := newReadinessTestServer(t)
+
+	res := s.checkReadiness(context.Background())
+	require.Equal(t, types.ReadinessOK, res.Status)
+	require.Equal(t, types.ReadinessOK, res.Components["redis"].Status)
+	require.Equal(t, types.ReadinessOK, res.Components["upstream"].Status)
+	require.Equal(t, types.ReadinessOK, res.Components["relay"].Status)
+	require.Equal(t, types.ReadinessDisabled, res.Components["postgres"].Status)
+	require.Equal(t, types.ReadinessDisabled, res.Components["configWatcher"].Status)
+
+	// Redis down must fail readiness with 503
+	redisServer.Close()
+	rec := httptest.NewRecorder()
+	s.handleReadinessRequest(rec, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	res = types.ReadinessResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Equal(t, types.ReadinessFail, res.Status)
+	require.Equal(t, types.ReadinessFail, res.Components["redis"].Status)
+	require.True(t, res.Components["redis"].Critical)
+}
+
+func TestReadinessSharedDependencyDegraded(t *testing.T) {
+	s, _ := newReadinessTestServer(t)
+	s.chains.defaultChain.relayUrl = "http://127.0.0.1:1"
+
+	// A relay outage affects all pods, so it must not take this one out of rotation
+	rec := httptest.NewRecorder()
+	s.handleReadinessRequest(rec, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	res := types.ReadinessResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Equal(t, types.ReadinessDegraded, res.Status)
+	require.Equal(t, types.ReadinessFail, res.Components["relay"].Status)
+	require.False(t, res.Components["relay"].Critical)
+}
+
+func TestReadinessSecondaryChainDegraded(t *testing.T) {
+	s, _ := newReadinessTestServer(t)
+	secondaryRedis, err := miniredis.Run()
+	require.NoError(t, err)
+	state, err := NewRedisState(secondaryRedis.Addr(), DefaultStateOptions)
+	require.NoError(t, err)
+	defaultChain := s.chains.defaultChain
+	require.NoError(t, s.chains.add(&Chain{
+		name:                "sepolia",
+		proxyUrls:           defaultChain.proxyUrls,
+		relayUrl:            defaultChain.relayUrl,
+		builderNameProvider: defaultChain.builderNameProvider,
+		state:               state,
+	}, nil))
+
+	// Redis of a secondary chain down only degrades readiness
+	secondaryRedis.Close()
+	res := s.checkReadiness(context.Background())
+	require.Equal(t, types.ReadinessDegraded, res.Status)
+	require.Equal(t, types.ReadinessFail, res.Components["redis:sepolia"].Status)
+	require.False(t, res.Components["redis:sepolia"].Critical)
+	require.True(t, res.Components["redis"].Critical)
+}
+
+func TestReadinessDraining(t *testing.T) {
+	s, _ := newReadinessTestServer(t)
+	s.drain.Drain("maintenance", nil)
+
+	res := s.checkReadiness(context.Background())
+	require.True(t, res.Draining)
+	require.Equal(t, types.ReadinessFail, res.Status)
+}
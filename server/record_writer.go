@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/metrics"
+	"github.com/pkg/errors"
+)
+
+var ErrRecordWriterStopped = errors.New("record writer is stopped")
+
+// RecordWriter persists request records in the background with a bounded queue and a fixed number of workers.
+// When the queue is full, Enqueue blocks for up to enqueueTimeout (applying backpressure on the request handler)
+// before the record is dropped. Records are saved one at a time, the batching of database writes is done by the
+// buffered store (see database.NewBufferedStore).
+type RecordWriter struct {
+	logger         log.Logger
+	queue          chan *requestRecord
+	workers        int
+	enqueueTimeout time.Duration
+
+	wg       sync.WaitGroup
+	stopMx   sync.RWMutex
+	stopped  bool
+	stopOnce sync.Once
+}
+
+func NewRecordWriter(logger log.Logger, queueSize, workers int, enqueueTimeout time.Duration) *RecordWriter {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return &RecordWriter{
+		logger:         logger,
+		queue:          make(chan *requestRecord, queueSize),
+		workers:        workers,
+		enqueueTimeout: enqueueTimeout,
+	}
+}
+
+func (w *RecordWriter) Start() {
+	for i := 0; i < w.workers; i++ {
+		w.wg.Add(1)
+		go w.work()
+	}
+}
+
+// Enqueue schedules the record to be saved, and returns false if it was dropped
+func (w *RecordWriter) Enqueue(record *requestRecord) bool {
+	w.stopMx.RLock()
+	defer w.stopMx.RUnlock()
+	if w.stopped {
+		metrics.IncRecordDropped()
+		w.logger.Error("[RecordWriter] record dropped, writer is stopped", "requestId", record.requestEntry.Id)
+		return false
+	}
+
+	select {
+	case w.queue <- record:
+		metrics.SetRecordQueueDepth(len(w.queue))
+		return true
+	default:
+	}
+
+	// Queue is full, wait for a free slot before dropping the record
+	timer := time.NewTimer(w.enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case w.queue <- record:
+		metrics.SetRecordQueueDepth(len(w.queue))
+		return true
+	case <-timer.C:
+		metrics.IncRecordDropped()
+		w.logger.Error("[RecordWriter] record dropped, queue is full", "requestId", record.requestEntry.Id, "queueSize", cap(w.queue))
+		return false
+	}
+}
+
+// Stop stops accepting new records and waits for the queue to be flushed, or until ctx is done
+func (w *RecordWriter) Stop(ctx context.Context) error {
+	w.stopOnce.Do(func() {
+		w.stopMx.Lock()
+		w.stopped = true
+		close(w.queue)
+		w.stopMx.Unlock()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		metrics.AddRecordDropped(len(w.queue))
+		return errors.Wrapf(ctx.Err(), "%d records not flushed", len(w.queue))
+	}
+}
+
+func (w *RecordWriter) work() {
+	defer w.wg.Done()
+	for record := range w.queue {
+		metrics.SetRecordQueueDepth(len(w.queue))
+		// Save both request entry and raw tx entries if present
+		if err := record.SaveRecord(); err != nil {
+			metrics.IncDatabaseErr()
+			w.logger.Error("saveRecord failed", "requestId", record.requestEntry.Id, "error", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingStore blocks all saves until release is closed
+type blockingStore struct {
+	database.Store
+	release chan struct{}
+}
+
+func (b *blockingStore) SaveRequestEntry(entry database.RequestEntry) error {
+	<-b.release
+	return b.Store.SaveRequestEntry(entry)
+}
+
+func newTestRecord(db database.Store) *requestRecord {
+	record := NewRequestRecord(db)
+	record.requestEntry.Id = uuid.New()
+	record.AddEthSendRawTxEntry(uuid.New()).WasSentToRelay = true
+	return record
+}
+
+// waitForRawTxEntries waits until n raw tx entries were saved in the background, and returns them newest first
+func waitForRawTxEntries(t *testing.T, store database.Reader, n int) []*database.EthSendRawTxEntry {
+	t.Helper()
+	var entries []*database.EthSendRawTxEntry
+	require.Eventually(t, func() bool {
+		var err error
+		entries, err = store.GetRawTxEntries(context.Background(), database.RawTxEntryFilter{})
+		require.NoError(t, err)
+		return len(entries) >= n
+	}, 5*time.Second, 5*time.Millisecond)
+	require.Len(t, entries, n)
+	return entries
+}
+
+func TestRecordWriterFlushesOnStop(t *testing.T) {
+	memStore := database.NewMemStore()
+	w := NewRecordWriter(log.New(), 100, 2, time.Second)
+	w.Start()
+
+	for i := 0; i < 50; i++ {
+		require.True(t, w.Enqueue(newTestRecord(memStore)))
+	}
+	require.NoError(t, w.Stop(context.Background()))
+	require.Equal(t, 50, len(memStore.Requests))
+	require.Equal(t, 50, len(memStore.EthSendRawTxs))
+
+	// No records are accepted after stop
+	require.False(t, w.Enqueue(newTestRecord(memStore)))
+}
+
+func TestRecordWriterDropsWhenFull(t *testing.T) {
+	store := &blockingStore{Store: database.NewMemStore(), release: make(chan struct{})}
+	w := NewRecordWriter(log.New(), 1, 1, 10*time.Millisecond)
+	w.Start()
+
+	// First record is picked up by the worker and blocks it, second fills the queue
+	require.True(t, w.Enqueue(newTestRecord(store)))
+	require.Eventually(t, func() bool { return len(w.queue) == 0 }, time.Second, time.Millisecond)
+	require.True(t, w.Enqueue(newTestRecord(store)))
+	require.False(t, w.Enqueue(newTestRecord(store)))
+
+	// Stop with an expired deadline reports unflushed records
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Error(t, w.Stop(ctx))
+	close(store.release)
+}
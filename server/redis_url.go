@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+type RedisMode string
+
+const (
+	RedisModeSingle   RedisMode = "single"
+	RedisModeCluster  RedisMode = "cluster"
+	RedisModeSentinel RedisMode = "sentinel"
+)
+
+// RedisConfig is the parsed connection config of a Redis URL
+type RedisConfig struct {
+	Mode    RedisMode
+	Options redis.UniversalOptions
+}
+
+// ParseRedisURL parses either a plain host:port address, or a URL of the form
+//
+//	redis[s]://[user:password@]host:port[,host:port...][/db][?mode=cluster|sentinel&master=name&sentinel_username=..&sentinel_password=..]
+//
+// rediss:// enables TLS. Hosts are the seed nodes in cluster mode and the sentinels in sentinel mode,
+// where master is required. All other query params are passed on to redis.ParseURL (e.g. dial_timeout, pool_size).
+func ParseRedisURL(redisUrl string) (*RedisConfig, error) {
+	if !strings.Contains(redisUrl, "://") {
+		return &RedisConfig{Mode: RedisModeSingle, Options: redis.UniversalOptions{Addrs: []string{redisUrl}}}, nil
+	}
+
+	// url.Parse rejects comma separated hosts, so the hosts are split off and the first one is used for parsing
+	scheme, rest, _ := strings.Cut(redisUrl, "://")
+	authorityEnd := strings.IndexAny(rest, "/?")
+	if authorityEnd < 0 {
+		authorityEnd = len(rest)
+	}
+	authority, pathAndQuery := rest[:authorityEnd], rest[authorityEnd:]
+	userInfo := ""
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		userInfo, authority = authority[:i+1], authority[i+1:]
+	}
+	hosts := strings.Split(authority, ",")
+
+	u, err := url.Parse(scheme + "://" + userInfo + hosts[0] + pathAndQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid redis url")
+	}
+	query := u.Query()
+	cfg := &RedisConfig{Mode: RedisMode(query.Get("mode"))}
+	masterName := query.Get("master")
+	sentinelUsername := query.Get("sentinel_username")
+	sentinelPassword := query.Get("sentinel_password")
+	for _, param := range []string{"mode", "master", "sentinel_username", "sentinel_password"} {
+		query.Del(param)
+	}
+	u.RawQuery = query.Encode()
+
+	opts, err := redis.ParseURL(u.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid redis url")
+	}
+
+	addrs := make([]string, 0, len(hosts))
+	for i, host := range hosts {
+		if i == 0 {
+			addrs = append(addrs, opts.Addr) // with the default port applied
+			continue
+		}
+		if host == "" {
+			return nil, errors.New("invalid redis url: empty host")
+		}
+		if !strings.Contains(host, ":") {
+			host += ":6379"
+		}
+		addrs = append(addrs, host)
+	}
+	if opts.TLSConfig != nil && len(addrs) > 1 {
+		// Each node is verified against its own hostname
+		opts.TLSConfig.ServerName = ""
+	}
+
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = RedisModeSingle
+		if len(addrs) > 1 {
+			return nil, errors.New("invalid redis url: multiple hosts require mode=cluster or mode=sentinel")
+		}
+	case RedisModeSingle:
+	case RedisModeCluster:
+		// Cluster nodes only have db 0, use -redisNamespace to share a cluster
+		if opts.DB != 0 {
+			return nil, errors.New("invalid redis url: mode=cluster does not support a db")
+		}
+	case RedisModeSentinel:
+		if masterName == "" {
+			return nil, errors.New("invalid redis url: mode=sentinel requires master")
+		}
+	default:
+		return nil, fmt.Errorf("invalid redis url: unknown mode %s", cfg.Mode)
+	}
+
+	cfg.Options = redis.UniversalOptions{
+		Addrs:              addrs,
+		DB:                 opts.DB,
+		Username:           opts.Username,
+		Password:           opts.Password,
+		SentinelUsername:   sentinelUsername,
+		SentinelPassword:   sentinelPassword,
+		MasterName:         masterName,
+		MaxRetries:         opts.MaxRetries,
+		MinRetryBackoff:    opts.MinRetryBackoff,
+		MaxRetryBackoff:    opts.MaxRetryBackoff,
+		DialTimeout:        opts.DialTimeout,
+		ReadTimeout:        opts.ReadTimeout,
+		WriteTimeout:       opts.WriteTimeout,
+		PoolFIFO:           opts.PoolFIFO,
+		PoolSize:           opts.PoolSize,
+		MinIdleConns:       opts.MinIdleConns,
+		MaxConnAge:         opts.MaxConnAge,
+		PoolTimeout:        opts.PoolTimeout,
+		IdleTimeout:        opts.IdleTimeout,
+		IdleCheckFrequency: opts.IdleCheckFrequency,
+		TLSConfig:          opts.TLSConfig,
+	}
+	return cfg, nil
+}
+
+// NewClient creates the client for the connection mode
+func (c *RedisConfig) NewClient() redis.UniversalClient {
+	switch c.Mode {
+	case RedisModeCluster:
+		return redis.NewClusterClient(c.Options.Cluster())
+	case RedisModeSentinel:
+		return redis.NewFailoverClient(c.Options.Failover())
+	default:
+		return redis.NewClient(c.Options.Simple())
+	}
+}
+
+// redisHashTag wraps the id in a hash tag, so all keys of the id are stored in the same cluster slot
+func redisHashTag(id string) string {
+	return "{" + strings.ToLower(id) + "}"
+}
@@ -13,85 +13,167 @@ import (
 
 var RedisPrefix = "rpc-endpoint:"
 
-// Enable lookup of timeSentToRelay by txHash
-var RedisPrefixTxSentToRelay = RedisPrefix + "tx-sent-to-relay:"
-var RedisExpiryTxSentToRelay = 10 * time.Minute
-
-// Enable lookup of txHash by txFrom+nonce (only if sent to relay)
-var RedisPrefixTxHashForSenderAndNonce = RedisPrefix + "txsender-and-nonce-to-txhash:"
-var RedisExpiryTxHashForSenderAndNonce = 10 * time.Minute
+// Key prefixes, after RedisPrefix and the namespace
+const (
+	// Enable lookup of timeSentToRelay by txHash
+	RedisPrefixTxSentToRelay = "tx-sent-to-relay:"
+	// Txs sent to the relay which were cancelled by a cancel-tx
+	RedisPrefixTxCancelled = "tx-cancelled:"
+	// Enable lookup of txHash by txFrom+nonce (only if sent to relay)
+	RedisPrefixTxHashForSenderAndNonce = "txsender-and-nonce-to-txhash:"
+	// nonce-fix of an account (with number of times sent)
+	RedisPrefixNonceFixForAccount = "txsender-with-nonce-fix:"
+	// Enable lookup of txFrom by txHash
+	RedisPrefixSenderOfTxHash = "txsender-of-txhash:"
+	// Enable lookup of txNonce by txHash
+	RedisPrefixNonceOfTxHash = "txnonce-of-txhash:"
+	// Remember nonce of pending user tx
+	RedisPrefixSenderMaxNonce = "txsender-pending-max-nonce:"
+	// Enable lookup of bundle txs by bundleId
+	RedisPrefixWhitehatBundleTransactions = "tx-for-whitehat-bundle:"
+	// Block transactions by txHash
+	RedisPrefixBlockedTxHash = "blocked-tx-hash:"
+	// Sorted set of webhook deliveries, scored by the unix ms they are due
+	RedisKeyWebhookDeliveries = "webhook-deliveries"
+	// Sorted set of scheduled mempool broadcasts, scored by the unix ms they are due
+	RedisKeyMempoolBroadcasts = "mempool-broadcasts"
+)
 
-// nonce-fix of an account (with number of times sent)
-var RedisPrefixNonceFixForAccount = RedisPrefix + "txsender-with-nonce-fix:"
-var RedisExpiryNonceFixForAccount = 10 * time.Minute
+// RedisKeys builds the keys of a namespace, e.g. rpc-endpoint:mainnet:tx-sent-to-relay:0x...
+type RedisKeys struct {
+	prefix string
+}
 
-// Enable lookup of txFrom by txHash
-var RedisPrefixSenderOfTxHash = RedisPrefix + "txsender-of-txhash:"
-var RedisExpirySenderOfTxHash = 10 * time.Minute
+func NewRedisKeys(namespace string) RedisKeys {
+	if namespace == "" {
+		return RedisKeys{prefix: RedisPrefix}
+	}
+	return RedisKeys{prefix: RedisPrefix + namespace + ":"}
+}
 
-// Enable lookup of txNonce by txHash
-var RedisPrefixNonceOfTxHash = RedisPrefix + "txnonce-of-txhash:"
-var RedisExpiryNonceOfTxHash = 10 * time.Minute
+func (k RedisKeys) TxSentToRelay(txHash string) string {
+	return k.prefix + RedisPrefixTxSentToRelay + strings.ToLower(txHash)
+}
 
-// Remember nonce of pending user tx
-var RedisPrefixSenderMaxNonce = RedisPrefix + "txsender-pending-max-nonce:"
-var RedisExpirySenderMaxNonce = 280 * time.Second //weird time to be a little less than 5 minute default blockrange
+func (k RedisKeys) TxCancelled(txHash string) string {
+	return k.prefix + RedisPrefixTxCancelled + strings.ToLower(txHash)
+}
 
-// Enable lookup of bundle txs by bundleId
-var RedisPrefixWhitehatBundleTransactions = RedisPrefix + "tx-for-whitehat-bundle:"
-var RedisExpiryWhitehatBundleTransactions = 10 * time.Minute
+func (k RedisKeys) TxHashForSenderAndNonce(txFrom string, nonce uint64) string {
+	return fmt.Sprintf("%s%s%s_%d", k.prefix, RedisPrefixTxHashForSenderAndNonce, strings.ToLower(txFrom), nonce)
+}
 
-// Enable lookup of bundle txs by bundleId
-var RedisPrefixBlockedTxHash = RedisPrefix + "blocked-tx-hash:"
-var RedisExpiryBlockedTxHash = 10 * time.Minute
+func (k RedisKeys) NonceFixForAccount(txFrom string) string {
+	return k.prefix + RedisPrefixNonceFixForAccount + strings.ToLower(txFrom)
+}
 
-// // Enable lookup of last privateTransaction-txHash sent by txFrom
-// var RedisPrefixLastPrivTxHashOfAccount = RedisPrefix + "last-txhash-of-txsender:"
-// var RedisExpiryLastPrivTxHashOfAccount = time.Duration(24 * time.Hour) // 1 day
+// Sender and nonce of a tx are set together, so their keys share a hash tag
+func (k RedisKeys) SenderOfTxHash(txHash string) string {
+	return k.prefix + RedisPrefixSenderOfTxHash + redisHashTag(txHash)
+}
 
-func RedisKeyTxSentToRelay(txHash string) string {
-	return RedisPrefixTxSentToRelay + strings.ToLower(txHash)
+func (k RedisKeys) NonceOfTxHash(txHash string) string {
+	return k.prefix + RedisPrefixNonceOfTxHash + redisHashTag(txHash)
 }
 
-func RedisKeyTxHashForSenderAndNonce(txFrom string, nonce uint64) string {
-	return fmt.Sprintf("%s%s_%d", RedisPrefixTxHashForSenderAndNonce, strings.ToLower(txFrom), nonce)
+// Keys of sender and nonce of a tx without hash tag, as written by older versions. They are read as a fallback,
+// and can be removed once they expired, SenderAndNonceOfTxHashExpiry after the upgrade.
+func (k RedisKeys) legacySenderOfTxHash(txHash string) string {
+	return k.prefix + RedisPrefixSenderOfTxHash + strings.ToLower(txHash)
 }
 
-func RedisKeyNonceFixForAccount(txFrom string) string {
-	return RedisPrefixNonceFixForAccount + strings.ToLower(txFrom)
+func (k RedisKeys) legacyNonceOfTxHash(txHash string) string {
+	return k.prefix + RedisPrefixNonceOfTxHash + strings.ToLower(txHash)
 }
 
-func RedisKeySenderOfTxHash(txHash string) string {
-	return RedisPrefixSenderOfTxHash + strings.ToLower(txHash)
+func (k RedisKeys) SenderMaxNonce(txFrom string) string {
+	return k.prefix + RedisPrefixSenderMaxNonce + strings.ToLower(txFrom)
 }
 
-func RedisKeyNonceOfTxHash(txHash string) string {
-	return RedisPrefixNonceOfTxHash + strings.ToLower(txHash)
+func (k RedisKeys) WhitehatBundleTransactions(bundleId string) string {
+	return k.prefix + RedisPrefixWhitehatBundleTransactions + strings.ToLower(bundleId)
 }
 
-func RedisKeySenderMaxNonce(txFrom string) string {
-	return RedisPrefixSenderMaxNonce + strings.ToLower(txFrom)
+func (k RedisKeys) BlockedTxHash(txHash string) string {
+	return k.prefix + RedisPrefixBlockedTxHash + strings.ToLower(txHash)
 }
 
-func RedisKeyWhitehatBundleTransactions(bundleId string) string {
-	return RedisPrefixWhitehatBundleTransactions + strings.ToLower(bundleId)
+func (k RedisKeys) WebhookDeliveries() string {
+	return k.prefix + RedisKeyWebhookDeliveries
 }
 
-func RedisKeyBlockedTxHash(txHash string) string {
-	return RedisPrefixBlockedTxHash + strings.ToLower(txHash)
+func (k RedisKeys) MempoolBroadcasts() string {
+	return k.prefix + RedisKeyMempoolBroadcasts
 }
 
+// // Enable lookup of last privateTransaction-txHash sent by txFrom
+// var RedisPrefixLastPrivTxHashOfAccount = RedisPrefix + "last-txhash-of-txsender:"
+// var RedisExpiryLastPrivTxHashOfAccount = time.Duration(24 * time.Hour) // 1 day
+
 // func RedisKeyLastPrivTxHashOfAccount(txFrom string) string {
 // 	return RedisPrefixLastPrivTxHashOfAccount + strings.ToLower(txFrom)
 // }
 
+// Scripts for read-modify-write updates, which Redis runs atomically
+var (
+	// setMaxNonceScript sets the nonce only if it is higher than the stored one: KEYS[1] = key, ARGV[1] = nonce, ARGV[2] = expiry in ms
+	setMaxNonceScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current and tonumber(current) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1`)
+
+	// incrIfExistsScript increments an existing counter, keeping its expiry, and returns -1 if it does not exist
+	incrIfExistsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+return redis.call('INCR', KEYS[1])`)
+
+	// addToBundleScript adds a tx to the front of a bundle list unless it is already in it, and keeps the latest 16 txs:
+	// KEYS[1] = key, ARGV[1] = tx, ARGV[2] = expiry in ms
+	addToBundleScript = redis.NewScript(`
+for _, tx in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	if tx == ARGV[1] then
+		return 0
+	end
+end
+redis.call('LPUSH', KEYS[1], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+redis.call('LTRIM', KEYS[1], 0, 15)
+return 1`)
+
+	// claimDueScript makes up to ARGV[3] members with a score up to ARGV[1] due again at ARGV[2], and returns them:
+	// KEYS[1] = key
+	claimDueScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+for _, member in ipairs(due) do
+	redis.call('ZADD', KEYS[1], ARGV[2], member)
+end
+return due`)
+)
+
 type RedisState struct {
-	RedisClient *redis.Client
+	RedisClient redis.UniversalClient
+	keys        RedisKeys
+	opts        StateOptions
 }
 
-func NewRedisState(redisUrl string) (*RedisState, error) {
+// NewRedisState connects to a single node, cluster or sentinel-managed Redis, see ParseRedisURL for the URL format
+func NewRedisState(redisUrl string, opts StateOptions) (*RedisState, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	cfg, err := ParseRedisURL(redisUrl)
+	if err != nil {
+		return nil, err
+	}
+
 	// Setup redis client and check connection
-	redisClient := redis.NewClient(&redis.Options{Addr: redisUrl})
+	redisClient := cfg.NewClient()
+	redisClient.AddHook(redisTracingHook{})
 
 	// Try to get a key to see if there's an error with the connection
 	if err := redisClient.Get(context.Background(), "somekey").Err(); err != nil && err != redis.Nil {
@@ -101,19 +183,25 @@ func NewRedisState(redisUrl string) (*RedisState, error) {
 	// Create and return the RedisState
 	return &RedisState{
 		RedisClient: redisClient,
+		keys:        NewRedisKeys(opts.Namespace),
+		opts:        opts,
 	}, nil
 }
 
+func (s *RedisState) Ping(ctx context.Context) error {
+	return s.RedisClient.Ping(ctx).Err()
+}
+
 // Enable lookup of timeSentToRelay by txHash
-func (s *RedisState) SetTxSentToRelay(txHash string) error {
-	key := RedisKeyTxSentToRelay(txHash)
-	err := s.RedisClient.Set(context.Background(), key, Now().UTC().Unix(), RedisExpiryTxSentToRelay).Err()
+func (s *RedisState) SetTxSentToRelay(ctx context.Context, txHash string) error {
+	key := s.keys.TxSentToRelay(txHash)
+	err := s.RedisClient.Set(ctx, key, Now().UTC().Unix(), s.opts.TxSentToRelayExpiry).Err()
 	return err
 }
 
-func (s *RedisState) GetTxSentToRelay(txHash string) (timeSent time.Time, found bool, err error) {
-	key := RedisKeyTxSentToRelay(txHash)
-	val, err := s.RedisClient.Get(context.Background(), key).Result()
+func (s *RedisState) GetTxSentToRelay(ctx context.Context, txHash string) (timeSent time.Time, found bool, err error) {
+	key := s.keys.TxSentToRelay(txHash)
+	val, err := s.RedisClient.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return time.Time{}, false, nil // just not found
 	} else if err != nil {
@@ -129,16 +217,25 @@ func (s *RedisState) GetTxSentToRelay(txHash string) (timeSent time.Time, found
 	return t, true, nil
 }
 
+func (s *RedisState) SetTxCancelled(ctx context.Context, txHash string) error {
+	return s.RedisClient.Set(ctx, s.keys.TxCancelled(txHash), 1, s.opts.TxSentToRelayExpiry).Err()
+}
+
+func (s *RedisState) GetTxCancelled(ctx context.Context, txHash string) (found bool, err error) {
+	n, err := s.RedisClient.Exists(ctx, s.keys.TxCancelled(txHash)).Result()
+	return n > 0, err
+}
+
 // Enable lookup of txHash by txFrom+nonce
-func (s *RedisState) SetTxHashForSenderAndNonce(txFrom string, nonce uint64, txHash string) error {
-	key := RedisKeyTxHashForSenderAndNonce(txFrom, nonce)
-	err := s.RedisClient.Set(context.Background(), key, strings.ToLower(txHash), RedisExpiryTxHashForSenderAndNonce).Err()
+func (s *RedisState) SetTxHashForSenderAndNonce(ctx context.Context, txFrom string, nonce uint64, txHash string) error {
+	key := s.keys.TxHashForSenderAndNonce(txFrom, nonce)
+	err := s.RedisClient.Set(ctx, key, strings.ToLower(txHash), s.opts.TxHashForSenderAndNonceExpiry).Err()
 	return err
 }
 
-func (s *RedisState) GetTxHashForSenderAndNonce(txFrom string, nonce uint64) (txHash string, found bool, err error) {
-	key := RedisKeyTxHashForSenderAndNonce(txFrom, nonce)
-	txHash, err = s.RedisClient.Get(context.Background(), key).Result()
+func (s *RedisState) GetTxHashForSenderAndNonce(ctx context.Context, txFrom string, nonce uint64) (txHash string, found bool, err error) {
+	key := s.keys.TxHashForSenderAndNonce(txFrom, nonce)
+	txHash, err = s.RedisClient.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return "", false, nil // not found
 	} else if err != nil {
@@ -149,21 +246,33 @@ func (s *RedisState) GetTxHashForSenderAndNonce(txFrom string, nonce uint64) (tx
 }
 
 // nonce-fix per account
-func (s *RedisState) SetNonceFixForAccount(txFrom string, numTimesSent uint64) error {
-	key := RedisKeyNonceFixForAccount(txFrom)
-	err := s.RedisClient.Set(context.Background(), key, numTimesSent, RedisExpiryNonceFixForAccount).Err()
+func (s *RedisState) SetNonceFixForAccount(ctx context.Context, txFrom string, numTimesSent uint64) error {
+	key := s.keys.NonceFixForAccount(txFrom)
+	err := s.RedisClient.Set(ctx, key, numTimesSent, s.opts.NonceFixForAccountExpiry).Err()
 	return err
 }
 
-func (s *RedisState) DelNonceFixForAccount(txFrom string) error {
-	key := RedisKeyNonceFixForAccount(txFrom)
-	err := s.RedisClient.Del(context.Background(), key).Err()
+// IncNonceFixForAccount atomically increments the times sent of an existing nonce-fix, and returns the new value
+func (s *RedisState) IncNonceFixForAccount(ctx context.Context, txFrom string) (numTimesSent uint64, found bool, err error) {
+	key := s.keys.NonceFixForAccount(txFrom)
+	val, err := incrIfExistsScript.Run(ctx, s.RedisClient, []string{key}).Int64()
+	if err != nil {
+		return 0, false, err
+	} else if val < 0 {
+		return 0, false, nil
+	}
+	return uint64(val), true, nil
+}
+
+func (s *RedisState) DelNonceFixForAccount(ctx context.Context, txFrom string) error {
+	key := s.keys.NonceFixForAccount(txFrom)
+	err := s.RedisClient.Del(ctx, key).Err()
 	return err
 }
 
-func (s *RedisState) GetNonceFixForAccount(txFrom string) (numTimesSent uint64, found bool, err error) {
-	key := RedisKeyNonceFixForAccount(txFrom)
-	val, err := s.RedisClient.Get(context.Background(), key).Result()
+func (s *RedisState) GetNonceFixForAccount(ctx context.Context, txFrom string) (numTimesSent uint64, found bool, err error) {
+	key := s.keys.NonceFixForAccount(txFrom)
+	val, err := s.RedisClient.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return 0, false, nil // not found
 	} else if err != nil {
@@ -177,21 +286,27 @@ func (s *RedisState) GetNonceFixForAccount(txFrom string) (numTimesSent uint64,
 	return numTimesSent, true, nil
 }
 
-// Enable lookup of txFrom and nonce by txHash
-func (s *RedisState) SetSenderAndNonceOfTxHash(txHash string, txFrom string, txNonce uint64) error {
-	key := RedisKeySenderOfTxHash(txHash)
-	err := s.RedisClient.Set(context.Background(), key, strings.ToLower(txFrom), RedisExpirySenderOfTxHash).Err()
-	if err != nil {
-		return err
-	}
-	key = RedisKeyNonceOfTxHash(txHash)
-	err = s.RedisClient.Set(context.Background(), key, txNonce, RedisExpiryNonceOfTxHash).Err()
+// Enable lookup of txFrom and nonce by txHash. Both are set in a single MULTI/EXEC transaction.
+func (s *RedisState) SetSenderAndNonceOfTxHash(ctx context.Context, txHash string, txFrom string, txNonce uint64) error {
+	_, err := s.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.keys.SenderOfTxHash(txHash), strings.ToLower(txFrom), s.opts.SenderAndNonceOfTxHashExpiry)
+		pipe.Set(ctx, s.keys.NonceOfTxHash(txHash), txNonce, s.opts.SenderAndNonceOfTxHashExpiry)
+		return nil
+	})
 	return err
 }
 
-func (s *RedisState) GetSenderOfTxHash(txHash string) (txSender string, found bool, err error) {
-	key := RedisKeySenderOfTxHash(txHash)
-	txSender, err = s.RedisClient.Get(context.Background(), key).Result()
+// getWithFallback gets the key, or the legacy key if the key doesn't exist
+func (s *RedisState) getWithFallback(ctx context.Context, key, legacyKey string) (string, error) {
+	val, err := s.RedisClient.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return s.RedisClient.Get(ctx, legacyKey).Result()
+	}
+	return val, err
+}
+
+func (s *RedisState) GetSenderOfTxHash(ctx context.Context, txHash string) (txSender string, found bool, err error) {
+	txSender, err = s.getWithFallback(ctx, s.keys.SenderOfTxHash(txHash), s.keys.legacySenderOfTxHash(txHash))
 	if err == redis.Nil { // not found
 		return "", false, nil
 	} else if err != nil {
@@ -201,9 +316,8 @@ func (s *RedisState) GetSenderOfTxHash(txHash string) (txSender string, found bo
 	return strings.ToLower(txSender), true, nil
 }
 
-func (s *RedisState) GetNonceOfTxHash(txHash string) (txNonce uint64, found bool, err error) {
-	key := RedisKeyNonceOfTxHash(txHash)
-	val, err := s.RedisClient.Get(context.Background(), key).Result()
+func (s *RedisState) GetNonceOfTxHash(ctx context.Context, txHash string) (txNonce uint64, found bool, err error) {
+	val, err := s.getWithFallback(ctx, s.keys.NonceOfTxHash(txHash), s.keys.legacyNonceOfTxHash(txHash))
 	if err == redis.Nil {
 		return 0, false, nil
 	}
@@ -216,44 +330,20 @@ func (s *RedisState) GetNonceOfTxHash(txHash string) (txNonce uint64, found bool
 }
 
 // Enable lookup of tx bundles by bundle ID
-func (s *RedisState) AddTxToWhitehatBundle(bundleId string, signedTx string) error {
-	key := RedisKeyWhitehatBundleTransactions(bundleId)
-
-	// Check if item already exists
-	txs, err := s.GetWhitehatBundleTx(bundleId)
-	if err == nil {
-		for _, tx := range txs {
-			if signedTx == tx {
-				return nil
-			}
-		}
-	}
-
-	// Add item
-	err = s.RedisClient.LPush(context.Background(), key, signedTx).Err()
-	if err != nil {
-		return err
-	}
-
-	// Set expiry
-	err = s.RedisClient.Expire(context.Background(), key, RedisExpiryWhitehatBundleTransactions).Err()
-	if err != nil {
-		return err
-	}
-
-	// Limit to 15 entries
-	err = s.RedisClient.LTrim(context.Background(), key, 0, 15).Err()
-	return err
+func (s *RedisState) AddTxToWhitehatBundle(ctx context.Context, bundleId string, signedTx string) error {
+	key := s.keys.WhitehatBundleTransactions(bundleId)
+	expiryMs := s.opts.WhitehatBundleExpiry.Milliseconds()
+	return addToBundleScript.Run(ctx, s.RedisClient, []string{key}, signedTx, expiryMs).Err()
 }
 
-func (s *RedisState) GetWhitehatBundleTx(bundleId string) ([]string, error) {
-	key := RedisKeyWhitehatBundleTransactions(bundleId)
-	return s.RedisClient.LRange(context.Background(), key, 0, -1).Result()
+func (s *RedisState) GetWhitehatBundleTx(ctx context.Context, bundleId string) ([]string, error) {
+	key := s.keys.WhitehatBundleTransactions(bundleId)
+	return s.RedisClient.LRange(ctx, key, 0, -1).Result()
 }
 
-func (s *RedisState) DelWhitehatBundleTx(bundleId string) error {
-	key := RedisKeyWhitehatBundleTransactions(bundleId)
-	return s.RedisClient.Del(context.Background(), key).Err()
+func (s *RedisState) DelWhitehatBundleTx(ctx context.Context, bundleId string) error {
+	key := s.keys.WhitehatBundleTransactions(bundleId)
+	return s.RedisClient.Del(ctx, key).Err()
 }
 
 //
@@ -261,13 +351,13 @@ func (s *RedisState) DelWhitehatBundleTx(bundleId string) error {
 //
 // func (s *RedisState) SetLastPrivTxHashOfAccount(txFrom string, txHash string) error {
 // 	key := RedisKeyLastPrivTxHashOfAccount(txFrom)
-// 	err := s.RedisClient.Set(context.Background(), key, strings.ToLower(txHash), RedisExpiryLastPrivTxHashOfAccount).Err()
+// 	err := s.RedisClient.Set(ctx, key, strings.ToLower(txHash), RedisExpiryLastPrivTxHashOfAccount).Err()
 // 	return err
 // }
 
 // func (s *RedisState) GetLastPrivTxHashOfAccount(txFrom string) (txHash string, found bool, err error) {
 // 	key := RedisKeyLastPrivTxHashOfAccount(txFrom)
-// 	txHash, err = s.RedisClient.Get(context.Background(), key).Result()
+// 	txHash, err = s.RedisClient.Get(ctx, key).Result()
 // 	if err == redis.Nil { // not found
 // 		return "", false, nil
 // 	} else if err != nil {
@@ -277,30 +367,16 @@ func (s *RedisState) DelWhitehatBundleTx(bundleId string) error {
 // 	return strings.ToLower(txHash), true, nil
 // }
 
-func (s *RedisState) SetSenderMaxNonce(txFrom string, nonce uint64, blockRange int) error {
-	prevMaxNonce, found, err := s.GetSenderMaxNonce(txFrom)
-	if err != nil {
-		return err
-	}
-
-	// Do nothing if current nonce is not higher than already existing
-	if found && prevMaxNonce >= nonce {
-		return nil
-	}
-
-	expiry := RedisExpirySenderMaxNonce
-	if blockRange > 0 {
-		expiry = 12 * time.Duration(blockRange) * time.Second
-	}
-
-	key := RedisKeySenderMaxNonce(txFrom)
-	err = s.RedisClient.Set(context.Background(), key, nonce, expiry).Err()
-	return err
+// SetSenderMaxNonce stores the nonce if it is higher than the stored one, as an atomic compare-and-set
+func (s *RedisState) SetSenderMaxNonce(ctx context.Context, txFrom string, nonce uint64, blockRange int) error {
+	key := s.keys.SenderMaxNonce(txFrom)
+	expiryMs := s.opts.SenderMaxNonceExpiry(blockRange).Milliseconds()
+	return setMaxNonceScript.Run(ctx, s.RedisClient, []string{key}, nonce, expiryMs).Err()
 }
 
-func (s *RedisState) GetSenderMaxNonce(txFrom string) (senderMaxNonce uint64, found bool, err error) {
-	key := RedisKeySenderMaxNonce(txFrom)
-	val, err := s.RedisClient.Get(context.Background(), key).Result()
+func (s *RedisState) GetSenderMaxNonce(ctx context.Context, txFrom string) (senderMaxNonce uint64, found bool, err error) {
+	key := s.keys.SenderMaxNonce(txFrom)
+	val, err := s.RedisClient.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return 0, false, nil // not found
 	} else if err != nil {
@@ -314,21 +390,21 @@ func (s *RedisState) GetSenderMaxNonce(txFrom string) (senderMaxNonce uint64, fo
 	return senderMaxNonce, true, nil
 }
 
-func (s *RedisState) DelSenderMaxNonce(txFrom string) error {
-	key := RedisKeySenderMaxNonce(txFrom)
-	return s.RedisClient.Del(context.Background(), key).Err()
+func (s *RedisState) DelSenderMaxNonce(ctx context.Context, txFrom string) error {
+	key := s.keys.SenderMaxNonce(txFrom)
+	return s.RedisClient.Del(ctx, key).Err()
 }
 
 // Block transactions, with a specific return value (eg. "nonce too low")
-func (s *RedisState) SetBlockedTxHash(txHash string, returnValue string) error {
-	key := RedisKeyBlockedTxHash(txHash)
-	err := s.RedisClient.Set(context.Background(), key, returnValue, RedisExpiryBlockedTxHash).Err()
+func (s *RedisState) SetBlockedTxHash(ctx context.Context, txHash string, returnValue string) error {
+	key := s.keys.BlockedTxHash(txHash)
+	err := s.RedisClient.Set(ctx, key, returnValue, s.opts.BlockedTxHashExpiry).Err()
 	return err
 }
 
-func (s *RedisState) GetBlockedTxHash(txHash string) (returnValue string, found bool, err error) {
-	key := RedisKeyBlockedTxHash(txHash)
-	returnValue, err = s.RedisClient.Get(context.Background(), key).Result()
+func (s *RedisState) GetBlockedTxHash(ctx context.Context, txHash string) (returnValue string, found bool, err error) {
+	key := s.keys.BlockedTxHash(txHash)
+	returnValue, err = s.RedisClient.Get(ctx, key).Result()
 	if err == redis.Nil { // not found
 		return "", false, nil
 	} else if err != nil {
@@ -337,3 +413,35 @@ func (s *RedisState) GetBlockedTxHash(txHash string) (returnValue string, found
 
 	return returnValue, true, nil
 }
+
+func (s *RedisState) AddWebhookDelivery(ctx context.Context, delivery string, due time.Time) error {
+	key := s.keys.WebhookDeliveries()
+	return s.RedisClient.ZAdd(ctx, key, &redis.Z{Score: float64(due.UnixMilli()), Member: delivery}).Err()
+}
+
+// ClaimWebhookDeliveries reschedules the due deliveries atomically, so each is claimed by one instance
+func (s *RedisState) ClaimWebhookDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]string, error) {
+	key := s.keys.WebhookDeliveries()
+	return claimDueScript.Run(ctx, s.RedisClient, []string{key}, now.UnixMilli(), leaseUntil.UnixMilli(), limit).StringSlice()
+}
+
+func (s *RedisState) DelWebhookDelivery(ctx context.Context, delivery string) error {
+	key := s.keys.WebhookDeliveries()
+	return s.RedisClient.ZRem(ctx, key, delivery).Err()
+}
+
+func (s *RedisState) AddMempoolBroadcast(ctx context.Context, broadcast string, due time.Time) error {
+	key := s.keys.MempoolBroadcasts()
+	return s.RedisClient.ZAdd(ctx, key, &redis.Z{Score: float64(due.UnixMilli()), Member: broadcast}).Err()
+}
+
+// ClaimMempoolBroadcasts reschedules the due broadcasts atomically, so each is claimed by one instance
+func (s *RedisState) ClaimMempoolBroadcasts(ctx context.Context, now, leaseUntil time.Time, limit int) ([]string, error) {
+	key := s.keys.MempoolBroadcasts()
+	return claimDueScript.Run(ctx, s.RedisClient, []string{key}, now.UnixMilli(), leaseUntil.UnixMilli(), limit).StringSlice()
+}
+
+func (s *RedisState) DelMempoolBroadcast(ctx context.Context, broadcast string) error {
+	key := s.keys.MempoolBroadcasts()
+	return s.RedisClient.ZRem(ctx, key, broadcast).Err()
+}
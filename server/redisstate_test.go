@@ -1,12 +1,14 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/require"
 )
 
@@ -24,7 +26,7 @@ func resetRedis() {
 		panic(err)
 	}
 
-	redisState, err = NewRedisState(redisServer.Addr())
+	redisState, err = NewRedisState(redisServer.Addr(), DefaultStateOptions)
 	// redisState, err = server.NewRedisState("localhost:6379")
 	if err != nil {
 		panic(err)
@@ -33,19 +35,20 @@ func resetRedis() {
 
 func TestRedisStateSetup(t *testing.T) {
 	var err error
-	redisState, err = NewRedisState("localhost:18279")
+	redisState, err = NewRedisState("localhost:18279", DefaultStateOptions)
 	require.NotNil(t, err, err)
 }
 
 func TestTxSentToRelay(t *testing.T) {
+	ctx := context.Background()
 	var err error
 	resetRedis()
 
 	timeBeforeSet := time.Now()
-	err = redisState.SetTxSentToRelay("foo")
+	err = redisState.SetTxSentToRelay(ctx, "foo")
 	require.Nil(t, err, err)
 
-	timeSent, found, err := redisState.GetTxSentToRelay("foo")
+	timeSent, found, err := redisState.GetTxSentToRelay(ctx, "foo")
 	require.Nil(t, err, err)
 	require.True(t, found)
 
@@ -54,18 +57,33 @@ func TestTxSentToRelay(t *testing.T) {
 	require.True(t, time.Since(timeSent) < time.Second)
 
 	// Invalid key should return found: false but no error
-	_, found, err = redisState.GetTxSentToRelay("XXX")
+	_, found, err = redisState.GetTxSentToRelay(ctx, "XXX")
 	require.Nil(t, err, err)
 	require.False(t, found)
 
 	// After resetting redis, we shouldn't be able to find the key
 	resetRedis()
-	_, found, err = redisState.GetTxSentToRelay("foo")
+	_, found, err = redisState.GetTxSentToRelay(ctx, "foo")
 	require.Nil(t, err, err)
 	require.False(t, found)
 }
 
+func TestTxCancelled(t *testing.T) {
+	ctx := context.Background()
+	resetRedis()
+
+	found, err := redisState.GetTxCancelled(ctx, "0xFoo")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, redisState.SetTxCancelled(ctx, "0xFoo"))
+	found, err = redisState.GetTxCancelled(ctx, "0xfoo")
+	require.NoError(t, err)
+	require.True(t, found)
+}
+
 func TestTxHashForSenderAndNonce(t *testing.T) {
+	ctx := context.Background()
 	var err error
 	resetRedis()
 
@@ -74,22 +92,22 @@ func TestTxHashForSenderAndNonce(t *testing.T) {
 	txHash := "0x0TxHash"
 
 	// Ensure key is correct
-	key := RedisKeyTxHashForSenderAndNonce(txFrom, nonce)
-	expectedKey := fmt.Sprintf("%s%s_%d", RedisPrefixTxHashForSenderAndNonce, strings.ToLower(txFrom), nonce)
+	key := redisState.keys.TxHashForSenderAndNonce(txFrom, nonce)
+	expectedKey := fmt.Sprintf("%s%s%s_%d", RedisPrefix, RedisPrefixTxHashForSenderAndNonce, strings.ToLower(txFrom), nonce)
 	require.Equal(t, expectedKey, key)
 
 	// Get before set: should return not found
-	txHashFromRedis, found, err := redisState.GetTxHashForSenderAndNonce(txFrom, nonce)
+	txHashFromRedis, found, err := redisState.GetTxHashForSenderAndNonce(ctx, txFrom, nonce)
 	require.Nil(t, err, err)
 	require.False(t, found)
 	require.Equal(t, "", txHashFromRedis)
 
 	// Set
-	err = redisState.SetTxHashForSenderAndNonce(txFrom, nonce, txHash)
+	err = redisState.SetTxHashForSenderAndNonce(ctx, txFrom, nonce, txHash)
 	require.Nil(t, err, err)
 
 	// Get
-	txHashFromRedis, found, err = redisState.GetTxHashForSenderAndNonce(txFrom, nonce)
+	txHashFromRedis, found, err = redisState.GetTxHashForSenderAndNonce(ctx, txFrom, nonce)
 	require.Nil(t, err, err)
 	require.True(t, found)
 
@@ -99,48 +117,50 @@ func TestTxHashForSenderAndNonce(t *testing.T) {
 }
 
 func TestNonceFixForAccount(t *testing.T) {
+	ctx := context.Background()
 	var err error
 	resetRedis()
 
 	txFrom := "0x0Sender"
 
-	numTimesSent, found, err := redisState.GetNonceFixForAccount(txFrom)
+	numTimesSent, found, err := redisState.GetNonceFixForAccount(ctx, txFrom)
 	require.Nil(t, err, err)
 	require.False(t, found)
 	require.Equal(t, uint64(0), numTimesSent)
 
-	err = redisState.SetNonceFixForAccount(txFrom, 0)
+	err = redisState.SetNonceFixForAccount(ctx, txFrom, 0)
 	require.Nil(t, err, err)
 
-	numTimesSent, found, err = redisState.GetNonceFixForAccount(txFrom)
+	numTimesSent, found, err = redisState.GetNonceFixForAccount(ctx, txFrom)
 	require.Nil(t, err, err)
 	require.True(t, found)
 	require.Equal(t, uint64(0), numTimesSent)
 
-	err = redisState.DelNonceFixForAccount(txFrom)
+	err = redisState.DelNonceFixForAccount(ctx, txFrom)
 	require.Nil(t, err, err)
 
-	numTimesSent, found, err = redisState.GetNonceFixForAccount(txFrom)
+	numTimesSent, found, err = redisState.GetNonceFixForAccount(ctx, txFrom)
 	require.Nil(t, err, err)
 	require.False(t, found)
 	require.Equal(t, uint64(0), numTimesSent)
 
-	err = redisState.SetNonceFixForAccount(txFrom, 17)
+	err = redisState.SetNonceFixForAccount(ctx, txFrom, 17)
 	require.Nil(t, err, err)
 
-	numTimesSent, found, err = redisState.GetNonceFixForAccount(txFrom)
+	numTimesSent, found, err = redisState.GetNonceFixForAccount(ctx, txFrom)
 	require.Nil(t, err, err)
 	require.True(t, found)
 	require.Equal(t, uint64(17), numTimesSent)
 
 	// Ensure it matches txFrom case-insensitive
-	numTimesSent, found, err = redisState.GetNonceFixForAccount(strings.ToUpper(txFrom))
+	numTimesSent, found, err = redisState.GetNonceFixForAccount(ctx, strings.ToUpper(txFrom))
 	require.Nil(t, err, err)
 	require.True(t, found)
 	require.Equal(t, uint64(17), numTimesSent)
 }
 
 func TestSenderOfTxHash(t *testing.T) {
+	ctx := context.Background()
 	var err error
 	resetRedis()
 
@@ -148,104 +168,320 @@ func TestSenderOfTxHash(t *testing.T) {
 	txHash := "0xDeadBeef"
 	txNonce := uint64(1337)
 
-	val, found, err := redisState.GetSenderOfTxHash(txHash)
+	val, found, err := redisState.GetSenderOfTxHash(ctx, txHash)
 	require.Nil(t, err, err)
 	require.False(t, found)
 	require.Equal(t, "", val)
 
-	err = redisState.SetSenderAndNonceOfTxHash(txHash, txFrom, txNonce)
+	err = redisState.SetSenderAndNonceOfTxHash(ctx, txHash, txFrom, txNonce)
 	require.Nil(t, err, err)
 
-	val, found, err = redisState.GetSenderOfTxHash(txHash)
+	val, found, err = redisState.GetSenderOfTxHash(ctx, txHash)
 	require.Nil(t, err, err)
 	require.True(t, found)
 	require.Equal(t, strings.ToLower(txFrom), val)
 }
 
+func TestSenderOfTxHashLegacyKeys(t *testing.T) {
+	ctx := context.Background()
+	resetRedis()
+
+	// Keys written before the hash tag was added are still read
+	require.NoError(t, redisServer.Set("rpc-endpoint:txsender-of-txhash:0xdeadbeef", "0x0sender"))
+	require.NoError(t, redisServer.Set("rpc-endpoint:txnonce-of-txhash:0xdeadbeef", "1337"))
+	sender, found, err := redisState.GetSenderOfTxHash(ctx, "0xDeadBeef")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "0x0sender", sender)
+	nonce, found, err := redisState.GetNonceOfTxHash(ctx, "0xDeadBeef")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(1337), nonce)
+
+	// The current keys take precedence
+	require.NoError(t, redisState.SetSenderAndNonceOfTxHash(ctx, "0xDeadBeef", "0x0Other", 1))
+	nonce, _, err = redisState.GetNonceOfTxHash(ctx, "0xDeadBeef")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), nonce)
+}
+
 func TestSenderMaxNonce(t *testing.T) {
+	ctx := context.Background()
 	var err error
 	resetRedis()
 
 	txFrom := "0x0Sender"
 
-	val, found, err := redisState.GetSenderMaxNonce(txFrom)
+	val, found, err := redisState.GetSenderMaxNonce(ctx, txFrom)
 	require.Nil(t, err, err)
 	require.False(t, found)
 	require.Equal(t, uint64(0), val)
 
-	err = redisState.SetSenderMaxNonce(txFrom, 17, 0)
+	err = redisState.SetSenderMaxNonce(ctx, txFrom, 17, 0)
 	require.Nil(t, err, err)
 
-	val, found, err = redisState.GetSenderMaxNonce(txFrom)
+	val, found, err = redisState.GetSenderMaxNonce(ctx, txFrom)
 	require.Nil(t, err, err)
 	require.True(t, found)
 	require.Equal(t, uint64(17), val)
 
-	err = redisState.SetSenderMaxNonce(txFrom, 16, 10)
+	err = redisState.SetSenderMaxNonce(ctx, txFrom, 16, 10)
 	require.Nil(t, err, err)
 
-	val, found, err = redisState.GetSenderMaxNonce(txFrom)
+	val, found, err = redisState.GetSenderMaxNonce(ctx, txFrom)
 	require.Nil(t, err, err)
 	require.True(t, found)
 	require.Equal(t, uint64(17), val)
 
-	err = redisState.SetSenderMaxNonce(txFrom, 18, 0)
+	err = redisState.SetSenderMaxNonce(ctx, txFrom, 18, 0)
 	require.Nil(t, err, err)
 
-	val, found, err = redisState.GetSenderMaxNonce(txFrom)
+	val, found, err = redisState.GetSenderMaxNonce(ctx, txFrom)
 	require.Nil(t, err, err)
 	require.True(t, found)
 	require.Equal(t, uint64(18), val)
 }
 
 func TestWhitehatTx(t *testing.T) {
+	ctx := context.Background()
 	resetRedis()
 	bundleId := "123"
 
 	// get (empty)
-	txs, err := redisState.GetWhitehatBundleTx(bundleId)
+	txs, err := redisState.GetWhitehatBundleTx(ctx, bundleId)
 	require.Nil(t, err, err)
 	require.Equal(t, 0, len(txs))
 
 	// add #1
 	tx1 := "0xa12345"
 	tx2 := "0xb123456"
-	err = redisState.AddTxToWhitehatBundle(bundleId, tx1)
+	err = redisState.AddTxToWhitehatBundle(ctx, bundleId, tx1)
 	require.Nil(t, err, err)
 
-	txs, err = redisState.GetWhitehatBundleTx(bundleId)
+	txs, err = redisState.GetWhitehatBundleTx(ctx, bundleId)
 	require.Nil(t, err, err)
 	require.Equal(t, 1, len(txs))
 
-	err = redisState.AddTxToWhitehatBundle(bundleId, tx1)
+	err = redisState.AddTxToWhitehatBundle(ctx, bundleId, tx1)
 	require.Nil(t, err, err)
-	err = redisState.AddTxToWhitehatBundle(bundleId, tx2)
+	err = redisState.AddTxToWhitehatBundle(ctx, bundleId, tx2)
 	require.Nil(t, err, err)
 
-	txs, err = redisState.GetWhitehatBundleTx(bundleId)
+	txs, err = redisState.GetWhitehatBundleTx(ctx, bundleId)
 	require.Nil(t, err, err)
 	require.Equal(t, 2, len(txs))
 	require.Equal(t, tx2, txs[0])
 	require.Equal(t, tx1, txs[1])
 
-	err = redisState.DelWhitehatBundleTx(bundleId)
+	err = redisState.DelWhitehatBundleTx(ctx, bundleId)
 	require.Nil(t, err, err)
 
-	txs, err = redisState.GetWhitehatBundleTx(bundleId)
+	txs, err = redisState.GetWhitehatBundleTx(ctx, bundleId)
 	require.Nil(t, err, err)
 	require.Equal(t, 0, len(txs))
 }
 
 func TestBlockedTxHash(t *testing.T) {
+	ctx := context.Background()
 	resetRedis()
 	txHash := "0x123"
 	retVal := "foo"
 
-	err := redisState.SetBlockedTxHash(txHash, retVal)
+	err := redisState.SetBlockedTxHash(ctx, txHash, retVal)
 	require.Nil(t, err, err)
 
-	val, found, err := redisState.GetBlockedTxHash(txHash)
+	val, found, err := redisState.GetBlockedTxHash(ctx, txHash)
 	require.Nil(t, err, err)
 	require.True(t, found)
 	require.Equal(t, retVal, val)
 }
+
+func TestWebhookDeliveries(t *testing.T) {
+	ctx := context.Background()
+	resetRedis()
+	now := time.Unix(1700000000, 0)
+
+	require.NoError(t, redisState.AddWebhookDelivery(ctx, "a", now.Add(-time.Second)))
+	require.NoError(t, redisState.AddWebhookDelivery(ctx, "b", now.Add(-2*time.Second)))
+	require.NoError(t, redisState.AddWebhookDelivery(ctx, "c", now.Add(time.Second)))
+
+	// Due deliveries are returned oldest first, and are not due again until the lease ends
+	deliveries, err := redisState.ClaimWebhookDeliveries(ctx, now, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "a"}, deliveries)
+	deliveries, err = redisState.ClaimWebhookDeliveries(ctx, now, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+
+	require.NoError(t, redisState.DelWebhookDelivery(ctx, "a"))
+	deliveries, err = redisState.ClaimWebhookDeliveries(ctx, now.Add(time.Minute), now.Add(2*time.Minute), 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c"}, deliveries)
+	deliveries, err = redisState.ClaimWebhookDeliveries(ctx, now.Add(time.Minute), now.Add(2*time.Minute), 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, deliveries)
+}
+
+func TestMempoolBroadcasts(t *testing.T) {
+	ctx := context.Background()
+	resetRedis()
+	now := time.Unix(1700000000, 0)
+
+	require.NoError(t, redisState.AddMempoolBroadcast(ctx, "a", now.Add(-time.Second)))
+	require.NoError(t, redisState.AddMempoolBroadcast(ctx, "b", now.Add(time.Second)))
+
+	broadcasts, err := redisState.ClaimMempoolBroadcasts(ctx, now, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, broadcasts)
+	broadcasts, err = redisState.ClaimMempoolBroadcasts(ctx, now, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Empty(t, broadcasts)
+
+	// A claimed broadcast which isn't deleted is due again when the lease ends
+	require.NoError(t, redisState.DelMempoolBroadcast(ctx, "b"))
+	broadcasts, err = redisState.ClaimMempoolBroadcasts(ctx, now.Add(time.Minute), now.Add(2*time.Minute), 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, broadcasts)
+}
+
+func TestSenderMaxNonceConcurrent(t *testing.T) {
+	ctx := context.Background()
+	resetRedis()
+	txFrom := "0x0Sender"
+
+	var wg sync.WaitGroup
+	for nonce := uint64(0); nonce < 50; nonce++ {
+		wg.Add(1)
+		go func(nonce uint64) {
+			defer wg.Done()
+			require.NoError(t, redisState.SetSenderMaxNonce(ctx, txFrom, nonce, 10))
+		}(nonce)
+	}
+	wg.Wait()
+
+	val, found, err := redisState.GetSenderMaxNonce(ctx, txFrom)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(49), val)
+	require.Equal(t, 120*time.Second, redisServer.TTL(redisState.keys.SenderMaxNonce(txFrom)))
+}
+
+func TestNonceFixForAccountConcurrentIncrements(t *testing.T) {
+	ctx := context.Background()
+	resetRedis()
+	txFrom := "0x0Sender"
+
+	_, found, err := redisState.IncNonceFixForAccount(ctx, txFrom)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, redisState.SetNonceFixForAccount(ctx, txFrom, 0))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, found, err := redisState.IncNonceFixForAccount(ctx, txFrom)
+			require.NoError(t, err)
+			require.True(t, found)
+		}()
+	}
+	wg.Wait()
+
+	numTimesSent, found, err := redisState.GetNonceFixForAccount(ctx, txFrom)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(20), numTimesSent)
+	require.Equal(t, DefaultStateOptions.NonceFixForAccountExpiry, redisServer.TTL(redisState.keys.NonceFixForAccount(txFrom)))
+}
+
+func TestWhitehatTxConcurrent(t *testing.T) {
+	ctx := context.Background()
+	resetRedis()
+	bundleId := "123"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			require.NoError(t, redisState.AddTxToWhitehatBundle(ctx, bundleId, "0xsame"))
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			require.NoError(t, redisState.AddTxToWhitehatBundle(ctx, bundleId, fmt.Sprintf("0x%d", i)))
+		}(i)
+	}
+	wg.Wait()
+
+	txs, err := redisState.GetWhitehatBundleTx(ctx, bundleId)
+	require.NoError(t, err)
+	require.Len(t, txs, 11)
+	require.Equal(t, DefaultStateOptions.WhitehatBundleExpiry, redisServer.TTL(redisState.keys.WhitehatBundleTransactions(bundleId)))
+}
+
+func TestSenderAndNonceOfTxHashConcurrent(t *testing.T) {
+	ctx := context.Background()
+	resetRedis()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			require.NoError(t, redisState.SetSenderAndNonceOfTxHash(ctx, "0xTxHash", fmt.Sprintf("0xSender%d", i), uint64(i)))
+		}(i)
+	}
+	wg.Wait()
+
+	// Sender and nonce are always from the same write
+	sender, found, err := redisState.GetSenderOfTxHash(ctx, "0xTxHash")
+	require.NoError(t, err)
+	require.True(t, found)
+	nonce, found, err := redisState.GetNonceOfTxHash(ctx, "0xTxHash")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, fmt.Sprintf("0xsender%d", nonce), sender)
+}
+
+func TestRedisStateNamespace(t *testing.T) {
+	ctx := context.Background()
+	resetRedis()
+	opts := DefaultStateOptions
+	opts.Namespace = "sepolia"
+	opts.TxSentToRelayExpiry = 30 * time.Second
+	opts.BlockTime = 2 * time.Second
+	sepoliaState, err := NewRedisState(redisServer.Addr(), opts)
+	require.NoError(t, err)
+
+	require.NoError(t, sepoliaState.SetTxSentToRelay(ctx, "0xFoo"))
+	require.NoError(t, sepoliaState.SetSenderMaxNonce(ctx, "0xSender", 1, 0))
+	require.True(t, redisServer.Exists("rpc-endpoint:sepolia:tx-sent-to-relay:0xfoo"))
+	require.Equal(t, 30*time.Second, redisServer.TTL("rpc-endpoint:sepolia:tx-sent-to-relay:0xfoo"))
+	require.Equal(t, 50*time.Second, redisServer.TTL("rpc-endpoint:sepolia:txsender-pending-max-nonce:0xsender"))
+
+	// Other namespaces don't see the entries
+	_, found, err := redisState.GetTxSentToRelay(ctx, "0xFoo")
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestStateOptionsValidate(t *testing.T) {
+	resetRedis()
+	require.NoError(t, DefaultStateOptions.Validate())
+	require.NoError(t, StateOptions{Namespace: "mainnet"}.WithDefaults().Validate())
+	require.Equal(t, 300*time.Second, DefaultStateOptions.SenderMaxNonceExpiry(0))
+	require.Equal(t, 24*time.Second, DefaultStateOptions.SenderMaxNonceExpiry(2))
+
+	for _, opts := range []StateOptions{
+		{Namespace: "main net"},
+		{Namespace: "{mainnet}"},
+		{TxSentToRelayExpiry: 500 * time.Millisecond},
+		{BlockedTxHashExpiry: -time.Minute},
+		{DefaultBlockRange: -1},
+	} {
+		require.Error(t, opts.WithDefaults().Validate(), opts)
+	}
+	_, err := NewRedisState(redisServer.Addr(), StateOptions{})
+	require.Error(t, err)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/metachris/flashbotsrpc"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/flashbots/rpc-endpoint/metrics"
+	"github.com/flashbots/rpc-endpoint/types"
+)
+
+// relayClient sends signed requests to the relay like flashbotsrpc.CallWithFlashbotsSignature, but the requests
+// are cancelled with their context. Relay errors wrap flashbotsrpc.ErrRelayErrorResponse.
+type relayClient struct {
+	url        string
+	signingKey *ecdsa.PrivateKey
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+// relayRequest has the field order of flashbotsrpc, so the signed body is the same
+type relayRequest struct {
+	ID      int           `json:"id"`
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+func newRelayClient(url string, signingKey *ecdsa.PrivateKey, originId string) *relayClient {
+	c := &relayClient{
+		url:        url,
+		signingKey: signingKey,
+		headers:    make(map[string]string),
+		httpClient: http.DefaultClient,
+	}
+	if originId != "" {
+		c.headers["X-Flashbots-Origin"] = originId
+	}
+	return c
+}
+
+func (c *relayClient) call(ctx context.Context, method string, params ...interface{}) (res json.RawMessage, err error) {
+	ctx, span := startSpan(ctx, "relay."+method, attribute.String("rpc.method", method))
+	start := time.Now()
+	defer func() {
+		metrics.ObserveRelayRequest(method, relayResult(ctx, err), time.Since(start))
+		endSpan(span, err)
+	}()
+
+	body, err := json.Marshal(relayRequest{ID: 1, JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		return nil, err
+	}
+
+	hashedBody := crypto.Keccak256Hash(body).Hex()
+	sig, err := crypto.Sign(accounts.TextHash([]byte(hashedBody)), c.signingKey)
+	if err != nil {
+		return nil, err
+	}
+	signature := crypto.PubkeyToAddress(c.signingKey.PublicKey).Hex() + ":" + hexutil.Encode(sig)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("X-Flashbots-Signature", signature)
+	for k, v := range c.headers {
+		req.Header.Add(k, v)
+	}
+	injectTraceContext(ctx, req.Header)
+
+	httpRes, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+	data, err := io.ReadAll(httpRes.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// On error, the relay may answer with {"error":"..."} instead of JSON-RPC
+	errorResp := new(flashbotsrpc.RelayErrorResponse)
+	if err := json.Unmarshal(data, errorResp); err == nil && errorResp.Error != "" {
+		return nil, fmt.Errorf("%w: %s", flashbotsrpc.ErrRelayErrorResponse, errorResp.Error)
+	}
+	resp := new(types.JsonRpcResponse)
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+	if resp.Id != float64(1) || resp.Version != "2.0" {
+		return nil, fmt.Errorf("%w: invalid JSONRPC response (HTTP status code: %d)", flashbotsrpc.ErrRelayErrorResponse, httpRes.StatusCode)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("%w: %s", flashbotsrpc.ErrRelayErrorResponse, resp.Error.Message)
+	}
+	return resp.Result, nil
+}
+
+// cancelPrivateTransaction cancels a tx which was sent with eth_sendPrivateTransaction
+func (c *relayClient) cancelPrivateTransaction(ctx context.Context, txHash string) error {
+	_, err := c.call(ctx, "eth_cancelPrivateTransaction", flashbotsrpc.FlashbotsCancelPrivateTransactionRequest{TxHash: txHash})
+	return err
+}
+
+// relayResult classifies the error of a relay call as a metrics label
+func relayResult(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, flashbotsrpc.ErrRelayErrorResponse):
+		return "relay_error"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "timeout"
+	case ctx.Err() != nil:
+		return "cancelled"
+	default:
+		return "network_error"
+	}
+}
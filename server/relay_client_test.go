@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/metachris/flashbotsrpc"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayClient(t *testing.T) {
+	signingKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	var response string
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NotEmpty(t, r.Header.Get("X-Flashbots-Signature"))
+		require.Equal(t, "origin", r.Header.Get("X-Flashbots-Origin"))
+		if response == "" {
+			io.ReadAll(r.Body) // the server notices a closed connection once the body is read
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	defer relay.Close()
+	c := newRelayClient(relay.URL, signingKey, "origin")
+
+	response = `{"id":1,"jsonrpc":"2.0","result":"0x1"}`
+	res, err := c.call(context.Background(), "eth_sendPrivateTransaction")
+	require.NoError(t, err)
+	require.Equal(t, `"0x1"`, string(res))
+
+	response = `{"error":"invalid tx"}`
+	_, err = c.call(context.Background(), "eth_sendPrivateTransaction")
+	require.True(t, errors.Is(err, flashbotsrpc.ErrRelayErrorResponse))
+	require.Contains(t, err.Error(), "invalid tx")
+
+	response = `{"id":1,"jsonrpc":"2.0","error":{"code":-32000,"message":"tx not found"}}`
+	err = c.cancelPrivateTransaction(context.Background(), "0xfoo")
+	require.True(t, errors.Is(err, flashbotsrpc.ErrRelayErrorResponse))
+	require.Contains(t, err.Error(), "tx not found")
+
+	response = ""
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.call(ctx, "eth_sendPrivateTransaction")
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+}
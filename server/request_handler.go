@@ -1,18 +1,20 @@
 package server
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/rand"
 
-	"github.com/flashbots/rpc-endpoint/application"
 	"github.com/flashbots/rpc-endpoint/database"
 	"github.com/flashbots/rpc-endpoint/metrics"
 	"github.com/flashbots/rpc-endpoint/types"
@@ -26,50 +28,66 @@ type RpcRequestHandler struct {
 	req                  *http.Request
 	logger               log.Logger
 	timeStarted          time.Time
+	chain                *Chain
 	defaultProxyUrl      string
 	proxyTimeoutSeconds  int
 	relaySigningKey      *ecdsa.PrivateKey
-	relayUrl             string
 	uid                  uuid.UUID
 	requestRecord        *requestRecord
 	builderNames         []string
-	chainID              []byte
-	rpcCache             *application.RpcCache
-	defaultEthClient     *ethclient.Client
 	configurationWatcher *ConfigurationWatcher
+	methodPolicy         *MethodPolicy
+	upstreamGuard        *UpstreamGuard
+	mempoolBroadcaster   *MempoolBroadcaster
+	recordWriter         *RecordWriter
+	timeouts             RequestTimeouts
+	drain                *DrainState
+	auditSink            AuditSink
+	decision             *DecisionRecord // of an eth_sendRawTransaction, emitted to the audit sink with the record
+	rpcMethod            string          // metrics label of the JSON-RPC method, empty until the request is parsed
+	rpcError             bool            // whether the JSON-RPC response is an error
 }
 
 func NewRpcRequestHandler(
 	logger log.Logger,
 	respw *http.ResponseWriter,
 	req *http.Request,
-	proxyUrl string,
+	chain *Chain,
 	proxyTimeoutSeconds int,
 	relaySigningKey *ecdsa.PrivateKey,
-	relayUrl string,
 	db database.Store,
-	builderNames []string,
-	chainID []byte,
-	rpcCache *application.RpcCache,
-	defaultEthClient *ethclient.Client,
 	configurationWatcher *ConfigurationWatcher,
+	methodPolicy *MethodPolicy,
+	upstreamGuard *UpstreamGuard,
+	mempoolBroadcaster *MempoolBroadcaster,
+	recordWriter *RecordWriter,
+	timeouts RequestTimeouts,
+	drain *DrainState,
+	auditSink AuditSink,
 ) *RpcRequestHandler {
+	if chain.name != "" {
+		logger = logger.New("chain", chain.name)
+	}
 	return &RpcRequestHandler{
 		logger:               logger,
 		respw:                respw,
 		req:                  req,
 		timeStarted:          Now(),
-		defaultProxyUrl:      proxyUrl,
+		chain:                chain,
+		defaultProxyUrl:      chain.proxyUrl(),
 		proxyTimeoutSeconds:  proxyTimeoutSeconds,
 		relaySigningKey:      relaySigningKey,
-		relayUrl:             relayUrl,
 		uid:                  uuid.New(),
 		requestRecord:        NewRequestRecord(db),
-		builderNames:         builderNames,
-		chainID:              chainID,
-		rpcCache:             rpcCache,
-		defaultEthClient:     defaultEthClient,
+		builderNames:         chain.builderNameProvider.BuilderNames(),
 		configurationWatcher: configurationWatcher,
+		methodPolicy:         methodPolicy,
+		upstreamGuard:        upstreamGuard,
+		mempoolBroadcaster:   mempoolBroadcaster,
+		recordWriter:         recordWriter,
+		timeouts:             timeouts.WithDefaults(),
+		drain:                drain,
+		auditSink:            auditSink,
 	}
 }
 
@@ -89,6 +107,7 @@ func (r *RpcRequestHandler) getEffectiveParameters() (URLParameters, error) {
 	}
 	if preset, exists := r.configurationWatcher.ParsedPresets[originID]; exists {
 		r.logger.Info("Using preset configuration", "originID", originID)
+		preset.presetApplied = true
 		return preset, nil
 	}
 
@@ -100,9 +119,15 @@ func (r *RpcRequestHandler) process() {
 	r.logger = r.logger.New("uid", r.uid)
 	r.logger.Info("[process] POST request received")
 
+	// Calls to the node, Redis, the relay and other services are cancelled with the request, and continue its trace
+	ctx, cancel := context.WithTimeout(extractTraceContext(r.req.Context(), r.req.Header), r.timeouts.Request)
+	defer cancel()
 	defer r.finishRequest()
+	ctx, span := startSpan(ctx, "RpcRequestHandler.process", attribute.String("request.id", r.uid.String()), attribute.String("chain", r.chain.name))
+	defer span.End()
 	r.requestRecord.requestEntry.ReceivedAt = r.timeStarted
 	r.requestRecord.requestEntry.Id = r.uid
+	r.requestRecord.requestEntry.Chain = r.chain.name
 	r.requestRecord.UpdateRequestEntry(r.req, http.StatusOK, "")
 
 	whitehatBundleId := r.req.URL.Query().Get("bundle")
@@ -113,9 +138,16 @@ func (r *RpcRequestHandler) process() {
 
 	// If users specify a proxy url in their rpc endpoint they can have their requests proxied to that endpoint instead of Infura
 	// e.g. https://rpc.flashbots.net?url=http://RPC-ENDPOINT.COM
-	customProxyUrl, ok := r.req.URL.Query()["url"]
-	if ok && len(customProxyUrl[0]) > 1 {
+	customProxyUrl, isCustomProxyUrl := r.req.URL.Query()["url"]
+	isCustomProxyUrl = isCustomProxyUrl && len(customProxyUrl[0]) > 1
+	if isCustomProxyUrl {
 		metrics.UrlParamUsageInc()
+		if err := r.upstreamGuard.CheckURL(ctx, customProxyUrl[0]); err != nil {
+			r.logger.Info("[process] Custom url not allowed", "url", customProxyUrl[0], "error", err)
+			r.requestRecord.UpdateRequestEntry(r.req, http.StatusBadRequest, err.Error())
+			http.Error(*r.respw, "invalid url param: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 		r.defaultProxyUrl = customProxyUrl[0]
 		r.logger.Info("[process] Using custom url", "url", r.defaultProxyUrl)
 	}
@@ -139,10 +171,14 @@ func (r *RpcRequestHandler) process() {
 	fingerprint, _ := FingerprintFromRequest(r.req, time.Now(), seed)
 	if fingerprint != 0 {
 		r.logger = r.logger.New("fingerprint", fingerprint.ToIPv6().String())
+		r.requestRecord.requestEntry.IpHash = fmt.Sprintf("%016x", uint64(fingerprint))
 	}
 
 	// create rpc proxy client for making proxy request
 	client := NewRPCProxyClient(r.logger, r.defaultProxyUrl, r.proxyTimeoutSeconds, fingerprint)
+	if isCustomProxyUrl {
+		client = NewCustomRPCProxyClient(r.logger, r.defaultProxyUrl, r.proxyTimeoutSeconds, fingerprint, r.upstreamGuard)
+	}
 
 	r.requestRecord.UpdateRequestEntry(r.req, http.StatusOK, "") // Data analytics
 
@@ -163,6 +199,8 @@ func (r *RpcRequestHandler) process() {
 		return
 	}
 	r.logger = r.logger.New("rpc_method", jsonReq.Method)
+	r.rpcMethod = metrics.RpcMethodLabel(jsonReq.Method)
+	span.SetAttributes(attribute.String("rpc.method", jsonReq.Method), attribute.String("originId", urlParams.originId))
 
 	if r.configurationWatcher != nil && jsonReq.Method == "eth_sendRawTransaction" {
 		origin := urlParams.originId
@@ -175,27 +213,59 @@ func (r *RpcRequestHandler) process() {
 	}
 
 	// Process single request
-	r.processRequest(client, jsonReq, origin, referer, isWhitehatBundleCollection, whitehatBundleId, urlParams, r.req.URL.String(), body)
+	r.processRequest(ctx, client, jsonReq, origin, referer, isWhitehatBundleCollection, whitehatBundleId, urlParams, r.req.URL.String(), body)
 }
 
 // processRequest handles single request
-func (r *RpcRequestHandler) processRequest(client RPCProxyClient, jsonReq *types.JsonRpcRequest, origin, referer string, isWhitehatBundleCollection bool, whitehatBundleId string, urlParams URLParameters, reqURL string, body []byte) {
+func (r *RpcRequestHandler) processRequest(ctx context.Context, client RPCProxyClient, jsonReq *types.JsonRpcRequest, origin, referer string, isWhitehatBundleCollection bool, whitehatBundleId string, urlParams URLParameters, reqURL string, body []byte) {
 	var entry *database.EthSendRawTxEntry
 	if jsonReq.Method == "eth_sendRawTransaction" || jsonReq.Method == "eth_sendPrivateTransaction" {
 		entry = r.requestRecord.AddEthSendRawTxEntry(uuid.New())
 		// log the full url for debugging
 		r.logger.Info("[processRequest] ", jsonReq.Method, " request URL", "url", reqURL)
 	}
+	if jsonReq.Method == "eth_sendRawTransaction" && r.auditSink != nil {
+		r.decision = newDecisionRecord(r.uid, r.chain.name, entry)
+	}
 	// Handle single request
-	rpcReq := NewRpcRequest(r.logger, client, jsonReq, r.relaySigningKey, r.relayUrl, origin, referer, isWhitehatBundleCollection, whitehatBundleId, entry, urlParams, r.chainID, r.rpcCache, r.defaultEthClient)
+	rpcReq := NewRpcRequest(r.logger, client, jsonReq, r.relaySigningKey, origin, referer, isWhitehatBundleCollection, whitehatBundleId, entry, r.decision, urlParams, r.chain, r.upstreamGuard, r.mempoolBroadcaster, r.timeouts)
 
 	if err := rpcReq.CheckFlashbotsSignature(r.req.Header.Get("X-Flashbots-Signature"), body); err != nil {
 		r.logger.Warn("[processRequest] CheckFlashbotsSignature", "error", err)
+		rpcReq.decide("signature", DecisionStop, err.Error())
 		rpcReq.writeRpcError(err.Error(), types.JsonRpcInvalidRequest)
 		r._writeRpcResponse(rpcReq.jsonRes)
 		return
 	}
-	res := rpcReq.ProcessRequest()
+
+	authenticated := r.configurationWatcher.IsAuthenticated(urlParams.originId, rpcReq.flashbotsSigningAddress)
+	if !r.methodPolicy.Allows(jsonReq.Method, r.configurationWatcher.MethodPolicy(urlParams.originId), authenticated) {
+		r.logger.Info("[processRequest] Method not allowed", "originId", urlParams.originId)
+		rpcReq.decide("method_policy", DecisionStop, "method not allowed")
+		rpcReq.writeRpcError(fmt.Sprintf("the method %s does not exist/is not available", jsonReq.Method), types.JsonRpcMethodNotFound)
+		r._writeRpcResponse(rpcReq.jsonRes)
+		return
+	}
+
+	// In-flight txs are still sent, new ones can be sent to another instance
+	if entry != nil && r.drain.IsDraining() {
+		r.logger.Info("[processRequest] Tx refused while draining")
+		rpcReq.decide("draining", DecisionStop, r.drain.Status().DrainReason)
+		rpcReq.writeRpcError("server is draining, please retry", types.JsonRpcDraining)
+		r._writeRpcResponse(rpcReq.jsonRes)
+		return
+	}
+
+	res := rpcReq.ProcessRequest(ctx)
+	if entry != nil {
+		metrics.IncPrivateTxOutcome(r.configurationWatcher.OriginLabel(urlParams.originId), privateTxOutcome(entry))
+	}
+	if reason := cancelReason(ctx); reason != "" {
+		r.logger.Info("[processRequest] Request cancelled", "reason", reason)
+		r.requestRecord.requestEntry.CancelReason = reason
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("cancel.reason", reason))
+		metrics.IncRequestCancelled(reason)
+	}
 	// Write response
 	r._writeRpcResponse(res)
 }
@@ -203,11 +273,41 @@ func (r *RpcRequestHandler) processRequest(client RPCProxyClient, jsonReq *types
 func (r *RpcRequestHandler) finishRequest() {
 	reqDuration := time.Since(r.timeStarted) // At end of request, log the time it needed
 	r.requestRecord.requestEntry.RequestDurationMs = reqDuration.Milliseconds()
-	go func() {
-		// Save both request entry and raw tx entries if present
-		if err := r.requestRecord.SaveRecord(); err != nil {
-			log.Error("saveRecord failed", "requestId", r.requestRecord.requestEntry.Id, "error", err)
-		}
-	}()
+	if r.rpcMethod != "" {
+		metrics.ObserveRpcRequest(r.rpcMethod, r.rpcError, reqDuration)
+	}
+	r.saveRecord()
 	r.logger.Info("Request finished", "duration", reqDuration.Seconds())
 }
+
+func (r *RpcRequestHandler) saveRecord() {
+	if r.decision != nil {
+		r.decision.complete()
+		r.auditSink.Emit(r.decision)
+	}
+	if r.recordWriter != nil {
+		r.recordWriter.Enqueue(r.requestRecord)
+	} else if err := r.requestRecord.SaveRecord(); err != nil {
+		log.Error("saveRecord failed", "requestId", r.requestRecord.requestEntry.Id, "error", err)
+	}
+}
+
+// privateTxOutcome returns how a private tx request was handled, as a metrics label
+func privateTxOutcome(entry *database.EthSendRawTxEntry) string {
+	switch {
+	case entry.IsBlocked:
+		return "blocked"
+	case entry.Error != "":
+		return "error"
+	case entry.IsWhiteHatBundleCollection:
+		return "whitehat"
+	case entry.IsCancelTx:
+		return "cancel"
+	case entry.WasSentToRelay:
+		return "relayed"
+	case entry.WasSentToMempool:
+		return "mempool"
+	default:
+		return "none"
+	}
+}
@@ -1,13 +1,26 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	vmetrics "github.com/VictoriaMetrics/metrics"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/database"
 	"github.com/flashbots/rpc-endpoint/metrics"
+	"github.com/flashbots/rpc-endpoint/testutils"
+	"github.com/flashbots/rpc-endpoint/types"
 	"github.com/stretchr/testify/require"
 )
 
@@ -101,8 +114,113 @@ func TestRpcRequestHandler_UrlParam(t *testing.T) {
 	metrics.UrlParamUsage.Set(0)
 
 	var rw http.ResponseWriter = wrec
-	rh := NewRpcRequestHandler(log.New(), &rw, req, "", 0, nil, "", nil, nil, nil, nil, nil, nil)
+	rh := NewRpcRequestHandler(log.New(), &rw, req, &Chain{proxyUrls: []string{""}, builderNameProvider: staticBuilderNames{}}, 0, nil, nil, nil, nil, nil, nil, nil, RequestTimeouts{}, nil, nil)
 	rh.process()
 
 	require.Equal(t, uint64(1), metrics.UrlParamUsage.Get())
 }
+
+func TestRpcRequestHandler_UrlParamNotAllowed(t *testing.T) {
+	wrec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/?url=http://169.254.169.254/latest", nil)
+
+	var rw http.ResponseWriter = wrec
+	rh := NewRpcRequestHandler(log.New(), &rw, req, &Chain{proxyUrls: []string{""}, builderNameProvider: staticBuilderNames{}}, 0, nil, nil, nil, nil, NewUpstreamGuard(nil), nil, nil, RequestTimeouts{}, nil, nil)
+	rh.process()
+
+	require.Equal(t, http.StatusBadRequest, wrec.Code)
+	require.Contains(t, wrec.Body.String(), "upstream is not allowed")
+}
+
+func TestRpcRequestHandler_Cancelled(t *testing.T) {
+	slowNode := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body) // the server notices a closed connection once the body is read
+		<-r.Context().Done()
+	}))
+	defer slowNode.Close()
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`
+	chain := &Chain{proxyUrls: []string{slowNode.URL}, builderNameProvider: staticBuilderNames{}}
+
+	t.Run("timeout", func(t *testing.T) {
+		wrec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		var rw http.ResponseWriter = wrec
+		rh := NewRpcRequestHandler(log.New(), &rw, req, chain, 0, nil, nil, nil, nil, nil, nil, nil, RequestTimeouts{Request: 50 * time.Millisecond}, nil, nil)
+		rh.process()
+
+		require.Equal(t, CancelReasonTimeout, rh.requestRecord.requestEntry.CancelReason)
+		require.Contains(t, wrec.Body.String(), "internal server error")
+	})
+
+	t.Run("client gone", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		wrec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body)).WithContext(ctx)
+		var rw http.ResponseWriter = wrec
+		rh := NewRpcRequestHandler(log.New(), &rw, req, chain, 0, nil, nil, nil, nil, nil, nil, nil, RequestTimeouts{}, nil, nil)
+		rh.process()
+
+		require.Equal(t, CancelReasonClientGone, rh.requestRecord.requestEntry.CancelReason)
+	})
+}
+
+func TestRpcRequestHandler_MethodMetrics(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer node.Close()
+	chain := &Chain{proxyUrls: []string{node.URL}, builderNameProvider: staticBuilderNames{}}
+
+	for method, label := range map[string]string{"eth_blockNumber": "eth_blockNumber", "made_upMethod": "other"} {
+		counter := vmetrics.GetOrCreateCounter(`rpc_requests_total{method="` + label + `",result="success"}`)
+		before := counter.Get()
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":[]}`))
+		var rw http.ResponseWriter = httptest.NewRecorder()
+		NewRpcRequestHandler(log.New(), &rw, req, chain, 0, nil, nil, nil, nil, nil, nil, nil, RequestTimeouts{}, nil, nil).process()
+		require.Equal(t, before+1, counter.Get(), method)
+	}
+}
+
+func TestRpcRequestHandler_RelaySendOutlivesClient(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redisServer.Close()
+	node := httptest.NewServer(http.HandlerFunc(testutils.RpcBackendHandler))
+	defer node.Close()
+
+	// The client goes away while the tx is sent to the relay
+	ctx, cancel := context.WithCancel(context.Background())
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		cancel()
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":"%s"}`, testutils.TestTx_BundleFailedTooManyTimes_Hash)
+	}))
+	defer relay.Close()
+
+	signingKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	memStore := database.NewMemStore()
+	s, err := NewRpcEndPointServer(Configuration{
+		RelaySigningKey:     signingKey,
+		DB:                  memStore,
+		Logger:              log.New(),
+		ProxyTimeoutSeconds: 1,
+		ProxyUrl:            node.URL,
+		RedisUrl:            redisServer.Addr(),
+		RelayUrl:            relay.URL,
+	})
+	require.NoError(t, err)
+
+	body, err := json.Marshal(types.NewJsonRpcRequest(1, "eth_sendRawTransaction", []interface{}{testutils.TestTx_BundleFailedTooManyTimes_RawTx}))
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	s.HandleHttpRequest(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)).WithContext(ctx))
+	var res types.JsonRpcResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Nil(t, res.Error)
+
+	entry := waitForRawTxEntries(t, memStore, 1)[0]
+	require.True(t, entry.WasSentToRelay)
+	require.Zero(t, entry.ErrorCode)
+}
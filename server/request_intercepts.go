@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -14,7 +15,7 @@ import (
 var ProtectTxApiHost = GetEnv("TX_API_HOST", "https://protect.flashbots.net")
 
 // If public getTransactionReceipt of a submitted tx is null, then check internal API to see if tx has failed
-func (r *RpcRequest) check_post_getTransactionReceipt(jsonResp *types.JsonRpcResponse) (requestFinished bool) {
+func (r *RpcRequest) check_post_getTransactionReceipt(ctx context.Context, jsonResp *types.JsonRpcResponse) (requestFinished bool) {
 	if jsonResp == nil {
 		return false
 	}
@@ -32,7 +33,9 @@ func (r *RpcRequest) check_post_getTransactionReceipt(jsonResp *types.JsonRpcRes
 	r.logger.Info("[post_getTransactionReceipt] eth_getTransactionReceipt is null, check if it was a private tx", "txHash", txHashLower)
 
 	// get tx status from private-tx-api
-	statusApiResponse, err := GetTxStatus(txHashLower)
+	txStatusCtx, cancel := withTimeout(ctx, r.timeouts.TxStatus)
+	defer cancel()
+	statusApiResponse, err := GetTxStatus(txStatusCtx, r.txApiHost, txHashLower)
 	if err != nil {
 		metrics.IncStatusEndpointErr()
 		r.logger.Error("[post_getTransactionReceipt] PrivateTxApi failed", "error", err)
@@ -41,7 +44,7 @@ func (r *RpcRequest) check_post_getTransactionReceipt(jsonResp *types.JsonRpcRes
 
 	resetMaxNonce := func(txFrom string, txHash string) {
 		// if the tx failed then we want to reset the redis max nonce
-		maxNonce, found, err := RState.GetSenderMaxNonce(txFrom)
+		maxNonce, found, err := r.state.GetSenderMaxNonce(ctx, txFrom)
 		if err != nil {
 			metrics.IncRedisErr()
 			r.logger.Error("[post_getTransactionReceipt] GetSenderMaxNonce failed", "error", err)
@@ -54,9 +57,9 @@ func (r *RpcRequest) check_post_getTransactionReceipt(jsonResp *types.JsonRpcRes
 		}
 
 		// we can elide error checking here since a txNonce of 0 will never match
-		txNonce, _, _ := RState.GetNonceOfTxHash(txHash)
+		txNonce, _, _ := r.state.GetNonceOfTxHash(ctx, txHash)
 		if maxNonce == txNonce {
-			if err := RState.DelSenderMaxNonce(txFrom); err != nil {
+			if err := r.state.DelSenderMaxNonce(ctx, txFrom); err != nil {
 				metrics.IncRedisErr()
 				r.logger.Error("[post_getTransactionReceipt] DelSenderMaxNonce failed", "error", err)
 			}
@@ -65,7 +68,7 @@ func (r *RpcRequest) check_post_getTransactionReceipt(jsonResp *types.JsonRpcRes
 
 	ensureAccountFixIsInPlace := func() {
 		// Get the sender of this transaction
-		txFromLower, txFromFound, err := RState.GetSenderOfTxHash(txHashLower)
+		txFromLower, txFromFound, err := r.state.GetSenderOfTxHash(ctx, txHashLower)
 		if err != nil {
 			metrics.IncRedisErr()
 			r.logger.Error("[post_getTransactionReceipt] Redis:GetSenderOfTxHash failed", "error", err)
@@ -77,7 +80,7 @@ func (r *RpcRequest) check_post_getTransactionReceipt(jsonResp *types.JsonRpcRes
 		}
 
 		// Check if nonceFix is already in place for this user
-		_, nonceFixAlreadyExists, err := RState.GetNonceFixForAccount(txFromLower)
+		_, nonceFixAlreadyExists, err := r.state.GetNonceFixForAccount(ctx, txFromLower)
 		if err != nil {
 			metrics.IncRedisErr()
 			r.logger.Error("[post_getTransactionReceipt] Redis:GetNonceFixForAccount failed", "error", err)
@@ -89,7 +92,7 @@ func (r *RpcRequest) check_post_getTransactionReceipt(jsonResp *types.JsonRpcRes
 		}
 
 		// Setup a new nonce-fix for this user
-		err = RState.SetNonceFixForAccount(txFromLower, 0)
+		err = r.state.SetNonceFixForAccount(ctx, txFromLower, 0)
 		if err != nil {
 			metrics.IncRedisErr()
 			r.logger.Error("[post_getTransactionReceipt] Redis error", "error", err)
@@ -121,18 +124,18 @@ func (r *RpcRequest) check_post_getTransactionReceipt(jsonResp *types.JsonRpcRes
 	return false
 }
 
-func (r *RpcRequest) intercept_mm_eth_getTransactionCount() (requestFinished bool) {
+func (r *RpcRequest) intercept_mm_eth_getTransactionCount(ctx context.Context) (requestFinished bool) {
 	if len(r.jsonReq.Params) < 1 {
 		return false
 	}
 
 	addr := strings.ToLower(r.jsonReq.Params[0].(string))
 
-	// Check if nonceFix is in place for this user
-	numTimesSent, nonceFixInPlace, err := RState.GetNonceFixForAccount(addr)
+	// Count the intercept if a nonceFix is in place for this user
+	numTimesSent, nonceFixInPlace, err := r.state.IncNonceFixForAccount(ctx, addr)
 	if err != nil {
 		metrics.IncRedisErr()
-		r.logger.Error("[eth_getTransactionCount] Redis:GetAccountWithNonceFix error:", "error", err)
+		r.logger.Error("[eth_getTransactionCount] Redis:IncNonceFixForAccount error:", "error", err)
 		return false
 	}
 
@@ -141,18 +144,10 @@ func (r *RpcRequest) intercept_mm_eth_getTransactionCount() (requestFinished boo
 	}
 
 	// Intercept max 4 times (after which Metamask marks it as dropped)
-	numTimesSent += 1
 	if numTimesSent > 4 {
 		return false
 	}
 
-	err = RState.SetNonceFixForAccount(addr, numTimesSent)
-	if err != nil {
-		metrics.IncRedisErr()
-		r.logger.Error("[eth_getTransactionCount] Redis:SetAccountWithNonceFix error", "error", err)
-		return false
-	}
-
 	r.logger.Info("[eth_getTransactionCount] intercept", "numTimesSent", numTimesSent)
 
 	// Return invalid nonce
@@ -189,7 +184,7 @@ func (r *RpcRequest) intercept_eth_call_to_FlashRPC_Contract() (requestFinished
 	return true
 }
 
-func (r *RpcRequest) intercept_signed_eth_getTransactionCount() (requestFinished bool) {
+func (r *RpcRequest) intercept_signed_eth_getTransactionCount(ctx context.Context) (requestFinished bool) {
 	if r.flashbotsSigningAddress == "" {
 		r.logger.Info("[eth_getTransactionCount] No signature found")
 		return false
@@ -219,7 +214,7 @@ func (r *RpcRequest) intercept_signed_eth_getTransactionCount() (requestFinished
 
 	// since it's possible that the user sent another tx via another provider, we need to check the nonce from
 	// both the backend and our cache, and return the greater of the two
-	cachedNonce, found, err := RState.GetSenderMaxNonce(addr)
+	cachedNonce, found, err := r.state.GetSenderMaxNonce(ctx, addr)
 	if err != nil {
 		metrics.IncRedisErr()
 		r.logger.Error("[eth_getTransactionCount] Redis:GetSenderMaxNonce error", "error", err)
@@ -229,7 +224,7 @@ func (r *RpcRequest) intercept_signed_eth_getTransactionCount() (requestFinished
 		r.logger.Info("[eth_getTransactionCount] No nonce found")
 		return false
 	}
-	if !r.proxyRequestRead() {
+	if !r.proxyRequestRead(ctx) {
 		r.logger.Info("[ProcessRequest] Proxy to node failed", "method", r.jsonReq.Method)
 		r.writeRpcError("internal server error", types.JsonRpcInternalError)
 		return true
@@ -259,7 +254,7 @@ func (r *RpcRequest) intercept_signed_eth_getTransactionCount() (requestFinished
 		txCount = backendTxCount
 		// since the cached value is invalid lets remove it from redis
 		r.logger.Info("[eth_getTransactionCount] intercept invalidated nonce", "addr", addr)
-		if err := RState.DelSenderMaxNonce(addr); err != nil {
+		if err := r.state.DelSenderMaxNonce(ctx, addr); err != nil {
 			metrics.IncRedisErr()
 			// log the error but continue
 			r.logger.Error("[eth_getTransactionCount] Redis:DelSenderMaxNonce error", "error", err, "addr", addr)
@@ -8,6 +8,7 @@ import (
 	"crypto/ecdsa"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"math/big"
 	"reflect"
@@ -25,6 +26,8 @@ import (
 
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/metachris/flashbotsrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 
 	"github.com/flashbots/rpc-endpoint/types"
 )
@@ -32,7 +35,8 @@ import (
 type RpcRequest struct {
 	logger                     log.Logger
 	client                     RPCProxyClient
-	defaultEthClient           *ethclient.Client
+	mempoolRPC                 string // of the chain, used if the user sets no mempoolRPC
+	mempoolClients             *EthClientPool
 	jsonReq                    *types.JsonRpcRequest
 	jsonRes                    *types.JsonRpcResponse
 	rawTxHex                   string
@@ -45,11 +49,22 @@ type RpcRequest struct {
 	isWhitehatBundleCollection bool
 	whitehatBundleId           string
 	ethSendRawTxEntry          *database.EthSendRawTxEntry
+	decision                   *DecisionRecord // checks run on an eth_sendRawTransaction, nil for other methods
 	urlParams                  URLParameters
 	chainID                    []byte
+	ethChainID                 []byte
+	chainIDInt                 *big.Int
+	txApiHost                  string
+	rejectUnprotectedTx        bool
 	rpcCache                   *application.RpcCache
 	flashbotsSigningAddress    string
 	maxBlockNumberOverride     uint64
+	state                      StateStore
+	upstreamGuard              *UpstreamGuard
+	mempoolBroadcaster         *MempoolBroadcaster
+	webhooks                   *WebhookNotifier
+	chainName                  string
+	timeouts                   RequestTimeouts
 }
 
 func NewRpcRequest(
@@ -57,30 +72,44 @@ func NewRpcRequest(
 	client RPCProxyClient,
 	jsonReq *types.JsonRpcRequest,
 	relaySigningKey *ecdsa.PrivateKey,
-	relayUrl, origin, referer string,
+	origin, referer string,
 	isWhitehatBundleCollection bool,
 	whitehatBundleId string,
 	ethSendRawTxEntry *database.EthSendRawTxEntry,
+	decision *DecisionRecord,
 	urlParams URLParameters,
-	chainID []byte,
-	rpcCache *application.RpcCache,
-	defaultEthClient *ethclient.Client,
+	chain *Chain,
+	upstreamGuard *UpstreamGuard,
+	mempoolBroadcaster *MempoolBroadcaster,
+	timeouts RequestTimeouts,
 ) *RpcRequest {
 	return &RpcRequest{
 		logger:                     logger.With("method", jsonReq.Method),
 		client:                     client,
 		jsonReq:                    jsonReq,
 		relaySigningKey:            relaySigningKey,
-		relayUrl:                   relayUrl,
+		relayUrl:                   chain.relayUrl,
 		origin:                     origin,
 		referer:                    referer,
 		isWhitehatBundleCollection: isWhitehatBundleCollection,
 		whitehatBundleId:           whitehatBundleId,
 		ethSendRawTxEntry:          ethSendRawTxEntry,
+		decision:                   decision,
 		urlParams:                  urlParams,
-		chainID:                    chainID,
-		rpcCache:                   rpcCache,
-		defaultEthClient:           defaultEthClient,
+		chainID:                    chain.chainID,
+		ethChainID:                 chain.ethChainID,
+		chainIDInt:                 chain.chainIDInt,
+		txApiHost:                  chain.txApiHost,
+		rejectUnprotectedTx:        chain.rejectUnprotected,
+		rpcCache:                   chain.rpcCache,
+		mempoolRPC:                 chain.mempoolRPC,
+		mempoolClients:             chain.mempoolClients,
+		state:                      chain.state,
+		upstreamGuard:              upstreamGuard,
+		mempoolBroadcaster:         mempoolBroadcaster,
+		webhooks:                   chain.webhooks,
+		chainName:                  chain.name,
+		timeouts:                   timeouts,
 	}
 }
 
@@ -105,27 +134,37 @@ func (r *RpcRequest) logRequest() {
 	}
 }
 
-func (r *RpcRequest) ProcessRequest() *types.JsonRpcResponse {
+func (r *RpcRequest) ProcessRequest(ctx context.Context) *types.JsonRpcResponse {
 	r.logRequest()
+	ctx, span := startSpan(ctx, "RpcRequest.ProcessRequest", attribute.String("rpc.method", r.jsonReq.Method), attribute.String("originId", r.urlParams.originId))
+	defer span.End()
+	// The span is named by the branch handling the request
+	branch := func(name string) { span.SetName("RpcRequest.ProcessRequest." + name) }
 
 	switch {
 	case r.jsonReq.Method == "eth_sendRawTransaction":
+		branch("sendRawTransaction")
 		r.ethSendRawTxEntry.WhiteHatBundleId = r.whitehatBundleId
-		r.handle_sendRawTransaction()
+		r.handle_sendRawTransaction(ctx)
 	case r.jsonReq.Method == "eth_sendPrivateTransaction":
+		branch("sendPrivateTransaction")
 		r.ethSendRawTxEntry.WhiteHatBundleId = r.whitehatBundleId
-		r.handle_sendPrivateTransaction()
-	case r.jsonReq.Method == "eth_getTransactionCount" && r.intercept_signed_eth_getTransactionCount():
-	case r.jsonReq.Method == "eth_getTransactionCount" && r.intercept_mm_eth_getTransactionCount(): // intercept if MM needs to show an error to user
+		r.handle_sendPrivateTransaction(ctx)
+	case r.jsonReq.Method == "eth_getTransactionCount" && r.intercept_signed_eth_getTransactionCount(ctx):
+		branch("signedGetTransactionCount")
+	case r.jsonReq.Method == "eth_getTransactionCount" && r.intercept_mm_eth_getTransactionCount(ctx): // intercept if MM needs to show an error to user
+		branch("nonceFixGetTransactionCount")
 	case r.jsonReq.Method == "eth_call" && r.intercept_eth_call_to_FlashRPC_Contract(): // intercept if Flashbots isRPC contract
+		branch("flashRPCContractCall")
 	case r.jsonReq.Method == "web3_clientVersion":
+		branch("clientVersion")
 		res, ok := r.rpcCache.Get("web3_clientVersion")
 		if ok {
 			r.jsonRes = res
 			return r.jsonRes
 		}
 
-		readJsonRpcSuccess := r.proxyRequestRead()
+		readJsonRpcSuccess := r.proxyRequestRead(ctx)
 		if !readJsonRpcSuccess {
 			r.logger.Info("[ProcessRequest] Proxy to node failed", "method", r.jsonReq.Method)
 			r.writeRpcError("internal server error", types.JsonRpcInternalError)
@@ -133,15 +172,21 @@ func (r *RpcRequest) ProcessRequest() *types.JsonRpcResponse {
 		}
 		r.rpcCache.Set("web3_clientVersion", r.jsonRes)
 	case r.jsonReq.Method == "net_version":
+		branch("netVersion")
 		r.writeRpcResult(json.RawMessage(r.chainID))
+	case r.jsonReq.Method == "eth_chainId":
+		branch("chainId")
+		r.writeRpcResult(json.RawMessage(r.ethChainID))
 	case r.isWhitehatBundleCollection && r.jsonReq.Method == "eth_getBalance":
+		branch("whitehatGetBalance")
 		r.writeRpcResult("0x56bc75e2d63100000") // 100 ETH, same as the eth_call SC call above returns
 	default:
+		branch("proxy")
 		if r.isWhitehatBundleCollection && r.jsonReq.Method == "eth_call" {
 			r.WhitehatBalanceCheckerRewrite()
 		}
 		// Proxy the request to a node
-		readJsonRpcSuccess := r.proxyRequestRead()
+		readJsonRpcSuccess := r.proxyRequestRead(ctx)
 		if !readJsonRpcSuccess {
 			r.logger.Info("[ProcessRequest] Proxy to node failed", "method", r.jsonReq.Method)
 			r.writeRpcError("internal server error", types.JsonRpcInternalError)
@@ -150,7 +195,7 @@ func (r *RpcRequest) ProcessRequest() *types.JsonRpcResponse {
 
 		// After proxy, perhaps check backend [MM fix #3 step 2]
 		if r.jsonReq.Method == "eth_getTransactionReceipt" {
-			requestCompleted := r.check_post_getTransactionReceipt(r.jsonRes)
+			requestCompleted := r.check_post_getTransactionReceipt(ctx, r.jsonRes)
 			if requestCompleted {
 				return r.jsonRes
 			}
@@ -160,7 +205,14 @@ func (r *RpcRequest) ProcessRequest() *types.JsonRpcResponse {
 }
 
 // Proxies the incoming request to the target URL, and tries to parse JSON-RPC response (and check for specific)
-func (r *RpcRequest) proxyRequestRead() (readJsonRpsResponseSuccess bool) {
+func (r *RpcRequest) proxyRequestRead(ctx context.Context) (readJsonRpsResponseSuccess bool) {
+	ctx, span := startSpan(ctx, "RpcRequest.proxyRequestRead")
+	defer func() {
+		if !readJsonRpsResponseSuccess {
+			span.SetStatus(codes.Error, "proxy request failed")
+		}
+		span.End()
+	}()
 	timeProxyStart := Now() // for measuring execution time
 	body, err := json.Marshal(r.jsonReq)
 	if err != nil {
@@ -170,7 +222,7 @@ func (r *RpcRequest) proxyRequestRead() (readJsonRpsResponseSuccess bool) {
 	}
 
 	// Proxy request
-	proxyResp, err := r.client.ProxyRequest(body)
+	proxyResp, err := r.client.ProxyRequest(ctx, body)
 	if err != nil {
 		r.logger.Error("[proxyRequestRead] Failed to make proxy request", "error", err, "response", proxyResp)
 		metrics.IncRPCNodeProxyServerErr()
@@ -202,33 +254,41 @@ func (r *RpcRequest) proxyRequestRead() (readJsonRpsResponseSuccess bool) {
 }
 
 // Check whether to block resending this tx. Send only if (a) not sent before, (b) sent and status=failed, (c) sent, status=unknown and sent at least 5 min ago
-func (r *RpcRequest) blockResendingTxToRelay(txHash string) bool {
-	timeSent, txWasSentToRelay, err := RState.GetTxSentToRelay(txHash)
+func (r *RpcRequest) blockResendingTxToRelay(ctx context.Context, txHash string) bool {
+	timeSent, txWasSentToRelay, err := r.state.GetTxSentToRelay(ctx, txHash)
 	if err != nil {
 		metrics.IncRedisErr()
 		r.logger.Error("[blockResendingTxToRelay] Redis:GetTxSentToRelay error", "error", err)
+		r.decide("resend", DecisionError, err.Error())
 		return false // don't block on redis error
 	}
 
 	if !txWasSentToRelay {
+		r.decide("resend", DecisionPass, "not sent before")
 		return false // don't block if not sent before
 	}
 
 	// was sent before. check status and time
-	txStatusApiResponse, err := GetTxStatus(txHash)
+	txStatusCtx, cancel := withTimeout(ctx, r.timeouts.TxStatus)
+	defer cancel()
+	txStatusApiResponse, err := GetTxStatus(txStatusCtx, r.txApiHost, txHash)
 	if err != nil {
 		r.logger.Error("[blockResendingTxToRelay] GetTxStatus error", "error", err)
+		r.decide("resend", DecisionError, err.Error())
 		return false // don't block on redis error
 	}
 
 	// Allow sending to relay if tx has failed, or if it's still unknown after a while
 	txStatus := txStatusApiResponse.Status
 	if txStatus == types.TxStatusFailed {
+		r.decide("resend", DecisionPass, "sent before and failed")
 		return false // don't block if tx failed
 	} else if txStatus == types.TxStatusUnknown && time.Since(timeSent).Minutes() >= 5 {
+		r.decide("resend", DecisionPass, "sent before and unknown for 5 minutes")
 		return false // don't block if unknown and sent at least 5 min ago
 	} else {
 		// block tx if pending or already included
+		r.decide("resend", DecisionStop, fmt.Sprintf("sent before and %s", txStatus))
 		return true
 	}
 }
@@ -250,10 +310,10 @@ func replaceZeroRefund(refunds []types.RefundConfig, sender common.Address) []ty
 }
 
 // Send tx to relay and finish request (write response)
-func (r *RpcRequest) sendTxToRelay() {
+func (r *RpcRequest) sendTxToRelay(ctx context.Context) {
 	txHash := strings.ToLower(r.tx.Hash().Hex())
 	// Check if tx was already forwarded and should be blocked now
-	IsBlocked := r.blockResendingTxToRelay(txHash)
+	IsBlocked := r.blockResendingTxToRelay(ctx, txHash)
 	if IsBlocked {
 		r.ethSendRawTxEntry.IsBlocked = IsBlocked
 		r.logger.Info("[sendTxToRelay] Blocked", "tx", txHash)
@@ -264,55 +324,70 @@ func (r *RpcRequest) sendTxToRelay() {
 	r.logger.Info("[sendTxToRelay] sending transaction to relay", "tx", txHash, "fromAddress", r.txFrom, "toAddress", r.tx.To())
 	r.ethSendRawTxEntry.WasSentToRelay = true
 
+	// Once the tx is marked as sent to the relay, resending it is blocked, so it must not be cancelled with the request
+	ctx, cancelSend := withTimeout(context.WithoutCancel(ctx), r.timeouts.Request)
+	defer cancelSend()
+
 	// mark tx as sent to relay
-	err := RState.SetTxSentToRelay(txHash)
+	err := r.state.SetTxSentToRelay(ctx, txHash)
 	if err != nil {
 		metrics.IncRedisErr()
 		r.logger.Error("[sendTxToRelay] Redis:SetTxSentToRelay failed", "error", err)
 	}
 
-	minNonce, maxNonce, err := r.GetAddressNonceRange(r.txFrom)
+	minNonce, maxNonce, err := r.GetAddressNonceRange(ctx, r.txFrom)
 	if err != nil {
 		r.logger.Error("[sendTxToRelay] GetAddressNonceRange error", "error", err)
+		r.decide("nonce_range", DecisionError, err.Error())
 	} else {
+		nonceRange := fmt.Sprintf("nonce %d, valid %d to %d", r.tx.Nonce(), minNonce, maxNonce+1)
 		if r.tx.Nonce() < minNonce || r.tx.Nonce() > maxNonce+1 {
 			r.logger.Info("[sendTxToRelay] invalid nonce", "tx", txHash, "txFrom", r.txFrom, "minNonce", minNonce, "maxNonce", maxNonce+1, "txNonce", r.tx.Nonce())
+			r.decide("nonce_range", DecisionStop, nonceRange)
 			r.writeRpcError("invalid nonce", types.JsonRpcInternalError)
 			return
 		}
+		r.decide("nonce_range", DecisionPass, nonceRange)
 	}
 
-	go RState.SetSenderMaxNonce(r.txFrom, r.tx.Nonce(), r.urlParams.blockRange)
+	if err = r.state.SetSenderMaxNonce(ctx, r.txFrom, r.tx.Nonce(), r.urlParams.blockRange); err != nil {
+		metrics.IncRedisErr()
+		r.logger.Error("[sendTxToRelay] Redis:SetSenderMaxNonce failed", "error", err)
+	}
 
 	// only allow large non-blob transactions to certain addresses - default max tx size is 128KB
 	// https://github.com/ethereum/go-ethereum/blob/master/core/tx_pool.go#L53
 	if r.tx.Type() != ethtypes.BlobTxType && r.tx.Size() > 131072 {
 		if r.tx.To() == nil {
 			r.logger.Error("[sendTxToRelay] large tx not allowed to target null", "tx", txHash)
+			r.decide("large_tx", DecisionStop, "contract creation not allowed")
 			r.writeRpcError("invalid target for large tx", types.JsonRpcInternalError)
 			return
 		} else if _, found := allowedLargeTxTargets[strings.ToLower(r.tx.To().Hex())]; !found {
 			r.logger.Error("[sendTxToRelay] large tx not allowed to target", "tx", txHash, "target", r.tx.To())
+			r.decide("large_tx", DecisionStop, "target not allowed: "+r.tx.To().Hex())
 			r.writeRpcError("invalid target for large tx", types.JsonRpcInternalError)
 			return
 		}
 		r.logger.Info("sendTxToRelay] allowed large tx", "tx", txHash, "target", r.tx.To())
+		r.decide("large_tx", DecisionPass, "allowed target "+r.tx.To().Hex())
 	}
 
 	// remember this tx based on from+nonce (for cancel-tx)
-	err = RState.SetTxHashForSenderAndNonce(r.txFrom, r.tx.Nonce(), txHash)
+	err = r.state.SetTxHashForSenderAndNonce(ctx, r.txFrom, r.tx.Nonce(), txHash)
 	if err != nil {
 		metrics.IncRedisErr()
 		r.logger.Error("[sendTxToRelay] Redis:SetTxHashForSenderAndNonce failed", "error", err)
 	}
 
-	// err = RState.SetLastPrivTxHashOfAccount(r.txFrom, txHash)
+	// err = r.state.SetLastPrivTxHashOfAccount(r.txFrom, txHash)
 	// if err != nil {
 	// 	r.Error("[sendTxToRelay] redis:SetLastTxHashOfAccount failed: %v", err)
 	// }
 
 	if DebugDontSendTx {
 		r.logger.Info("[sendTxToRelay] Faked sending tx to relay, did nothing", "tx", txHash)
+		r.decide("relay", DecisionStop, "not sent, DEBUG_DONT_SEND_RAWTX is set")
 		r.writeRpcResult(txHash)
 		return
 	}
@@ -350,7 +425,9 @@ func (r *RpcRequest) sendTxToRelay() {
 	if r.maxBlockNumberOverride > 0 {
 		sendPrivateTxArgs.MaxBlockNumber = r.maxBlockNumberOverride
 	} else if r.urlParams.blockRange > 0 {
-		bn, err := r.defaultEthClient.BlockNumber(context.Background())
+		mempoolCtx, cancel := withTimeout(ctx, r.timeouts.Mempool)
+		bn, err := r.blockNumber(mempoolCtx)
+		cancel()
 		if err != nil {
 			r.logger.Error("[sendTxToRelay] BlockNumber failed", "error", err)
 			r.writeRpcError(err.Error(), types.JsonRpcInternalError)
@@ -361,13 +438,11 @@ func (r *RpcRequest) sendTxToRelay() {
 		sendPrivateTxArgs.MaxBlockNumber = maxBlockNumber
 	}
 
-	fbRpc := flashbotsrpc.New(r.relayUrl, func(rpc *flashbotsrpc.FlashbotsRPC) {
-		if r.urlParams.originId != "" {
-			rpc.Headers["X-Flashbots-Origin"] = r.urlParams.originId
-		}
-	})
+	relay := newRelayClient(r.relayUrl, r.relaySigningKey, r.urlParams.originId)
 	r.logger.Info("[sendTxToRelay] sending transaction", "builders count", len(sendPrivateTxArgs.Preferences.Privacy.Builders), "is_fast", r.urlParams.fast)
-	_, err = fbRpc.CallWithFlashbotsSignature("eth_sendPrivateTransaction", r.relaySigningKey, sendPrivateTxArgs)
+	relayCtx, cancel := withTimeout(ctx, r.timeouts.Relay)
+	_, err = relay.call(relayCtx, "eth_sendPrivateTransaction", sendPrivateTxArgs)
+	cancel()
 	if err != nil {
 		if errors.Is(err, flashbotsrpc.ErrRelayErrorResponse) {
 			r.logger.Info("[sendTxToRelay] Relay error response", "error", err, "rawTx", r.rawTxHex)
@@ -376,6 +451,21 @@ func (r *RpcRequest) sendTxToRelay() {
 			r.logger.Error("[sendTxToRelay] Relay call failed", "error", err, "rawTx", r.rawTxHex)
 			metrics.IncRelayServerErr()
 		}
+		r.decide("relay", DecisionStop, err.Error())
+
+		// Users who set useMempool get their tx propagated even if the relay rejects it
+		if r.broadcastsToMempool() {
+			mempoolCtx, cancel := withTimeout(ctx, r.timeouts.Mempool)
+			err = r.sendTxToMempool(mempoolCtx)
+			cancel()
+			if err == nil {
+				r.decide("mempool", DecisionStop, "sent to the mempool after the relay error")
+				r.writeRpcResult(txHash)
+				return
+			}
+			r.logger.Error("[sendTxToRelay] Sending to mempool after relay error failed", "error", err)
+			r.decide("mempool", DecisionStop, err.Error())
+		}
 
 		// todo: we need to change the way we call bundle-relay-api as it's not json-rpc compatible so we don't get proper
 		// error code/text
@@ -383,18 +473,61 @@ func (r *RpcRequest) sendTxToRelay() {
 		return
 	}
 
+	r.decide("relay", DecisionPass, "sent")
+	r.notifyWebhook(ctx, WebhookEvent{Event: WebhookEventRelayed, TxHash: txHash})
+	if r.broadcastsToMempool() {
+		r.scheduleMempoolBroadcast(ctx)
+	}
 	r.writeRpcResult(txHash)
 	r.logger.Info("[sendTxToRelay] Sent", "tx", txHash)
 }
 
+// broadcastsToMempool returns whether the endpoint sends the tx to the mempool itself, besides the relay
+func (r *RpcRequest) broadcastsToMempool() bool {
+	return r.mempoolBroadcaster != nil && r.urlParams.pref.Privacy.UseMempool
+}
+
+// scheduleMempoolBroadcast schedules the broadcast of the tx, which only needs the tx and its entry
+func (r *RpcRequest) scheduleMempoolBroadcast(ctx context.Context) {
+	broadcast := mempoolBroadcast{
+		Chain:      r.chainName,
+		RawTx:      r.rawTxHex,
+		TxFrom:     r.txFrom,
+		MempoolRPC: r.urlParams.pref.Privacy.MempoolRPC,
+	}
+	if r.ethSendRawTxEntry != nil {
+		broadcast.RawTxEntryId = r.ethSendRawTxEntry.Id
+	}
+	if err := r.mempoolBroadcaster.Schedule(ctx, broadcast); err != nil {
+		r.logger.Error("[sendTxToRelay] Scheduling mempool broadcast failed", "error", err)
+		metrics.IncMempoolBroadcastSkipped("error")
+		r.decide("mempool", DecisionStop, err.Error())
+		return
+	}
+	r.decide("mempool", DecisionPass, "scheduled")
+}
+
+// sendTxToMempool sends the tx to the mempool RPC, a tx which is already known counts as sent
+func (r *RpcRequest) sendTxToMempool(ctx context.Context) error {
+	client, reportResult, err := r.mempoolClient(ctx)
+	if err != nil {
+		return err
+	}
+	if err = sendTxToMempool(ctx, client, reportResult, r.tx); err != nil {
+		return err
+	}
+	r.ethSendRawTxEntry.WasSentToMempool = true
+	return nil
+}
+
 // Sends cancel-tx to relay as cancelPrivateTransaction, if initial tx was sent there too.
-func (r *RpcRequest) handleCancelTx() (requestCompleted bool) {
+func (r *RpcRequest) handleCancelTx(ctx context.Context) (requestCompleted bool) {
 	cancelTxHash := strings.ToLower(r.tx.Hash().Hex())
 	txFromLower := strings.ToLower(r.txFrom)
 	r.logger.Info("[cancel-tx] cancelling transaction", "cancelTxHash", cancelTxHash, "txFromLower", txFromLower, "txNonce", r.tx.Nonce())
 
 	// Get initial txHash by sender+nonce
-	initialTxHash, txHashFound, err := RState.GetTxHashForSenderAndNonce(txFromLower, r.tx.Nonce())
+	initialTxHash, txHashFound, err := r.state.GetTxHashForSenderAndNonce(ctx, txFromLower, r.tx.Nonce())
 	if err != nil {
 		metrics.IncRedisErr()
 		r.logger.Error("[cancelTx] Redis:GetTxHashForSenderAndNonce failed", "error", err)
@@ -407,7 +540,7 @@ func (r *RpcRequest) handleCancelTx() (requestCompleted bool) {
 	}
 
 	// Check if initial tx was sent to relay
-	_, txWasSentToRelay, err := RState.GetTxSentToRelay(initialTxHash)
+	_, txWasSentToRelay, err := r.state.GetTxSentToRelay(ctx, initialTxHash)
 	if err != nil {
 		metrics.IncRedisErr()
 		r.logger.Error("[cancelTx] Redis:GetTxSentToRelay failed", "error", err)
@@ -420,7 +553,7 @@ func (r *RpcRequest) handleCancelTx() (requestCompleted bool) {
 	}
 
 	// Should send cancel-tx to relay. Check if cancel-tx was already sent before
-	_, cancelTxAlreadySentToRelay, err := RState.GetTxSentToRelay(cancelTxHash)
+	_, cancelTxAlreadySentToRelay, err := r.state.GetTxSentToRelay(ctx, cancelTxHash)
 	if err != nil {
 		metrics.IncRedisErr()
 		r.logger.Error("[cancelTx] Redis:GetTxSentToRelay error", "error", err)
@@ -428,17 +561,29 @@ func (r *RpcRequest) handleCancelTx() (requestCompleted bool) {
 		return true
 	}
 
+	r.decide("cancel_tx", DecisionStop, "cancels "+initialTxHash+" at the relay")
 	if cancelTxAlreadySentToRelay { // already sent
 		r.writeRpcResult(cancelTxHash)
 		return true
 	}
 
-	err = RState.SetTxSentToRelay(cancelTxHash)
+	// Once the cancel-tx is marked as sent to the relay, resending it is skipped, so it must not be cancelled with the request
+	ctx, cancelSend := withTimeout(context.WithoutCancel(ctx), r.timeouts.Request)
+	defer cancelSend()
+
+	err = r.state.SetTxSentToRelay(ctx, cancelTxHash)
 	if err != nil {
 		metrics.IncRedisErr()
 		r.logger.Error("[cancelTx] Redis:SetTxSentToRelay failed", "error", err)
 	}
 
+	// Keeps a scheduled mempool broadcast of the initial tx from sending it
+	err = r.state.SetTxCancelled(ctx, initialTxHash)
+	if err != nil {
+		metrics.IncRedisErr()
+		r.logger.Error("[cancelTx] Redis:SetTxCancelled failed", "error", err)
+	}
+
 	r.logger.Info("[cancel-tx] sending to relay", "initialTxHash", initialTxHash, "txFromLower", txFromLower, "txNonce", r.tx.Nonce())
 
 	if DebugDontSendTx {
@@ -449,17 +594,17 @@ func (r *RpcRequest) handleCancelTx() (requestCompleted bool) {
 
 	if r.urlParams.pref.Privacy.UseMempool {
 		r.logger.Info("[cancelTx] cancel-tx sending to mempool", "tx", initialTxHash)
-		ethCl := r.defaultEthClient
-		if r.urlParams.pref.Privacy.MempoolRPC != "" {
-			ethCl, err = ethclient.Dial(r.urlParams.pref.Privacy.MempoolRPC)
-			if err != nil {
-				r.logger.Error("[cancelTx] Dial failed", "error", err, "rpc", r.urlParams.pref.Privacy.MempoolRPC)
-				r.writeRpcError("invalid mempool rpc", types.JsonRpcInvalidParams)
-				return true
-			}
+		mempoolCtx, cancel := withTimeout(ctx, r.timeouts.Mempool)
+		defer cancel()
+		ethCl, reportResult, err := r.mempoolClient(mempoolCtx)
+		if err != nil {
+			r.logger.Error("[cancelTx] Dial failed", "error", err, "rpc", r.urlParams.pref.Privacy.MempoolRPC)
+			r.writeRpcError("invalid mempool rpc", types.JsonRpcInvalidParams)
+			return true
 		}
 
-		err = ethCl.SendTransaction(context.Background(), r.tx)
+		err = ethCl.SendTransaction(mempoolCtx, r.tx)
+		reportResult(err)
 		if err != nil {
 			metrics.IncEthNodeClusterErr()
 			r.logger.Error("[cancelTx] SendTransaction failed", "error", err)
@@ -468,10 +613,9 @@ func (r *RpcRequest) handleCancelTx() (requestCompleted bool) {
 		}
 	}
 
-	cancelPrivTxArgs := flashbotsrpc.FlashbotsCancelPrivateTransactionRequest{TxHash: initialTxHash}
-
-	fbRpc := flashbotsrpc.New(r.relayUrl)
-	_, err = fbRpc.FlashbotsCancelPrivateTransaction(r.relaySigningKey, cancelPrivTxArgs)
+	relayCtx, cancel := withTimeout(ctx, r.timeouts.Relay)
+	defer cancel()
+	err = newRelayClient(r.relayUrl, r.relaySigningKey, "").cancelPrivateTransaction(relayCtx, initialTxHash)
 	if err != nil {
 		if errors.Is(err, flashbotsrpc.ErrRelayErrorResponse) {
 			// errors could be: 'tx not found', 'tx was already cancelled', 'tx has already expired'
@@ -486,11 +630,12 @@ func (r *RpcRequest) handleCancelTx() (requestCompleted bool) {
 		return true
 	}
 
+	r.notifyWebhook(ctx, WebhookEvent{Event: WebhookEventCancelled, TxHash: initialTxHash, CancelTxHash: cancelTxHash})
 	r.writeRpcResult(cancelTxHash)
 	return true
 }
 
-func (r *RpcRequest) GetAddressNonceRange(address string) (minNonce, maxNonce uint64, err error) {
+func (r *RpcRequest) GetAddressNonceRange(ctx context.Context, address string) (minNonce, maxNonce uint64, err error) {
 	// Get minimum nonce by asking the eth node for the current transaction count
 	_req := types.NewJsonRpcRequest(1, "eth_getTransactionCount", []interface{}{r.txFrom, "latest"})
 	jsonData, err := json.Marshal(_req)
@@ -498,7 +643,7 @@ func (r *RpcRequest) GetAddressNonceRange(address string) (minNonce, maxNonce ui
 		r.logger.Error("[GetAddressNonceRange] eth_getTransactionCount marshal failed", "error", err)
 		return 0, 0, err
 	}
-	httpRes, err := r.client.ProxyRequest(jsonData)
+	httpRes, err := r.client.ProxyRequest(ctx, jsonData)
 	if err != nil {
 		r.logger.Error("[GetAddressNonceRange] eth_getTransactionCount proxy request failed", "error", err)
 		return 0, 0, err
@@ -528,7 +673,7 @@ func (r *RpcRequest) GetAddressNonceRange(address string) (minNonce, maxNonce ui
 	minNonce = _userNonceBigInt.Uint64()
 
 	// Get maximum nonce by looking at redis, which has current pending transactions
-	_redisMaxNonce, _, _ := RState.GetSenderMaxNonce(r.txFrom)
+	_redisMaxNonce, _, _ := r.state.GetSenderMaxNonce(ctx, r.txFrom)
 	maxNonce = Max(minNonce, _redisMaxNonce)
 	return minNonce, maxNonce, nil
 }
@@ -557,6 +702,38 @@ func (r *RpcRequest) WhitehatBalanceCheckerRewrite() {
 	}
 }
 
+// mempoolClient returns the mempool client of the request and a func to report the result of using it
+func (r *RpcRequest) mempoolClient(ctx context.Context) (client *ethclient.Client, reportResult func(error), err error) {
+	return mempoolClient(ctx, r.mempoolClients, r.mempoolRPC, r.upstreamGuard, r.urlParams.pref.Privacy.MempoolRPC)
+}
+
+// blockNumber returns the latest block number of the mempool RPC of the chain
+func (r *RpcRequest) blockNumber(ctx context.Context) (uint64, error) {
+	client, err := r.mempoolClients.Get(ctx, r.mempoolRPC)
+	if err != nil {
+		return 0, err
+	}
+	bn, err := client.BlockNumber(ctx)
+	r.mempoolClients.ReportResult(r.mempoolRPC, err)
+	return bn, err
+}
+
+// notifyWebhook queues the event of the tx for the webhook of its origin. The tx was sent already, so the event is
+// queued even if the client is gone.
+func (r *RpcRequest) notifyWebhook(ctx context.Context, event WebhookEvent) {
+	event.OriginId = r.urlParams.originId
+	event.Chain = r.chainName
+	if r.ethSendRawTxEntry != nil {
+		event.RawTxEntryId = r.ethSendRawTxEntry.Id
+	}
+	r.webhooks.Notify(context.WithoutCancel(ctx), event)
+}
+
+// decide adds a check to the decision record of the tx, if any
+func (r *RpcRequest) decide(check, result, detail string) {
+	r.decision.add(check, result, detail)
+}
+
 func (r *RpcRequest) writeRpcError(msg string, errCode int) {
 	if r.jsonReq.Method == "eth_sendRawTransaction" {
 		r.ethSendRawTxEntry.Error = msg
@@ -2,14 +2,18 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/flashbots/rpc-endpoint/database"
@@ -18,16 +22,17 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func setupRedis() {
+func setupRedis() *RedisState {
 	redisServer, err := miniredis.Run()
 	if err != nil {
 		panic(err)
 	}
 
-	RState, err = NewRedisState(redisServer.Addr())
+	state, err := NewRedisState(redisServer.Addr(), DefaultStateOptions)
 	if err != nil {
 		panic(err)
 	}
+	return state
 }
 
 func setupMockTxApi() {
@@ -43,47 +48,48 @@ func setServerTimeNowOffset(td time.Duration) {
 }
 
 func TestRequestshouldSendTxToRelay(t *testing.T) {
-	setupRedis()
+	ctx := context.Background()
+	state := setupRedis()
 	setupMockTxApi()
 
-	request := RpcRequest{}
+	request := RpcRequest{state: state, txApiHost: ProtectTxApiHost}
 	txHash := "0x0Foo"
 
 	// SEND when not seen before
-	shouldSend := !request.blockResendingTxToRelay(txHash)
+	shouldSend := !request.blockResendingTxToRelay(ctx, txHash)
 	require.True(t, shouldSend)
 
 	// Fake a previous send
-	err := RState.SetTxSentToRelay(txHash)
+	err := state.SetTxSentToRelay(ctx, txHash)
 	require.Nil(t, err, err)
 
 	// Ensure tx status is UNKNOWN
-	txStatusApiResponse, err := GetTxStatus(txHash)
+	txStatusApiResponse, err := GetTxStatus(ctx, ProtectTxApiHost, txHash)
 	require.Nil(t, err, err)
 	require.Equal(t, types.TxStatusUnknown, txStatusApiResponse.Status)
 
 	// NOT SEND when unknown and time since sent < 5 min
-	shouldSend = !request.blockResendingTxToRelay(txHash)
+	shouldSend = !request.blockResendingTxToRelay(ctx, txHash)
 	require.False(t, shouldSend)
 
 	// Set tx status to Failed
 	testutils.MockTxApiStatusForHash[txHash] = types.TxStatusFailed
-	txStatusApiResponse, err = GetTxStatus(txHash)
+	txStatusApiResponse, err = GetTxStatus(ctx, ProtectTxApiHost, txHash)
 	require.Nil(t, err, err)
 	require.Equal(t, types.TxStatusFailed, txStatusApiResponse.Status)
 
 	// SEND if failed
-	shouldSend = !request.blockResendingTxToRelay(txHash)
+	shouldSend = !request.blockResendingTxToRelay(ctx, txHash)
 	require.True(t, shouldSend)
 
 	// Set tx status to pending
 	testutils.MockTxApiStatusForHash[txHash] = types.TxStatusPending
-	txStatusApiResponse, err = GetTxStatus(txHash)
+	txStatusApiResponse, err = GetTxStatus(ctx, ProtectTxApiHost, txHash)
 	require.Nil(t, err, err)
 	require.Equal(t, types.TxStatusPending, txStatusApiResponse.Status)
 
 	// NOT SEND if pending
-	shouldSend = !request.blockResendingTxToRelay(txHash)
+	shouldSend = !request.blockResendingTxToRelay(ctx, txHash)
 	require.False(t, shouldSend)
 
 	//
@@ -93,20 +99,20 @@ func TestRequestshouldSendTxToRelay(t *testing.T) {
 	setServerTimeNowOffset(time.Minute * -6)
 	defer setServerTimeNowOffset(0)
 
-	err = RState.SetTxSentToRelay(txHash)
+	err = state.SetTxSentToRelay(ctx, txHash)
 	require.Nil(t, err, err)
 
-	timeSent, found, err := RState.GetTxSentToRelay(txHash)
+	timeSent, found, err := state.GetTxSentToRelay(ctx, txHash)
 	require.Nil(t, err, err)
 	require.True(t, found)
 	require.True(t, time.Since(timeSent) > time.Minute*4)
 
 	// Ensure tx status is UNKNOWN
-	txStatusApiResponse, err = GetTxStatus(txHash)
+	txStatusApiResponse, err = GetTxStatus(ctx, ProtectTxApiHost, txHash)
 	require.Nil(t, err, err)
 	require.Equal(t, types.TxStatusUnknown, txStatusApiResponse.Status)
 
-	shouldSend = !request.blockResendingTxToRelay(txHash)
+	shouldSend = !request.blockResendingTxToRelay(ctx, txHash)
 	require.True(t, shouldSend)
 }
 
@@ -115,14 +121,15 @@ type mockClient struct {
 	nextResponse *http.Response
 }
 
-func (m mockClient) ProxyRequest(body []byte) (*http.Response, error) {
+func (m mockClient) ProxyRequest(ctx context.Context, body []byte) (*http.Response, error) {
 	return m.nextResponse, m.err
 }
 
 var _ RPCProxyClient = &mockClient{}
 
 func TestFailedTxShouldResetMaxNonce(t *testing.T) {
-	setupRedis()
+	ctx := context.Background()
+	state := setupRedis()
 	setupMockTxApi()
 
 	sender := "0x6bc84f6a0fabbd7102be338c048fe0ae54948c2e"
@@ -133,10 +140,10 @@ func TestFailedTxShouldResetMaxNonce(t *testing.T) {
 	require.NotNil(t, privKey)
 
 	t.Run("setup", func(t *testing.T) {
-		err := RState.SetSenderMaxNonce(sender, 4, 10)
+		err := state.SetSenderMaxNonce(ctx, sender, 4, 10)
 		require.NoError(t, err)
 
-		status, err := GetTxStatus(txHash)
+		status, err := GetTxStatus(ctx, ProtectTxApiHost, txHash)
 		require.NoError(t, err)
 		require.Equal(t, types.TxStatusUnknown, status.Status)
 	})
@@ -146,7 +153,7 @@ func TestFailedTxShouldResetMaxNonce(t *testing.T) {
 	// if we see this test failing then we should invest in proper mock tooling
 	// around RpcRequest.
 	t.Run("send tx", func(t *testing.T) {
-		r := RpcRequest{}
+		r := RpcRequest{state: state, txApiHost: ProtectTxApiHost}
 		r.jsonReq = &types.JsonRpcRequest{
 			Id:     1,
 			Method: "eth_sendRawTransaction",
@@ -165,13 +172,13 @@ func TestFailedTxShouldResetMaxNonce(t *testing.T) {
 		}
 
 		// we expect handle_sendRawTransaction to set the
-		// nonce in the RState cache to the txs nonce (0x25)
-		r.handle_sendRawTransaction()
+		// nonce in the state cache to the txs nonce (0x25)
+		r.handle_sendRawTransaction(ctx)
 
 		// but actually the nonce is set asynchronously so we need to first
 		// sleep until we see it in the cache.
 		for i := 0; i < 5; i++ {
-			_, found, _ := RState.GetSenderMaxNonce(sender)
+			_, found, _ := state.GetSenderMaxNonce(ctx, sender)
 			if !found {
 				time.Sleep(time.Millisecond * time.Duration(i*10))
 			}
@@ -179,7 +186,7 @@ func TestFailedTxShouldResetMaxNonce(t *testing.T) {
 
 		// once found we can check the results
 		require.Equal(t, r.tx.Nonce(), uint64(0x25))
-		maxNonce, found, err := RState.GetSenderMaxNonce(sender)
+		maxNonce, found, err := state.GetSenderMaxNonce(ctx, sender)
 		require.NoError(t, err)
 		require.True(t, found)
 		require.Equal(t, r.tx.Nonce(), maxNonce)
@@ -192,7 +199,7 @@ func TestFailedTxShouldResetMaxNonce(t *testing.T) {
 
 	// and now simulate the user sending a eth_getTransactionReceipt request
 	t.Run("eth_getTransactionReceipt", func(t *testing.T) {
-		r := RpcRequest{}
+		r := RpcRequest{state: state, txApiHost: ProtectTxApiHost}
 		r.logger = log.New()
 		r.jsonReq = &types.JsonRpcRequest{
 			Id:      1,
@@ -206,14 +213,49 @@ func TestFailedTxShouldResetMaxNonce(t *testing.T) {
 			Error:   nil,
 			Version: "2.0",
 		}
-		r.check_post_getTransactionReceipt(response)
+		r.check_post_getTransactionReceipt(ctx, response)
 	})
 
 	// ensure that the max nonce is cleared
 	t.Run("check nonce", func(t *testing.T) {
-		maxNonce, found, err := RState.GetSenderMaxNonce(sender)
+		maxNonce, found, err := state.GetSenderMaxNonce(ctx, sender)
 		require.NoError(t, err)
 		require.Equal(t, uint64(0x0), maxNonce)
 		require.False(t, found)
 	})
 }
+
+func TestCheckTxChainId(t *testing.T) {
+	privKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	to := common.HexToAddress("0x6bc84f6a0fabbd7102be338c048fe0ae54948c2e")
+	legacyTx := ethtypes.NewTx(&ethtypes.LegacyTx{To: &to, Gas: 21000, GasPrice: big.NewInt(1)})
+	signTx := func(signer ethtypes.Signer) *ethtypes.Transaction {
+		tx, err := ethtypes.SignTx(legacyTx, signer, privKey)
+		require.NoError(t, err)
+		return tx
+	}
+
+	for _, tc := range []struct {
+		name                string
+		tx                  *ethtypes.Transaction
+		rejectUnprotectedTx bool
+		errCode             int
+	}{
+		{"same chain", signTx(ethtypes.LatestSignerForChainID(big.NewInt(1))), true, 0},
+		{"other chain", signTx(ethtypes.LatestSignerForChainID(big.NewInt(11155111))), false, types.JsonRpcWrongChainId},
+		{"unprotected", signTx(ethtypes.HomesteadSigner{}), false, 0},
+		{"unprotected rejected", signTx(ethtypes.HomesteadSigner{}), true, types.JsonRpcUnprotectedTx},
+	} {
+		r := RpcRequest{
+			logger:              log.New(),
+			jsonReq:             types.NewJsonRpcRequest(1, "eth_sendRawTransaction", nil),
+			ethSendRawTxEntry:   &database.EthSendRawTxEntry{},
+			chainIDInt:          big.NewInt(1),
+			rejectUnprotectedTx: tc.rejectUnprotectedTx,
+			tx:                  tc.tx,
+		}
+		require.Equal(t, tc.errCode == 0, r.checkTxChainId(), tc.name)
+		require.Equal(t, tc.errCode, r.ethSendRawTxEntry.ErrorCode, tc.name)
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/flashbots/rpc-endpoint/database"
@@ -33,6 +34,24 @@ func (r *requestRecord) AddEthSendRawTxEntry(id uuid.UUID) *database.EthSendRawT
 	return entry
 }
 
+// setRawTxEntryPreferences stores the resolved URL preferences of the request in the entry
+func setRawTxEntryPreferences(entry *database.EthSendRawTxEntry, params URLParameters) {
+	entry.Fast = params.fast || params.pref.Fast
+	entry.OriginId = params.originId
+	entry.PresetApplied = params.presetApplied
+	entry.Hints = strings.Join(params.pref.Privacy.Hints, ",")
+	entry.Builders = strings.Join(params.pref.Privacy.Builders, ",")
+	refunds := make([]string, len(params.pref.Validity.Refund))
+	for i, refund := range params.pref.Validity.Refund {
+		refunds[i] = fmt.Sprintf("%s:%d", strings.ToLower(refund.Address.Hex()), refund.Percent)
+	}
+	entry.Refund = strings.Join(refunds, ",")
+	entry.BlockRange = params.blockRange
+	entry.AuctionTimeout = int64(params.auctionTimeout)
+	entry.UseMempool = params.pref.Privacy.UseMempool
+	entry.AllowTEE = params.pref.Privacy.AllowTEE
+}
+
 func (r *requestRecord) UpdateRequestEntry(req *http.Request, reqStatus int, error string) {
 	r.requestEntry.HttpMethod = req.Method
 	r.requestEntry.Error = error
@@ -47,6 +66,12 @@ func (r *requestRecord) UpdateRequestEntry(req *http.Request, reqStatus int, err
 func (r *requestRecord) SaveRecord() error {
 	entries := r.getValidRawTxEntriesToSave()
 	if len(entries) > 0 { // Save entries if the request contains rawTxEntries
+		if recordStore, ok := r.db.(database.RecordStore); ok {
+			if err := recordStore.SaveRecord(r.requestEntry, entries); err != nil {
+				return fmt.Errorf("SaveRecord failed %v", err)
+			}
+			return nil
+		}
 		if err := r.db.SaveRequestEntry(r.requestEntry); err != nil {
 			return fmt.Errorf("SaveRequestEntry failed %v", err)
 		}
@@ -1,11 +1,13 @@
 package server
 
 import (
+	"net/url"
+	"sync"
+	"testing"
+
 	"github.com/flashbots/rpc-endpoint/database"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
-	"sync"
-	"testing"
 )
 
 func Test_requestRecord_getForwardedRawTxEntries(t *testing.T) {
@@ -107,3 +109,24 @@ func Test_requestRecord_SaveRecord(t *testing.T) {
 		})
 	}
 }
+
+func Test_setRawTxEntryPreferences(t *testing.T) {
+	reqUrl, err := url.Parse("/fast?originId=wallet&hint=calldata&builder=flashbots&refund=origin:50&blockRange=10&auctionTimeout=500&useMempool=true")
+	require.NoError(t, err)
+	params, err := ExtractParametersFromUrl(reqUrl, []string{"flashbots", "beaverbuild"})
+	require.NoError(t, err)
+	params.presetApplied = true
+
+	entry := &database.EthSendRawTxEntry{}
+	setRawTxEntryPreferences(entry, params)
+	require.True(t, entry.Fast)
+	require.Equal(t, "wallet", entry.OriginId)
+	require.True(t, entry.PresetApplied)
+	require.Equal(t, "calldata", entry.Hints)
+	require.Equal(t, "flashbots,beaverbuild", entry.Builders)
+	require.Equal(t, "0x0000000000000000000000000000000000000000:50", entry.Refund)
+	require.Equal(t, 10, entry.BlockRange)
+	require.Equal(t, int64(500), entry.AuctionTimeout)
+	require.True(t, entry.UseMempool)
+	require.True(t, entry.AllowTEE)
+}
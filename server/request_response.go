@@ -11,6 +11,7 @@ func (r *RpcRequestHandler) writeHeaderContentTypeJson() {
 }
 
 func (r *RpcRequestHandler) _writeRpcResponse(res *types.JsonRpcResponse) {
+	r.rpcError = res == nil || res.Error != nil
 	// If the request is single and not batch
 	// Write content type
 	r.writeHeaderContentTypeJson() // Set content type to json
@@ -1,11 +1,12 @@
 package server
 
 import (
+	"context"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/flashbots/rpc-endpoint/types"
 )
 
-func (r *RpcRequest) handle_sendPrivateTransaction() {
+func (r *RpcRequest) handle_sendPrivateTransaction(ctx context.Context) {
 	if len(r.jsonReq.Params) > 1 {
 		m, ok := r.jsonReq.Params[1].(string)
 		if !ok {
@@ -33,5 +34,5 @@ func (r *RpcRequest) handle_sendPrivateTransaction() {
 		r.urlParams.pref.Fast = fast
 	}
 
-	r.handle_sendRawTransaction()
+	r.handle_sendRawTransaction(ctx)
 }
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"strings"
@@ -9,20 +10,27 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/flashbots/rpc-endpoint/metrics"
 	"github.com/flashbots/rpc-endpoint/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	scMethodBytes = 4 // first 4 byte of data field
 )
 
-func (r *RpcRequest) handle_sendRawTransaction() {
+func (r *RpcRequest) handle_sendRawTransaction(ctx context.Context) {
 	metrics.IncPrivateTx()
+	setRawTxEntryPreferences(r.ethSendRawTxEntry, r.urlParams)
+	if r.urlParams.presetApplied {
+		r.decide("preset", DecisionPass, "preferences of the origin preset applied")
+	}
 
 	var err error
 
 	// JSON-RPC sanity checks
 	if len(r.jsonReq.Params) < 1 {
 		r.logger.Info("[sendRawTransaction] No params for eth_sendRawTransaction")
+		r.decide("params", DecisionStop, "empty params")
 		r.writeRpcError("empty params for eth_sendRawTransaction", types.JsonRpcInvalidParams)
 		return
 	}
@@ -35,6 +43,7 @@ func (r *RpcRequest) handle_sendRawTransaction() {
 	r.rawTxHex = r.jsonReq.Params[0].(string)
 	if len(r.rawTxHex) < 2 {
 		r.logger.Error("[sendRawTransaction] Invalid raw transaction (wrong length)")
+		r.decide("params", DecisionStop, "raw tx has the wrong length")
 		r.writeRpcError("invalid raw transaction param (wrong length)", types.JsonRpcInvalidParams)
 		return
 	}
@@ -44,17 +53,26 @@ func (r *RpcRequest) handle_sendRawTransaction() {
 	r.tx, err = GetTx(r.rawTxHex)
 	if err != nil {
 		r.logger.Info("[sendRawTransaction] Reading transaction object failed", "tx", r.rawTxHex)
+		r.decide("decode", DecisionStop, err.Error())
 		r.writeRpcError(fmt.Sprintf("reading transaction object failed - rawTx: %s", r.rawTxHex), types.JsonRpcInvalidRequest)
 		return
 	}
+	r.decide("params", DecisionPass, "")
 	r.ethSendRawTxEntry.TxHash = r.tx.Hash().String()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("tx.hash", r.tx.Hash().String()))
 	r.logger = r.logger.New("txHash", r.tx.Hash().String())
+
+	if !r.checkTxChainId() {
+		return
+	}
+
 	// Get address from tx
 	r.logger.Info("[sendRawTransaction] start to process raw tx", "txHash", r.tx.Hash(), "timestamp", time.Now().Unix(), "time", time.Now().UTC())
 	r.txFrom, err = GetSenderFromRawTx(r.tx)
 	if err != nil {
 
 		r.logger.Info("[sendRawTransaction] Couldn't get address from rawTx", "error", err)
+		r.decide("sender", DecisionStop, err.Error())
 		r.writeRpcError(fmt.Sprintf("couldn't get address from rawTx: %v", err), types.JsonRpcInvalidRequest)
 		return
 	}
@@ -63,7 +81,7 @@ func (r *RpcRequest) handle_sendRawTransaction() {
 	txFromLower := strings.ToLower(r.txFrom)
 
 	// store tx info to ethSendRawTxEntries which will be stored in db for data analytics reason
-	r.ethSendRawTxEntry.TxFrom = r.txFrom
+	r.ethSendRawTxEntry.TxFrom = txFromLower
 	r.ethSendRawTxEntry.TxTo = AddressPtrToStr(r.tx.To())
 	r.ethSendRawTxEntry.TxNonce = int(r.tx.Nonce())
 
@@ -77,21 +95,28 @@ func (r *RpcRequest) handle_sendRawTransaction() {
 
 	if r.tx.Nonce() >= 1e9 {
 		r.logger.Info("[sendRawTransaction] tx rejected - nonce too high", "txNonce", r.tx.Nonce(), "txFromLower", txFromLower, "origin", r.origin)
+		r.decide("nonce_limit", DecisionStop, fmt.Sprintf("nonce %d too high", r.tx.Nonce()))
 		r.writeRpcError("tx rejected - nonce too high", types.JsonRpcInvalidRequest)
 		return
 	}
+	r.decide("nonce_limit", DecisionPass, "")
 
 	txHashLower := strings.ToLower(r.tx.Hash().Hex())
 	// Check if tx was blocked (eg. "nonce too low")
-	retVal, isBlocked, _ := RState.GetBlockedTxHash(txHashLower)
+	retVal, isBlocked, err := r.state.GetBlockedTxHash(ctx, txHashLower)
 	if isBlocked {
 		r.logger.Info("[sendRawTransaction] tx blocked", "retVal", retVal)
+		r.decide("blocked_tx_hash", DecisionStop, retVal)
 		r.writeRpcError(retVal, types.JsonRpcInternalError)
 		return
+	} else if err != nil {
+		r.decide("blocked_tx_hash", DecisionError, err.Error())
+	} else {
+		r.decide("blocked_tx_hash", DecisionPass, "")
 	}
 
 	// Remember sender and nonce of the tx, for lookup in getTransactionReceipt to possibly set nonce-fix
-	err = RState.SetSenderAndNonceOfTxHash(txHashLower, txFromLower, r.tx.Nonce())
+	err = r.state.SetSenderAndNonceOfTxHash(ctx, txHashLower, txFromLower, r.tx.Nonce())
 	if err != nil {
 		metrics.IncRedisErr()
 		r.logger.Error("[sendRawTransaction] Redis:SetSenderAndNonceOfTxHash failed: %v", err)
@@ -104,22 +129,26 @@ func (r *RpcRequest) handle_sendRawTransaction() {
 	r.ethSendRawTxEntry.IsOnOafcList = isOnOfacList
 	if isOnOfacList {
 		r.logger.Info("[sendRawTransaction] Blocked tx due to ofac sanctioned address", "txFrom", r.txFrom, "txTo", txToAddr)
+		r.decide("ofac", DecisionStop, "sanctioned address")
 		r.writeRpcError("blocked tx due to ofac sanctioned address", types.JsonRpcInvalidRequest)
 		return
 	}
+	r.decide("ofac", DecisionPass, "")
 
 	// Check if transaction needs protection
 	r.ethSendRawTxEntry.NeedsFrontRunningProtection = true
 	// If users specify a bundle ID, cache this transaction
 	if r.isWhitehatBundleCollection {
 		r.logger.Info("[WhitehatBundleCollection] Adding tx to bundle", "whiteHatBundleId", r.whitehatBundleId, "tx", r.rawTxHex)
-		err = RState.AddTxToWhitehatBundle(r.whitehatBundleId, r.rawTxHex)
+		err = r.state.AddTxToWhitehatBundle(ctx, r.whitehatBundleId, r.rawTxHex)
 		if err != nil {
 			metrics.IncRedisErr()
 			r.logger.Error("[WhitehatBundleCollection] AddTxToWhitehatBundle failed", "error", err)
+			r.decide("whitehat_bundle", DecisionStop, err.Error())
 			r.writeRpcError("[WhitehatBundleCollection] AddTxToWhitehatBundle failed:", types.JsonRpcInternalError)
 			return
 		}
+		r.decide("whitehat_bundle", DecisionStop, "collected in bundle "+r.whitehatBundleId)
 		r.writeRpcResult(r.tx.Hash().Hex())
 		return
 	}
@@ -127,19 +156,47 @@ func (r *RpcRequest) handle_sendRawTransaction() {
 	// Check for cancellation-tx
 	if r.tx.To() != nil && len(r.tx.Data()) <= 2 && txFromLower == strings.ToLower(r.tx.To().Hex()) {
 		r.ethSendRawTxEntry.IsCancelTx = true
-		requestDone := r.handleCancelTx() // returns true if tx was cancelled at the relay and response has been sent to the user
+		requestDone := r.handleCancelTx(ctx) // returns true if tx was cancelled at the relay and response has been sent to the user
 		if !requestDone {
+			r.decide("cancel_tx", DecisionPass, "no cancellable tx with the same nonce was sent to the relay")
 			r.ethSendRawTxEntry.IsCancelTx = false
 			r.logger.Warn("[cancel-tx] This is not a cancellation tx, since we don't have original one. So we process it as usual tx", "txFromLower", txFromLower, "txNonce", r.tx.Nonce())
-			r.sendTxToRelay()
+			r.sendTxToRelay(ctx)
 		}
 		return
 	}
 
 	// do it as the last step, in case it is used as cancellation
 	if r.tx.GasTipCap().Cmp(big.NewInt(0)) == 0 {
+		r.decide("gas_tip", DecisionStop, "gas tip cap 0")
 		r.writeRpcError("transaction underpriced: gas tip cap 0, minimum needed 1", types.JsonRpcInvalidRequest)
 		return
 	}
-	r.sendTxToRelay()
+	r.decide("gas_tip", DecisionPass, "")
+	r.sendTxToRelay(ctx)
+}
+
+// checkTxChainId rejects txs signed for another chain, which would never be included, and unprotected
+// legacy txs if the chain requires a chain id. Returns false if the tx was rejected.
+func (r *RpcRequest) checkTxChainId() bool {
+	if !r.tx.Protected() {
+		if r.rejectUnprotectedTx {
+			r.logger.Info("[sendRawTransaction] tx rejected - no chain id")
+			metrics.IncTxRejected("unprotected")
+			r.decide("chain_id", DecisionStop, "unprotected tx without chain id")
+			r.writeRpcError("tx rejected - unprotected tx without chain id (pre EIP-155)", types.JsonRpcUnprotectedTx)
+			return false
+		}
+		r.decide("chain_id", DecisionPass, "unprotected tx without chain id")
+		return true
+	}
+	if r.chainIDInt != nil && r.tx.ChainId().Cmp(r.chainIDInt) != 0 {
+		r.logger.Info("[sendRawTransaction] tx rejected - wrong chain id", "txChainId", r.tx.ChainId(), "chainId", r.chainIDInt)
+		metrics.IncTxRejected("wrong_chain_id")
+		r.decide("chain_id", DecisionStop, fmt.Sprintf("chain id %s does not match %s", r.tx.ChainId(), r.chainIDInt))
+		r.writeRpcError(fmt.Sprintf("tx rejected - chain id %s does not match %s", r.tx.ChainId(), r.chainIDInt), types.JsonRpcWrongChainId)
+		return false
+	}
+	r.decide("chain_id", DecisionPass, "")
+	return true
 }
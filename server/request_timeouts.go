@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RequestTimeouts are the deadlines of a request and of its calls to other services. The calls are also
+// cancelled when the request is, e.g. when the client disconnects.
+type RequestTimeouts struct {
+	Request  time.Duration // whole request, should be below the WriteTimeout of the server
+	TxStatus time.Duration // each call to the tx status API
+	Relay    time.Duration // each call to the relay
+	Mempool  time.Duration // each call to the mempool RPC
+}
+
+var DefaultRequestTimeouts = RequestTimeouts{
+	Request:  25 * time.Second,
+	TxStatus: 5 * time.Second,
+	Relay:    10 * time.Second,
+	Mempool:  customMempoolTimeout,
+}
+
+// WithDefaults sets zero values from DefaultRequestTimeouts
+func (t RequestTimeouts) WithDefaults() RequestTimeouts {
+	d := DefaultRequestTimeouts
+	t.Request = valueOrDefault(t.Request, d.Request)
+	t.TxStatus = valueOrDefault(t.TxStatus, d.TxStatus)
+	t.Relay = valueOrDefault(t.Relay, d.Relay)
+	t.Mempool = valueOrDefault(t.Mempool, d.Mempool)
+	return t
+}
+
+const (
+	CancelReasonClientGone = "client_gone"
+	CancelReasonTimeout    = "timeout"
+)
+
+// cancelReason returns why the context of a request was done, or an empty string if it isn't
+func cancelReason(ctx context.Context) string {
+	switch {
+	case ctx.Err() == nil:
+		return ""
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return CancelReasonTimeout
+	default:
+		return CancelReasonClientGone
+	}
+}
+
+// withTimeout returns the context of a call of a request, a zero timeout only keeps the deadline of the request
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
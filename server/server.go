@@ -3,26 +3,23 @@ package server
 import (
 	"context"
 	"crypto/ecdsa"
+	"crypto/tls"
 	"encoding/json"
 	"io"
 	"net/http"
-	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"runtime"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/flashbots/rpc-endpoint/adapters/webfile"
-	"github.com/flashbots/rpc-endpoint/application"
 	"github.com/flashbots/rpc-endpoint/database"
 
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/log"
 
-	"github.com/alicebob/miniredis"
 	"github.com/pkg/errors"
 
 	"github.com/flashbots/rpc-endpoint/types"
@@ -32,108 +29,211 @@ var Now = time.Now // used to mock time in tests
 
 var DebugDontSendTx = os.Getenv("DEBUG_DONT_SEND_RAWTX") != ""
 
-// Metamask fix helper
-var RState *RedisState
+// Defaults for the background request record writer
+var (
+	DefaultRecordQueueSize      = 10000
+	DefaultRecordWorkers        = 4
+	DefaultRecordEnqueueTimeout = 100 * time.Millisecond
+	DefaultRecordDrainTimeout   = 20 * time.Second
+)
 
 type BuilderNameProvider interface {
 	BuilderNames() []string
 }
+
+// BuilderRegistryAgeProvider is implemented by builder name providers which are periodically refreshed
+type BuilderRegistryAgeProvider interface {
+	LastFetched() (time.Time, bool)
+}
+
 type RpcEndPointServer struct {
-	server *http.Server
-	drain  *http.Server
-
-	drainAddress         string
-	drainSeconds         int
-	db                   database.Store
-	isHealthy            bool
-	isHealthyMx          sync.RWMutex
-	listenAddress        string
-	logger               log.Logger
-	proxyTimeoutSeconds  int
-	proxyUrl             string
-	relaySigningKey      *ecdsa.PrivateKey
-	relayUrl             string
-	startTime            time.Time
-	version              string
-	builderNameProvider  BuilderNameProvider
-	chainID              []byte
-	rpcCache             *application.RpcCache
-	defaultEthClient     *ethclient.Client
-	configurationWatcher *ConfigurationWatcher
+	server      *http.Server
+	drainServer *http.Server
+	admin       *http.Server
+
+	drainAddress             string
+	adminAddress             string
+	adminToken               string
+	adminTLSCertFile         string
+	adminTLSKeyFile          string
+	adminTLSConfig           *tls.Config // requires client certificates if a client CA is configured
+	drainSeconds             int
+	fetchInfoIntervalSeconds int
+	db                       database.Store
+	drain                    *DrainState
+	auditSink                AuditSink
+	listenAddress            string
+	logger                   log.Logger
+	proxyTimeoutSeconds      int
+	requestTimeouts          RequestTimeouts
+	relaySigningKey          *ecdsa.PrivateKey
+	startTime                time.Time
+	version                  string
+	configurationWatcher     atomic.Pointer[ConfigurationWatcher] // replaced on reload
+	customerConfigFile       string
+	methodPolicy             *MethodPolicy
+	mempoolClients           *EthClientPool
+	mempoolBroadcaster       *MempoolBroadcaster
+	webhookNotifier          *WebhookNotifier
+	upstreamGuard            *UpstreamGuard
+	recordWriter             *RecordWriter
+	recordDrainTimeout       time.Duration
+	shutdownTracing          func(context.Context) error
+	chains                   *chainRouter
 }
 
 func NewRpcEndPointServer(cfg Configuration) (*RpcEndPointServer, error) {
-	var err error
+	adminTLSConfig, err := newAdminTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err = cfg.MethodPolicy.Validate(); err != nil {
+		return nil, err
+	}
 	if DebugDontSendTx {
 		cfg.Logger.Info("DEBUG MODE: raw transactions will not be sent out!", "redisUrl", cfg.RedisUrl)
 	}
 
-	if cfg.RedisUrl == "dev" {
-		cfg.Logger.Info("Using integrated in-memory Redis instance", "redisUrl", cfg.RedisUrl)
-		redisServer, err := miniredis.Run()
+	stateOpts := cfg.StateOptions.WithDefaults()
+	state := cfg.State
+	if state == nil {
+		state, err = newStateStore(cfg, stateOpts)
 		if err != nil {
 			return nil, err
 		}
-		cfg.RedisUrl = redisServer.Addr()
 	}
-	// Setup redis connection
-	cfg.Logger.Info("Connecting to redis...", "redisUrl", cfg.RedisUrl)
-	RState, err = NewRedisState(cfg.RedisUrl)
+	// The webhook events of all chains are queued in the state of the default chain
+	var webhooks map[string]WebhookConfig
+	if cfg.ConfigurationWatcher != nil {
+		webhooks = cfg.ConfigurationWatcher.Config.Webhooks
+	}
+	webhookNotifier := NewWebhookNotifier(cfg.Logger, state, webhooks)
+	// The mempool RPCs of the chains are dialed on first use, and kept while the server runs
+	mempoolClients := NewEthClientPool(len(cfg.Chains)+1, 0, func(ctx context.Context, url string) (*ethclient.Client, error) {
+		return ethclient.DialContext(ctx, url)
+	})
+	defaultChain, err := newChain(cfg, ChainConfiguration{
+		ProxyUrls:            []string{cfg.ProxyUrl},
+		RelayUrl:             cfg.RelayUrl,
+		MempoolRPC:           cfg.DefaultMempoolRPC,
+		TxApiHost:            ProtectTxApiHost,
+		BuilderInfoSource:    cfg.BuilderInfoSource,
+		RejectUnprotectedTxs: cfg.RejectUnprotectedTxs,
+	}, state, mempoolClients, webhookNotifier)
 	if err != nil {
-		return nil, errors.Wrap(err, "Redis init error")
+		return nil, err
 	}
-	var builderInfoFetcher application.Fetcher
-	if cfg.BuilderInfoSource != "" {
-		builderInfoFetcher = webfile.NewFetcher(cfg.BuilderInfoSource)
+	chains := newChainRouter(defaultChain)
+	for _, chainCfg := range cfg.Chains {
+		if err = chainCfg.Validate(); err != nil {
+			return nil, err
+		}
+		chainStateOpts := stateOpts
+		chainStateOpts.Namespace = valueOrDefault(chainCfg.RedisNamespace, chainCfg.Name)
+		chainState, err := newStateStore(cfg, chainStateOpts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "chain %s", chainCfg.Name)
+		}
+		chain, err := newChain(cfg, chainCfg, chainState, mempoolClients, webhookNotifier)
+		if err != nil {
+			return nil, errors.Wrapf(err, "chain %s", chainCfg.Name)
+		}
+		if err = chains.add(chain, chainCfg.Hosts); err != nil {
+			return nil, err
+		}
 	}
-	bis, err := application.StartBuilderInfoService(context.Background(), builderInfoFetcher, time.Second*time.Duration(cfg.FetchInfoInterval))
-	if err != nil {
-		return nil, errors.Wrap(err, "BuilderInfoService init error")
+	for _, chain := range chains.chains() {
+		cfg.Logger.Info("Serving chain", "name", chain.name, "chainID", chain.chainIDInt, "proxyUrls", chain.proxyUrls, "relayUrl", chain.relayUrl)
+	}
+
+	upstreamGuard := NewUpstreamGuard(cfg.AllowedCustomUpstreams)
+	// The mempool broadcasts of all chains are scheduled in the state of the default chain
+	var mempoolBroadcaster *MempoolBroadcaster
+	if cfg.MempoolBroadcast {
+		mempoolBroadcaster = NewMempoolBroadcaster(cfg.Logger, state, chains, upstreamGuard, cfg.MempoolBroadcastDelay)
 	}
 
-	bts, err := fetchNetworkIDBytes(cfg)
+	shutdownTracing, err := SetupTracing(cfg.Tracing, cfg.Version)
 	if err != nil {
-		return nil, errors.Wrap(err, "fetchNetworkIDBytes error")
+		return nil, err
 	}
 
-	rpcCache := application.NewRpcCache(cfg.TTLCacheSeconds)
-	ethCl, err := ethclient.Dial(cfg.DefaultMempoolRPC)
+	recordWriter := NewRecordWriter(
+		cfg.Logger,
+		valueOrDefault(cfg.RecordQueueSize, DefaultRecordQueueSize),
+		valueOrDefault(cfg.RecordWorkers, DefaultRecordWorkers),
+		valueOrDefault(cfg.RecordEnqueueTimeout, DefaultRecordEnqueueTimeout),
+	)
+	recordWriter.Start()
+
+	s := &RpcEndPointServer{
+		db:                       cfg.DB,
+		drainAddress:             cfg.DrainAddress,
+		adminAddress:             cfg.AdminAddress,
+		adminToken:               cfg.AdminToken,
+		adminTLSCertFile:         cfg.AdminTLSCertFile,
+		adminTLSKeyFile:          cfg.AdminTLSKeyFile,
+		adminTLSConfig:           adminTLSConfig,
+		drainSeconds:             cfg.DrainSeconds,
+		fetchInfoIntervalSeconds: cfg.FetchInfoInterval,
+		drain:                    &DrainState{},
+		auditSink:                cfg.AuditSink,
+		listenAddress:            cfg.ListenAddress,
+		logger:                   cfg.Logger,
+		proxyTimeoutSeconds:      cfg.ProxyTimeoutSeconds,
+		requestTimeouts:          cfg.RequestTimeouts.WithDefaults(),
+		relaySigningKey:          cfg.RelaySigningKey,
+		startTime:                Now(),
+		version:                  cfg.Version,
+		customerConfigFile:       cfg.CustomerConfigFile,
+		methodPolicy:             &cfg.MethodPolicy,
+		mempoolClients:           mempoolClients,
+		mempoolBroadcaster:       mempoolBroadcaster,
+		webhookNotifier:          webhookNotifier,
+		upstreamGuard:            upstreamGuard,
+		recordWriter:             recordWriter,
+		recordDrainTimeout:       valueOrDefault(cfg.RecordDrainTimeout, DefaultRecordDrainTimeout),
+		shutdownTracing:          shutdownTracing,
+		chains:                   chains,
+	}
+	s.configurationWatcher.Store(cfg.ConfigurationWatcher)
+	return s, nil
+}
+
+// newStateStore connects to Redis, or keeps the state in memory if the Redis url is "dev"
+func newStateStore(cfg Configuration, opts StateOptions) (StateStore, error) {
+	if cfg.RedisUrl == "dev" {
+		cfg.Logger.Info("Using in-memory state instead of Redis", "redisUrl", cfg.RedisUrl, "namespace", opts.Namespace)
+		state, err := NewMemState(opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "state init error")
+		}
+		return state, nil
+	}
+	cfg.Logger.Info("Connecting to redis...", "redisUrl", cfg.RedisUrl, "namespace", opts.Namespace)
+	state, err := NewRedisState(cfg.RedisUrl, opts)
 	if err != nil {
-		return nil, errors.Wrap(err, "ethclient.Dial error")
-	}
-	return &RpcEndPointServer{
-		db:                   cfg.DB,
-		drainAddress:         cfg.DrainAddress,
-		drainSeconds:         cfg.DrainSeconds,
-		isHealthy:            true,
-		listenAddress:        cfg.ListenAddress,
-		logger:               cfg.Logger,
-		proxyTimeoutSeconds:  cfg.ProxyTimeoutSeconds,
-		proxyUrl:             cfg.ProxyUrl,
-		relaySigningKey:      cfg.RelaySigningKey,
-		relayUrl:             cfg.RelayUrl,
-		startTime:            Now(),
-		version:              cfg.Version,
-		chainID:              bts,
-		builderNameProvider:  bis,
-		rpcCache:             rpcCache,
-		defaultEthClient:     ethCl,
-		configurationWatcher: cfg.ConfigurationWatcher,
-	}, nil
+		return nil, errors.Wrap(err, "Redis init error")
+	}
+	return state, nil
 }
 
-func fetchNetworkIDBytes(cfg Configuration) ([]byte, error) {
+func fetchNetworkIDBytes(logger log.Logger, proxyUrl string, proxyTimeoutSeconds int) ([]byte, error) {
+	return fetchRpcResult(logger, proxyUrl, proxyTimeoutSeconds, "net_version")
+}
 
-	cl := NewRPCProxyClient(cfg.Logger, cfg.ProxyUrl, cfg.ProxyTimeoutSeconds, 0)
+// fetchRpcResult returns the raw result of a JSON-RPC method without params
+func fetchRpcResult(logger log.Logger, proxyUrl string, proxyTimeoutSeconds int, method string) ([]byte, error) {
+	cl := NewRPCProxyClient(logger, proxyUrl, proxyTimeoutSeconds, 0)
 
-	_req := types.NewJsonRpcRequest(1, "net_version", []interface{}{})
+	_req := types.NewJsonRpcRequest(1, method, []interface{}{})
 	jsonData, err := json.Marshal(_req)
 	if err != nil {
-		return nil, errors.Wrap(err, "network version request failed")
+		return nil, errors.Wrapf(err, "%s request failed", method)
 	}
-	httpRes, err := cl.ProxyRequest(jsonData)
+	httpRes, err := cl.ProxyRequest(context.Background(), jsonData)
 	if err != nil {
-		return nil, errors.Wrap(err, "cl.NetworkID error")
+		return nil, errors.Wrapf(err, "cl.ProxyRequest %s error", method)
 	}
 
 	resBytes, err := io.ReadAll(httpRes.Body)
@@ -145,7 +245,9 @@ func fetchNetworkIDBytes(cfg Configuration) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-
+	if _res.Error != nil {
+		return nil, errors.Errorf("%s error: %s", method, _res.Error.Message)
+	}
 	return _res.Result, nil
 }
 
@@ -162,14 +264,35 @@ func (s *RpcEndPointServer) Start() {
 
 	s.startMainServer()
 	s.startDrainServer()
+	s.startAdminServer()
+	for _, chain := range s.chains.chains() {
+		if chain.inclusionTracker != nil {
+			chain.inclusionTracker.Start()
+		}
+	}
+	s.webhookNotifier.Start()
+	s.mempoolBroadcaster.Start()
 
 	notifier := make(chan os.Signal, 1)
 	signal.Notify(notifier, os.Interrupt, syscall.SIGTERM)
 
 	<-notifier
 
+	s.stopAdminServer()
 	s.stopDrainServer()
 	s.stopMainServer()
+	s.stopMempoolBroadcaster()
+	s.stopRecordWriter()
+	s.stopAuditSink()
+	for _, chain := range s.chains.chains() {
+		if chain.inclusionTracker != nil {
+			chain.inclusionTracker.Stop()
+		}
+	}
+	s.webhookNotifier.Stop()
+	s.mempoolClients.Close()
+	s.upstreamGuard.Close()
+	s.stopTracing()
 }
 
 func (s *RpcEndPointServer) startMainServer() {
@@ -180,6 +303,7 @@ func (s *RpcEndPointServer) startMainServer() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.HandleHttpRequest)
 	mux.HandleFunc("/health", s.handleHealthRequest)
+	mux.HandleFunc("/readiness", s.handleReadinessRequest)
 	mux.HandleFunc("/bundle", s.HandleBundleRequest)
 	wrappedRouter := MetricsMiddleware(mux)
 
@@ -208,40 +332,105 @@ func (s *RpcEndPointServer) stopMainServer() {
 	}
 }
 
+// stopMempoolBroadcaster waits for running broadcasts, the scheduled ones stay in the state for the next instance
+func (s *RpcEndPointServer) stopMempoolBroadcaster() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.recordDrainTimeout)
+	defer cancel()
+	if err := s.mempoolBroadcaster.Stop(ctx); err != nil {
+		s.logger.Error("mempool broadcaster shutdown failed", "error", err)
+	}
+}
+
+// stopRecordWriter flushes pending request records, and then the entries buffered by the database, each within
+// recordDrainTimeout. It must be called after the main server has stopped, so no new records are enqueued.
+func (s *RpcEndPointServer) stopRecordWriter() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.recordDrainTimeout)
+	defer cancel()
+	if err := s.recordWriter.Stop(ctx); err != nil {
+		s.logger.Error("record writer shutdown failed", "error", err)
+	} else {
+		s.logger.Info("record writer stopped")
+	}
+
+	stopper, ok := s.db.(database.Stopper)
+	if !ok {
+		return
+	}
+	// The record writer may have used up its deadline, the database flush gets its own
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), s.recordDrainTimeout)
+	defer flushCancel()
+	if err := stopper.Stop(flushCtx); err != nil {
+		s.logger.Error("database flush failed", "error", err)
+	}
+}
+
+// stopAuditSink flushes pending decision records, after the records of all requests are saved
+func (s *RpcEndPointServer) stopAuditSink() {
+	if s.auditSink == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.recordDrainTimeout)
+	defer cancel()
+	if err := s.auditSink.Close(ctx); err != nil {
+		s.logger.Error("audit sink shutdown failed", "error", err)
+	}
+}
+
+// stopTracing exports the remaining spans
+func (s *RpcEndPointServer) stopTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.shutdownTracing(ctx); err != nil {
+		s.logger.Error("tracing shutdown failed", "error", err)
+	}
+}
+
 func (s *RpcEndPointServer) startDrainServer() {
-	if s.drain != nil {
+	if s.drainServer != nil {
 		panic("drain http server is already running")
 	}
 	mux := http.NewServeMux()
+	// No auth on this listener, so it only starts the shutdown drain. Drains and undrains go through the admin API.
 	mux.HandleFunc("/", s.handleDrain)
-	s.drain = &http.Server{
+	s.drainServer = &http.Server{
 		Addr:    s.drainAddress,
 		Handler: mux,
 	}
 	go func() {
-		if err := s.drain.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := s.drainServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			s.logger.Error("drain http server failed", "error", err)
 		}
 	}()
 }
 
 func (s *RpcEndPointServer) stopDrainServer() {
-	if s.drain != nil {
+	if s.drainServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
-		if err := s.drain.Shutdown(ctx); err != nil {
+		if err := s.drainServer.Shutdown(ctx); err != nil {
 			s.logger.Error("drain http server shutdown failed", "error", err)
 		}
 		s.logger.Info("drain http server stopped")
-		s.drain = nil
+		s.drainServer = nil
 	}
 }
 
 func (s *RpcEndPointServer) HandleHttpRequest(respw http.ResponseWriter, req *http.Request) {
 	setCorsHeaders(respw)
 
+	chain, path := s.chains.route(req)
+	if path == "/bundle" {
+		s.handleBundleRequest(chain, respw, req)
+		return
+	}
+
+	if req.Method == http.MethodGet && path == "/chain" {
+		s.handleChainInfo(chain, respw)
+		return
+	}
+
 	if req.Method == http.MethodGet {
-		if strings.Trim(req.URL.Path, "/") == "fast" {
+		if strings.Trim(path, "/") == "fast" {
 			http.Redirect(respw, req, "https://docs.flashbots.net/flashbots-protect/quick-start#faster-transactions", http.StatusFound)
 		} else {
 			http.Redirect(respw, req, "https://docs.flashbots.net/flashbots-protect/rpc/quick-start/", http.StatusFound)
@@ -254,36 +443,28 @@ func (s *RpcEndPointServer) HandleHttpRequest(respw http.ResponseWriter, req *ht
 		return
 	}
 
-	request := NewRpcRequestHandler(s.logger, &respw, req, s.proxyUrl, s.proxyTimeoutSeconds, s.relaySigningKey, s.relayUrl, s.db, s.builderNameProvider.BuilderNames(), s.chainID, s.rpcCache, s.defaultEthClient, s.configurationWatcher)
+	// The url params are parsed from the path without the chain name, e.g. /sepolia/fast is /fast
+	if path != req.URL.Path {
+		req.URL.Path = path
+	}
+	request := NewRpcRequestHandler(s.logger, &respw, req, chain, s.proxyTimeoutSeconds, s.relaySigningKey, s.db, s.configurationWatcher.Load(), s.methodPolicy, s.upstreamGuard, s.mempoolBroadcaster, s.recordWriter, s.requestTimeouts, s.drain, s.auditSink)
 	request.process()
 }
 
-func (s *RpcEndPointServer) handleDrain(respw http.ResponseWriter, req *http.Request) {
-	s.isHealthyMx.Lock()
-	if !s.isHealthy {
-		s.isHealthyMx.Unlock()
-		return
+// handleChainInfo returns the metadata of the chain, for wallets and status pages
+func (s *RpcEndPointServer) handleChainInfo(chain *Chain, respw http.ResponseWriter) {
+	respw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(respw).Encode(chain.info()); err != nil {
+		s.logger.Error("[handleChainInfo] encoding chain info failed", "error", err)
 	}
-
-	s.isHealthy = false
-	s.logger.Info("Server marked as unhealthy")
-
-	// Let's not hold onto the lock in our sleep
-	s.isHealthyMx.Unlock()
-
-	// Give LB enough time to detect us unhealthy
-	time.Sleep(
-		time.Duration(s.drainSeconds) * time.Second,
-	)
 }
 
 func (s *RpcEndPointServer) handleHealthRequest(respw http.ResponseWriter, req *http.Request) {
-	s.isHealthyMx.RLock()
-	defer s.isHealthyMx.RUnlock()
 	res := types.HealthResponse{
-		Now:       Now(),
-		StartTime: s.startTime,
-		Version:   s.version,
+		Now:         Now(),
+		StartTime:   s.startTime,
+		Version:     s.version,
+		DrainStatus: s.drain.Status(),
 	}
 
 	jsonResp, err := json.Marshal(res)
@@ -294,7 +475,7 @@ func (s *RpcEndPointServer) handleHealthRequest(respw http.ResponseWriter, req *
 	}
 
 	respw.Header().Set("Content-Type", "application/json")
-	if s.isHealthy {
+	if !res.Draining {
 		respw.WriteHeader(http.StatusOK)
 	} else {
 		respw.WriteHeader(http.StatusInternalServerError)
@@ -302,7 +483,13 @@ func (s *RpcEndPointServer) handleHealthRequest(respw http.ResponseWriter, req *
 	respw.Write(jsonResp)
 }
 
+// HandleBundleRequest serves the whitehat bundle txs of the chain of the request's host, see also /<chain>/bundle
 func (s *RpcEndPointServer) HandleBundleRequest(respw http.ResponseWriter, req *http.Request) {
+	chain, _ := s.chains.route(req)
+	s.handleBundleRequest(chain, respw, req)
+}
+
+func (s *RpcEndPointServer) handleBundleRequest(chain *Chain, respw http.ResponseWriter, req *http.Request) {
 	setCorsHeaders(respw)
 	bundleId := req.URL.Query().Get("id")
 	if bundleId == "" {
@@ -311,7 +498,7 @@ func (s *RpcEndPointServer) HandleBundleRequest(respw http.ResponseWriter, req *
 	}
 
 	if req.Method == http.MethodGet {
-		txs, err := RState.GetWhitehatBundleTx(bundleId)
+		txs, err := chain.state.GetWhitehatBundleTx(req.Context(), bundleId)
 		if err != nil {
 			s.logger.Info("[handleBundleRequest] GetWhitehatBundleTx failed", "bundleId", bundleId, "error", err)
 			respw.WriteHeader(http.StatusInternalServerError)
@@ -334,7 +521,7 @@ func (s *RpcEndPointServer) HandleBundleRequest(respw http.ResponseWriter, req *
 		respw.Write(jsonResp)
 
 	} else if req.Method == http.MethodDelete {
-		RState.DelWhitehatBundleTx(bundleId)
+		chain.state.DelWhitehatBundleTx(req.Context(), bundleId)
 		respw.WriteHeader(http.StatusOK)
 
 	} else {
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StateStore holds the short-lived state shared between requests, like which txs were sent to the relay,
+// pending nonces and nonce fixes of accounts, and collected whitehat bundle txs.
+// Keys are case-insensitive and entries expire after the durations of the StateOptions, except for the
+// webhook delivery queue, whose deliveries are kept until they are deleted.
+// Operations touching multiple keys, or reading and then writing a key, are atomic.
+type StateStore interface {
+	Ping(ctx context.Context) error
+
+	SetTxSentToRelay(ctx context.Context, txHash string) error
+	GetTxSentToRelay(ctx context.Context, txHash string) (timeSent time.Time, found bool, err error)
+
+	// SetTxCancelled marks a tx sent to the relay as cancelled by a cancel-tx, it expires with TxSentToRelayExpiry
+	SetTxCancelled(ctx context.Context, txHash string) error
+	GetTxCancelled(ctx context.Context, txHash string) (found bool, err error)
+
+	SetTxHashForSenderAndNonce(ctx context.Context, txFrom string, nonce uint64, txHash string) error
+	GetTxHashForSenderAndNonce(ctx context.Context, txFrom string, nonce uint64) (txHash string, found bool, err error)
+
+	SetNonceFixForAccount(ctx context.Context, txFrom string, numTimesSent uint64) error
+	// IncNonceFixForAccount increments the times sent of an existing nonce-fix and returns the new value
+	IncNonceFixForAccount(ctx context.Context, txFrom string) (numTimesSent uint64, found bool, err error)
+	DelNonceFixForAccount(ctx context.Context, txFrom string) error
+	GetNonceFixForAccount(ctx context.Context, txFrom string) (numTimesSent uint64, found bool, err error)
+
+	SetSenderAndNonceOfTxHash(ctx context.Context, txHash string, txFrom string, txNonce uint64) error
+	GetSenderOfTxHash(ctx context.Context, txHash string) (txSender string, found bool, err error)
+	GetNonceOfTxHash(ctx context.Context, txHash string) (txNonce uint64, found bool, err error)
+
+	AddTxToWhitehatBundle(ctx context.Context, bundleId string, signedTx string) error
+	GetWhitehatBundleTx(ctx context.Context, bundleId string) ([]string, error)
+	DelWhitehatBundleTx(ctx context.Context, bundleId string) error
+
+	// SetSenderMaxNonce stores the nonce only if it is higher than the stored one
+	SetSenderMaxNonce(ctx context.Context, txFrom string, nonce uint64, blockRange int) error
+	GetSenderMaxNonce(ctx context.Context, txFrom string) (senderMaxNonce uint64, found bool, err error)
+	DelSenderMaxNonce(ctx context.Context, txFrom string) error
+
+	SetBlockedTxHash(ctx context.Context, txHash string, returnValue string) error
+	GetBlockedTxHash(ctx context.Context, txHash string) (returnValue string, found bool, err error)
+
+	// AddWebhookDelivery queues a delivery, which is due at the given time
+	AddWebhookDelivery(ctx context.Context, delivery string, due time.Time) error
+	// ClaimWebhookDeliveries returns up to limit deliveries which are due, and makes them due again at leaseUntil,
+	// so deliveries of an instance which stops before deleting them are retried
+	ClaimWebhookDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]string, error)
+	DelWebhookDelivery(ctx context.Context, delivery string) error
+
+	// AddMempoolBroadcast schedules a broadcast of a tx to the mempool, which is due at the given time
+	AddMempoolBroadcast(ctx context.Context, broadcast string, due time.Time) error
+	// ClaimMempoolBroadcasts returns up to limit broadcasts which are due, and makes them due again at leaseUntil,
+	// so broadcasts of an instance which stops before deleting them are picked up by another one
+	ClaimMempoolBroadcasts(ctx context.Context, now, leaseUntil time.Time, limit int) ([]string, error)
+	DelMempoolBroadcast(ctx context.Context, broadcast string) error
+}
+
+var (
+	_ StateStore = (*RedisState)(nil)
+	_ StateStore = (*MemState)(nil)
+)
+
+// StateOptions sets the key namespace and the expiries of a StateStore
+type StateOptions struct {
+	// Namespace is added to all keys, so instances of different networks or environments can share a Redis
+	Namespace string
+
+	TxSentToRelayExpiry           time.Duration
+	TxHashForSenderAndNonceExpiry time.Duration
+	NonceFixForAccountExpiry      time.Duration
+	// SenderAndNonceOfTxHashExpiry applies to both the sender and the nonce of a tx hash
+	SenderAndNonceOfTxHashExpiry time.Duration
+	WhitehatBundleExpiry         time.Duration
+	BlockedTxHashExpiry          time.Duration
+
+	// The pending max nonce of a sender is kept for the blockRange of the tx, or DefaultBlockRange blocks
+	BlockTime         time.Duration
+	DefaultBlockRange int
+}
+
+var DefaultStateOptions = StateOptions{
+	TxSentToRelayExpiry:           10 * time.Minute,
+	TxHashForSenderAndNonceExpiry: 10 * time.Minute,
+	NonceFixForAccountExpiry:      10 * time.Minute,
+	SenderAndNonceOfTxHashExpiry:  10 * time.Minute,
+	WhitehatBundleExpiry:          10 * time.Minute,
+	BlockedTxHashExpiry:           10 * time.Minute,
+	BlockTime:                     12 * time.Second,
+	DefaultBlockRange:             25, // the default of the relay
+}
+
+// WithDefaults sets zero values from DefaultStateOptions
+func (o StateOptions) WithDefaults() StateOptions {
+	d := DefaultStateOptions
+	o.TxSentToRelayExpiry = valueOrDefault(o.TxSentToRelayExpiry, d.TxSentToRelayExpiry)
+	o.TxHashForSenderAndNonceExpiry = valueOrDefault(o.TxHashForSenderAndNonceExpiry, d.TxHashForSenderAndNonceExpiry)
+	o.NonceFixForAccountExpiry = valueOrDefault(o.NonceFixForAccountExpiry, d.NonceFixForAccountExpiry)
+	o.SenderAndNonceOfTxHashExpiry = valueOrDefault(o.SenderAndNonceOfTxHashExpiry, d.SenderAndNonceOfTxHashExpiry)
+	o.WhitehatBundleExpiry = valueOrDefault(o.WhitehatBundleExpiry, d.WhitehatBundleExpiry)
+	o.BlockedTxHashExpiry = valueOrDefault(o.BlockedTxHashExpiry, d.BlockedTxHashExpiry)
+	o.BlockTime = valueOrDefault(o.BlockTime, d.BlockTime)
+	o.DefaultBlockRange = valueOrDefault(o.DefaultBlockRange, d.DefaultBlockRange)
+	return o
+}
+
+// Validate checks the namespace and that all expiries are at least a second, which Redis needs for the
+// second precision of some entries
+func (o StateOptions) Validate() error {
+	if strings.ContainsAny(o.Namespace, " \t\n{}") {
+		return errors.New("state namespace must not contain whitespace or braces")
+	}
+	for name, expiry := range map[string]time.Duration{
+		"TxSentToRelayExpiry":           o.TxSentToRelayExpiry,
+		"TxHashForSenderAndNonceExpiry": o.TxHashForSenderAndNonceExpiry,
+		"NonceFixForAccountExpiry":      o.NonceFixForAccountExpiry,
+		"SenderAndNonceOfTxHashExpiry":  o.SenderAndNonceOfTxHashExpiry,
+		"WhitehatBundleExpiry":          o.WhitehatBundleExpiry,
+		"BlockedTxHashExpiry":           o.BlockedTxHashExpiry,
+		"BlockTime":                     o.BlockTime,
+	} {
+		if expiry < time.Second {
+			return fmt.Errorf("state option %s must be at least 1s, got %s", name, expiry)
+		}
+	}
+	if o.DefaultBlockRange < 1 {
+		return fmt.Errorf("state option DefaultBlockRange must be positive, got %d", o.DefaultBlockRange)
+	}
+	return nil
+}
+
+// SenderMaxNonceExpiry keeps the max nonce for the blockRange of the tx, or the default block range if unset
+func (o StateOptions) SenderMaxNonceExpiry(blockRange int) time.Duration {
+	if blockRange <= 0 {
+		blockRange = o.DefaultBlockRange
+	}
+	return time.Duration(blockRange) * o.BlockTime
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+)
+
+const tracerName = "github.com/flashbots/rpc-endpoint/server"
+
+// TracingConfiguration sets up OpenTelemetry tracing, which is disabled if Exporter is empty
+type TracingConfiguration struct {
+	Exporter     string  // otlp or stdout
+	OTLPEndpoint string  // host:port of the OTLP/HTTP collector, OTEL_EXPORTER_OTLP_ENDPOINT is used if empty
+	SampleRatio  float64 // of requests, the sampled flag of incoming traces is ignored
+}
+
+// SetupTracing sets the global tracer provider and the W3C trace context propagator, and returns a func to flush
+// and stop the exporter. Without an exporter, the trace context of requests is still passed on to the node and relay.
+func SetupTracing(cfg TracingConfiguration, version string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case TracingExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.OTLPEndpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, errors.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "creating tracing exporter failed")
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("rpc-endpoint"),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(newTracingSampler(cfg.SampleRatio)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// newTracingSampler samples new traces and traces continued from a client by the ratio, so clients can't force
+// sampling with the sampled flag or a chosen trace id. Spans of a sampled local parent are always sampled.
+func newTracingSampler(ratio float64) sdktrace.Sampler {
+	remote := randomRatioSampler{ratio: ratio}
+	return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio),
+		sdktrace.WithRemoteParentSampled(remote),
+		sdktrace.WithRemoteParentNotSampled(remote),
+	)
+}
+
+// randomRatioSampler samples by the ratio independent of the trace id, which is set by the client for remote parents
+type randomRatioSampler struct {
+	ratio float64
+}
+
+func (s randomRatioSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	decision := sdktrace.Drop
+	if rand.Float64() < s.ratio {
+		decision = sdktrace.RecordAndSample
+	}
+	return sdktrace.SamplingResult{
+		Decision:   decision,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+func (s randomRatioSampler) Description() string {
+	return fmt.Sprintf("RandomRatioSampler{%g}", s.ratio)
+}
+
+// startSpan starts a span of the current trace with the global tracer provider, which is a no-op unless tracing
+// is set up
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// extractTraceContext returns ctx with the trace context of the incoming request headers
+func extractTraceContext(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// injectTraceContext adds the trace context of ctx to the headers of an outgoing request
+func injectTraceContext(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// redisTracingHook adds a span for each Redis command, and pipeline, of a RedisState
+type redisTracingHook struct{}
+
+var _ redis.Hook = redisTracingHook{}
+
+func (redisTracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = startSpan(ctx, "redis."+cmd.Name(), semconv.DBSystemRedis)
+	return ctx, nil
+}
+
+func (redisTracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endSpan(trace.SpanFromContext(ctx), redisSpanError(cmd.Err()))
+	return nil
+}
+
+func (redisTracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+	}
+	ctx, _ = startSpan(ctx, "redis.pipeline", semconv.DBSystemRedis, attribute.String("db.redis.commands", strings.Join(names, ",")))
+	return ctx, nil
+}
+
+func (redisTracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = redisSpanError(cmd.Err()); err != nil {
+			break
+		}
+	}
+	endSpan(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+// redisSpanError ignores redis.Nil, a missing key is not an error
+func redisSpanError(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTestTracing(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return recorder
+}
+
+func spanNames(spans []sdktrace.ReadOnlySpan) []string {
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name()
+	}
+	return names
+}
+
+func TestTracingPropagatesTraceContext(t *testing.T) {
+	recorder := setupTestTracing(t)
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	var nodeTraceparent string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nodeTraceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer node.Close()
+
+	wrec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/?originId=test", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	var rw http.ResponseWriter = wrec
+	rh := NewRpcRequestHandler(log.New(), &rw, req, &Chain{proxyUrls: []string{node.URL}, builderNameProvider: staticBuilderNames{}}, 0, nil, nil, nil, nil, nil, nil, nil, RequestTimeouts{}, nil, nil)
+	rh.process()
+	require.Contains(t, wrec.Body.String(), "0x10")
+
+	// The incoming trace is continued, and propagated to the node
+	spans := recorder.Ended()
+	require.Equal(t, []string{"RpcRequest.proxyRequestRead", "RpcRequest.ProcessRequest.proxy", "RpcRequestHandler.process"}, spanNames(spans))
+	for _, span := range spans {
+		require.Equal(t, traceID, span.SpanContext().TraceID().String())
+	}
+	require.Contains(t, nodeTraceparent, traceID)
+	require.Contains(t, spans[2].Attributes(), attributeString("originId", "test"))
+	require.Contains(t, spans[2].Attributes(), attributeString("rpc.method", "eth_blockNumber"))
+}
+
+func TestTracingRedisState(t *testing.T) {
+	recorder := setupTestTracing(t)
+	redisServer, err := miniredis.Run()
+	require.NoError(t, err)
+	defer redisServer.Close()
+	state, err := NewRedisState(redisServer.Addr(), DefaultStateOptions)
+	require.NoError(t, err)
+
+	ctx, span := startSpan(context.Background(), "test")
+	require.NoError(t, state.SetSenderAndNonceOfTxHash(ctx, "0xFoo", "0xBar", 1))
+	_, found, err := state.GetBlockedTxHash(ctx, "0xFoo")
+	require.NoError(t, err)
+	require.False(t, found)
+	span.End()
+
+	spans := recorder.Ended()
+	require.Equal(t, []string{"redis.pipeline", "redis.get", "test"}, spanNames(spans)[len(spans)-3:])
+	for _, s := range spans[len(spans)-3:] {
+		require.Equal(t, span.SpanContext().TraceID(), s.SpanContext().TraceID())
+	}
+}
+
+func TestTracingSamplerIgnoresRemoteSampledFlag(t *testing.T) {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	parentContext := func(remote bool, flags trace.TraceFlags) context.Context {
+		return trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: flags,
+			Remote:     remote,
+		}))
+	}
+	shouldSample := func(sampler sdktrace.Sampler, ctx context.Context) sdktrace.SamplingDecision {
+		return sampler.ShouldSample(sdktrace.SamplingParameters{ParentContext: ctx, TraceID: traceID, Name: "test"}).Decision
+	}
+
+	// A client can't force sampling, nor prevent it
+	require.Equal(t, sdktrace.Drop, shouldSample(newTracingSampler(0), parentContext(true, trace.FlagsSampled)))
+	require.Equal(t, sdktrace.RecordAndSample, shouldSample(newTracingSampler(1), parentContext(true, 0)))
+
+	// Spans of sampled local parents are sampled
+	require.Equal(t, sdktrace.RecordAndSample, shouldSample(newTracingSampler(0), parentContext(false, trace.FlagsSampled)))
+}
+
+func TestTracingCustomUpstreamWithoutTraceContext(t *testing.T) {
+	setupTestTracing(t)
+	var traceparent string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer node.Close()
+
+	ctx, span := startSpan(context.Background(), "test")
+	defer span.End()
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+
+	res, err := NewRPCProxyClient(log.New(), node.URL, 1, 0).ProxyRequest(ctx, body)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Contains(t, traceparent, span.SpanContext().TraceID().String())
+
+	// The guarded client refuses to connect to the test node on loopback
+	custom := NewCustomRPCProxyClient(log.New(), node.URL, 1, 0, NewUpstreamGuard(nil)).(*rpcProxyClient)
+	custom.httpClient = http.Client{}
+	res, err = custom.ProxyRequest(ctx, body)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Empty(t, traceparent)
+}
+
+func attributeString(key, value string) attribute.KeyValue {
+	return attribute.String(key, value)
+}
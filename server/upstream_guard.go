@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+// customMempoolTimeout is the timeout of requests to a mempoolRPC set by the client
+const customMempoolTimeout = 10 * time.Second
+
+var (
+	ErrUpstreamNotAllowed = errors.New("upstream is not allowed")
+
+	// blockedUpstreamCidrBlocks can't be used as custom upstreams: the private ranges of fingerprint.go,
+	// and other ranges which are not publicly routable, including cloud metadata services
+	blockedUpstreamCidrBlocks      []*net.IPNet
+	blockedUpstreamCidrBlockString = []string{
+		"0.0.0.0/8",         // this network
+		"100.64.0.0/10",     // carrier-grade NAT
+		"192.0.0.0/24",      // IETF protocol assignments
+		"198.18.0.0/15",     // benchmarking
+		"224.0.0.0/4",       // multicast
+		"240.0.0.0/4",       // reserved and broadcast
+		"::/128",            // unspecified IPv6
+		"64:ff9b::/96",      // NAT64, may map to private IPv4 addresses
+		"fd00:ec2::254/128", // AWS metadata service IPv6 (also in fc00::/7)
+		"ff00::/8",          // multicast IPv6
+	}
+)
+
+func init() {
+	for _, block := range append(append([]string{}, rfc1918cidrBlockStrings...), blockedUpstreamCidrBlockString...) {
+		_, cidr, err := net.ParseCIDR(block)
+		if err != nil {
+			panic(err)
+		}
+		blockedUpstreamCidrBlocks = append(blockedUpstreamCidrBlocks, cidr)
+	}
+}
+
+// isBlockedUpstreamIP returns whether the ip is in a private, link-local, metadata or otherwise reserved range
+func isBlockedUpstreamIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4 // IPv4-mapped IPv6 addresses are checked as IPv4
+	}
+	for _, block := range blockedUpstreamCidrBlocks {
+		if block.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpstreamGuard checks the upstreams set by clients with the url and mempoolRPC params, so they can't make the
+// endpoint send requests to internal addresses. Urls are checked when the request is received, and the address
+// is checked again when connecting, in case the DNS answer changed since.
+// A nil UpstreamGuard allows all public hosts.
+type UpstreamGuard struct {
+	allowedHosts map[string]bool // if not empty, only these hosts can be used
+	lookupIPAddr func(ctx context.Context, host string) ([]net.IPAddr, error)
+	ethClients   *EthClientPool
+	httpClients  sync.Map // timeout -> *http.Client
+}
+
+// upstreamHostRegex matches host names, which are compared to the host of urls without port
+var upstreamHostRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// ParseUpstreamHosts splits a comma-separated list of hosts or IP addresses, without scheme or port, in lowercase
+func ParseUpstreamHosts(s string) ([]string, error) {
+	hosts := parseCommaList(strings.ToLower(s))
+	for _, host := range hosts {
+		if net.ParseIP(host) == nil && (len(host) > 253 || !upstreamHostRegex.MatchString(host)) {
+			return nil, errors.Errorf("invalid upstream host %q", host)
+		}
+	}
+	return hosts, nil
+}
+
+func NewUpstreamGuard(allowedHosts []string) *UpstreamGuard {
+	g := &UpstreamGuard{
+		allowedHosts: make(map[string]bool),
+		lookupIPAddr: net.DefaultResolver.LookupIPAddr,
+	}
+	for _, host := range allowedHosts {
+		g.allowedHosts[strings.ToLower(host)] = true
+	}
+	g.ethClients = NewEthClientPool(DefaultCustomEthClientPoolSize, DefaultCustomEthClientTTL, func(ctx context.Context, url string) (*ethclient.Client, error) {
+		return g.DialEthClient(ctx, url, customMempoolTimeout)
+	})
+	return g
+}
+
+// CheckURL returns an error if the url is not a http(s) url of an allowed host, or if the host resolves to a
+// blocked address
+func (g *UpstreamGuard) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.Wrap(ErrUpstreamNotAllowed, "invalid url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Wrapf(ErrUpstreamNotAllowed, "scheme %q", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return errors.Wrap(ErrUpstreamNotAllowed, "no host")
+	}
+	if g != nil && len(g.allowedHosts) > 0 && !g.allowedHosts[host] {
+		return errors.Wrapf(ErrUpstreamNotAllowed, "host %s is not in the allowlist", host)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if isBlockedUpstreamIP(ip) {
+			return errors.Wrapf(ErrUpstreamNotAllowed, "address %s", ip)
+		}
+		return nil
+	}
+	lookupIPAddr := net.DefaultResolver.LookupIPAddr
+	if g != nil {
+		lookupIPAddr = g.lookupIPAddr
+	}
+	addrs, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return errors.Wrapf(ErrUpstreamNotAllowed, "resolving %s failed", host)
+	}
+	for _, addr := range addrs {
+		if isBlockedUpstreamIP(addr.IP) {
+			return errors.Wrapf(ErrUpstreamNotAllowed, "host %s resolves to %s", host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// HTTPClient returns a client which refuses to connect to blocked addresses. There is one client per timeout,
+// so connections are reused across requests.
+func (g *UpstreamGuard) HTTPClient(timeout time.Duration) *http.Client {
+	if g == nil {
+		return newGuardedHTTPClient(timeout)
+	}
+	if client, ok := g.httpClients.Load(timeout); ok {
+		return client.(*http.Client)
+	}
+	client, _ := g.httpClients.LoadOrStore(timeout, newGuardedHTTPClient(timeout))
+	return client.(*http.Client)
+}
+
+func newGuardedHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isBlockedUpstreamIP(ip) {
+				return errors.Wrapf(ErrUpstreamNotAllowed, "connecting to %s", address)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// No proxy from the environment, it would be connected to instead of the upstream
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// DialEthClient checks the url and connects to it with the guarded HTTPClient
+func (g *UpstreamGuard) DialEthClient(ctx context.Context, rawURL string, timeout time.Duration) (*ethclient.Client, error) {
+	if err := g.CheckURL(ctx, rawURL); err != nil {
+		return nil, err
+	}
+	rpcClient, err := rpc.DialOptions(ctx, rawURL, rpc.WithHTTPClient(g.HTTPClient(timeout)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "dialing %s failed", rawURL)
+	}
+	return ethclient.NewClient(rpcClient), nil
+}
+
+// EthClient returns a pooled client of the url, which is checked when it is dialed.
+// A nil UpstreamGuard dials a new client.
+func (g *UpstreamGuard) EthClient(ctx context.Context, rawURL string) (*ethclient.Client, error) {
+	if g == nil {
+		return g.DialEthClient(ctx, rawURL, customMempoolTimeout)
+	}
+	return g.ethClients.Get(ctx, rawURL)
+}
+
+// ReportEthClientResult tracks the health of the pooled client of the url
+func (g *UpstreamGuard) ReportEthClientResult(rawURL string, err error) {
+	if g != nil {
+		g.ethClients.ReportResult(rawURL, err)
+	}
+}
+
+// Close closes the pooled clients
+func (g *UpstreamGuard) Close() {
+	if g != nil {
+		g.ethClients.Close()
+		g.httpClients.Range(func(_, client any) bool {
+			client.(*http.Client).CloseIdleConnections()
+			return true
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamGuardCheckURL(t *testing.T) {
+	guard := NewUpstreamGuard(nil)
+	guard.lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if host == "internal.example.com" {
+			return []net.IPAddr{{IP: net.ParseIP("8.8.8.8")}, {IP: net.ParseIP("10.0.0.5")}}, nil
+		}
+		return []net.IPAddr{{IP: net.ParseIP("8.8.8.8")}}, nil
+	}
+
+	for _, tc := range []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://rpc.example.com", true},
+		{"http://8.8.8.8:8545", true},
+		{"https://internal.example.com", false},
+		{"http://127.0.0.1:8545", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://100.100.100.200", false},
+		{"http://[::1]:8545", false},
+		{"http://[::ffff:10.0.0.1]", false},
+		{"http://[fd00:ec2::254]", false},
+		{"ftp://8.8.8.8", false},
+		{"file:///etc/passwd", false},
+		{"//8.8.8.8", false},
+	} {
+		err := guard.CheckURL(context.Background(), tc.url)
+		if tc.allowed {
+			require.NoError(t, err, tc.url)
+		} else {
+			require.ErrorIs(t, err, ErrUpstreamNotAllowed, tc.url)
+		}
+	}
+
+	allowlist := NewUpstreamGuard([]string{"RPC.example.com"})
+	allowlist.lookupIPAddr = guard.lookupIPAddr
+	require.NoError(t, allowlist.CheckURL(context.Background(), "https://rpc.example.com/path"))
+	require.ErrorIs(t, allowlist.CheckURL(context.Background(), "https://other.example.com"), ErrUpstreamNotAllowed)
+}
+
+// The address is checked again when connecting, in case the host resolves to another address than when it was checked
+func TestParseUpstreamHosts(t *testing.T) {
+	hosts, err := ParseUpstreamHosts(" RPC.example.com, 8.8.8.8,,2001:db8::1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"rpc.example.com", "8.8.8.8", "2001:db8::1"}, hosts)
+
+	for _, invalid := range []string{"https://rpc.example.com", "rpc.example.com:8545", "rpc.example.com/path", "-rpc.example.com", "*.example.com"} {
+		_, err = ParseUpstreamHosts(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestUpstreamGuardHTTPClient(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	_, err := NewUpstreamGuard(nil).HTTPClient(time.Second).Get(backend.URL)
+	require.ErrorIs(t, err, ErrUpstreamNotAllowed)
+
+	res, err := http.Get(backend.URL)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	// Clients are reused per timeout, so are their connections
+	guard := NewUpstreamGuard(nil)
+	require.Same(t, guard.HTTPClient(time.Second), guard.HTTPClient(time.Second))
+	require.Same(t, guard.HTTPClient(time.Second).Transport, NewCustomRPCProxyClient(log.New(), backend.URL, 1, 0, guard).(*rpcProxyClient).httpClient.Transport)
+	require.NotSame(t, guard.HTTPClient(time.Second), guard.HTTPClient(2*time.Second))
+	guard.Close()
+}
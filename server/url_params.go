@@ -38,6 +38,7 @@ type URLParameters struct {
 	fast                     bool
 	blockRange               int
 	auctionTimeout           uint64
+	presetApplied            bool
 	rawNormalizedQueryParams map[string][]string
 }
 
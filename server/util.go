@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
@@ -15,6 +16,7 @@ import (
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/flashbots/rpc-endpoint/types"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func Min(a uint64, b uint64) uint64 {
@@ -31,6 +33,15 @@ func Max(a uint64, b uint64) uint64 {
 	return b
 }
 
+// valueOrDefault returns defaultValue if value is the zero value
+func valueOrDefault[T comparable](value, defaultValue T) T {
+	var zero T
+	if value == zero {
+		return defaultValue
+	}
+	return value
+}
+
 func GetTx(rawTxHex string) (*ethtypes.Transaction, error) {
 	if len(rawTxHex) < 2 {
 		return nil, errors.New("invalid raw transaction")
@@ -75,9 +86,17 @@ func GetSenderFromRawTx(tx *ethtypes.Transaction) (string, error) {
 	return from, nil
 }
 
-func GetTxStatus(txHash string) (*types.PrivateTxApiResponse, error) {
-	privTxApiUrl := fmt.Sprintf("%s/tx/%s", ProtectTxApiHost, txHash)
-	resp, err := http.Get(privTxApiUrl)
+// GetTxStatus looks up the status of a tx on the tx status api of a chain, e.g. ProtectTxApiHost
+func GetTxStatus(ctx context.Context, txApiHost, txHash string) (res *types.PrivateTxApiResponse, err error) {
+	ctx, span := startSpan(ctx, "GetTxStatus", attribute.String("tx.hash", txHash))
+	defer func() { endSpan(span, err) }()
+
+	privTxApiUrl := fmt.Sprintf("%s/tx/%s", txApiHost, txHash)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, privTxApiUrl, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "privTxApi request failed for "+txHash)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "privTxApi call failed for "+txHash)
 	}
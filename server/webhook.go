@@ -0,0 +1,347 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/flashbots/rpc-endpoint/metrics"
+)
+
+// Lifecycle events of private txs, which are sent to the webhook of their origin
+const (
+	WebhookEventRelayed   = "relayed"   // the tx was sent to the relay
+	WebhookEventCancelled = "cancelled" // a cancel-tx of the tx was sent to the relay
+	WebhookEventIncluded  = "included"  // the tx was included, its execution may have reverted
+	WebhookEventFailed    = "failed"    // the tx failed or expired without being included
+)
+
+var webhookEvents = []string{WebhookEventRelayed, WebhookEventCancelled, WebhookEventIncluded, WebhookEventFailed}
+
+// Headers of webhook calls
+const (
+	WebhookIdHeader        = "X-Webhook-Id"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// Defaults for the webhook notifier
+var (
+	DefaultWebhookPollInterval = time.Second
+	DefaultWebhookBatchSize    = 100
+	DefaultWebhookTimeout      = 10 * time.Second
+	DefaultWebhookLease        = time.Minute // must be longer than the timeout
+	DefaultWebhookMaxAttempts  = 10
+	DefaultWebhookBackoff      = 5 * time.Second
+	DefaultWebhookMaxBackoff   = time.Hour
+)
+
+// WebhookConfig is the webhook of a customer in the customer config
+type WebhookConfig struct {
+	URL    string   `yaml:"url" json:"url"`
+	Secret string   `yaml:"secret" json:"-"`                          // key of the HMAC-SHA256 signature of calls
+	Events []string `yaml:"events,omitempty" json:"events,omitempty"` // all events if empty
+}
+
+func (c WebhookConfig) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url %q must be an http(s) url", c.URL)
+	}
+	if c.Secret == "" {
+		return errors.New("webhook secret is required")
+	}
+	for _, event := range c.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("unknown webhook event %q", event)
+		}
+	}
+	return nil
+}
+
+func (c WebhookConfig) subscribes(event string) bool {
+	return len(c.Events) == 0 || slices.Contains(c.Events, event)
+}
+
+// WebhookEvent is the body of a webhook call
+type WebhookEvent struct {
+	Id    uuid.UUID `json:"id"` // derived from the raw tx entry and the event, to deduplicate retried or repeated calls
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// OriginId is the originId param of the request, which is set by the client: anyone can send txs with the
+	// origin id of a customer, so the events only tell about txs sent with that origin id.
+	OriginId     string    `json:"originId"`
+	Chain        string    `json:"chain,omitempty"`
+	RawTxEntryId uuid.UUID `json:"rawTxEntryId"`
+	TxHash       string    `json:"txHash"`
+	// Hash of the cancel-tx of cancelled events
+	CancelTxHash string `json:"cancelTxHash,omitempty"`
+	// Inclusion status of included and failed events: included, reverted, failed or expired
+	InclusionStatus string `json:"inclusionStatus,omitempty"`
+	BlockNumber     int64  `json:"blockNumber,omitempty"`
+}
+
+// webhookDelivery is a queued call of a webhook
+type webhookDelivery struct {
+	Event   WebhookEvent `json:"event"`
+	Attempt int          `json:"attempt"` // number of failed attempts
+}
+
+// WebhookNotifier sends lifecycle events of private txs to the webhooks of their origins. Events are queued in
+// the StateStore, so they are retried with exponential backoff by any instance sharing the Redis.
+type WebhookNotifier struct {
+	logger     log.Logger
+	state      StateStore
+	httpClient http.Client
+
+	mu       sync.RWMutex
+	webhooks map[string]WebhookConfig // by origin id, replaced on reload of the customer config
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewWebhookNotifier(logger log.Logger, state StateStore, webhooks map[string]WebhookConfig) *WebhookNotifier {
+	return &WebhookNotifier{
+		logger:     logger,
+		state:      state,
+		httpClient: http.Client{Timeout: DefaultWebhookTimeout},
+		webhooks:   webhooks,
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// SetWebhooks replaces the webhooks, queued events of removed webhooks are dropped
+func (n *WebhookNotifier) SetWebhooks(webhooks map[string]WebhookConfig) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.webhooks = webhooks
+}
+
+func (n *WebhookNotifier) webhook(originId string) (WebhookConfig, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	config, ok := n.webhooks[originId]
+	return config, ok
+}
+
+// Notify queues the event if the origin has a webhook for it. A nil WebhookNotifier does nothing.
+func (n *WebhookNotifier) Notify(ctx context.Context, event WebhookEvent) {
+	if n == nil || event.OriginId == "" {
+		return
+	}
+	config, ok := n.webhook(event.OriginId)
+	if !ok || !config.subscribes(event.Event) {
+		return
+	}
+	event.Id = webhookEventId(event.RawTxEntryId, event.Event)
+	event.Time = Now()
+	delivery, err := json.Marshal(webhookDelivery{Event: event})
+	if err != nil {
+		n.logger.Error("[WebhookNotifier] marshal failed", "error", err)
+		return
+	}
+	if err = n.state.AddWebhookDelivery(ctx, string(delivery), event.Time); err != nil {
+		metrics.IncWebhookQueueErr()
+		n.logger.Error("[WebhookNotifier] AddWebhookDelivery failed", "error", err, "originId", event.OriginId, "txHash", event.TxHash)
+		return
+	}
+	metrics.IncWebhookEvent(event.OriginId, event.Event)
+}
+
+// webhookEventId is the same for each event of a raw tx entry, e.g. if an outcome is notified by two replicas
+func webhookEventId(rawTxEntryId uuid.UUID, event string) uuid.UUID {
+	return uuid.NewSHA1(rawTxEntryId, []byte(event))
+}
+
+func (n *WebhookNotifier) Start() {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ticker := time.NewTicker(DefaultWebhookPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := n.Deliver(context.Background()); err != nil {
+					metrics.IncWebhookQueueErr()
+					n.logger.Error("[WebhookNotifier] deliver failed", "error", err)
+				}
+			case <-n.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for running deliveries to finish
+func (n *WebhookNotifier) Stop() {
+	if n == nil {
+		return
+	}
+	n.stopOnce.Do(func() { close(n.stopCh) })
+	n.wg.Wait()
+}
+
+// Deliver calls the webhooks of the due events, in parallel. Failed calls are retried with backoff, and dropped
+// after DefaultWebhookMaxAttempts.
+func (n *WebhookNotifier) Deliver(ctx context.Context) error {
+	n.mu.RLock()
+	configured := len(n.webhooks) > 0
+	n.mu.RUnlock()
+	if !configured {
+		return nil
+	}
+	now := Now()
+	deliveries, err := n.state.ClaimWebhookDeliveries(ctx, now, now.Add(DefaultWebhookLease), DefaultWebhookBatchSize)
+	if err != nil {
+		return errors.Wrap(err, "ClaimWebhookDeliveries failed")
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.deliver(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (n *WebhookNotifier) deliver(ctx context.Context, rawDelivery string) {
+	var delivery webhookDelivery
+	if err := json.Unmarshal([]byte(rawDelivery), &delivery); err != nil {
+		n.logger.Error("[WebhookNotifier] invalid delivery", "error", err, "delivery", rawDelivery)
+		n.delete(ctx, rawDelivery)
+		return
+	}
+	event := delivery.Event
+	config, ok := n.webhook(event.OriginId)
+	if !ok {
+		metrics.ObserveWebhookDelivery(metrics.OtherLabel, "dropped", 0)
+		n.delete(ctx, rawDelivery)
+		return
+	}
+
+	start := time.Now()
+	err := n.call(ctx, config, event)
+	timeNeeded := time.Since(start)
+	if err == nil {
+		metrics.ObserveWebhookDelivery(event.OriginId, "success", timeNeeded)
+		n.delete(ctx, rawDelivery)
+		return
+	}
+
+	delivery.Attempt++
+	if delivery.Attempt >= DefaultWebhookMaxAttempts {
+		n.logger.Error("[WebhookNotifier] delivery failed, dropping event", "error", err, "originId", event.OriginId, "event", event.Event, "txHash", event.TxHash, "attempts", delivery.Attempt)
+		metrics.ObserveWebhookDelivery(event.OriginId, "failed", timeNeeded)
+		n.delete(ctx, rawDelivery)
+		return
+	}
+	n.logger.Info("[WebhookNotifier] delivery failed, retrying", "error", err, "originId", event.OriginId, "event", event.Event, "txHash", event.TxHash, "attempt", delivery.Attempt)
+	metrics.ObserveWebhookDelivery(event.OriginId, "retry", timeNeeded)
+	retry, err := json.Marshal(delivery)
+	if err != nil {
+		n.logger.Error("[WebhookNotifier] marshal failed", "error", err)
+		return
+	}
+	// The retry is added before the delivery is deleted, so the event is not lost if this fails in between
+	if err = n.state.AddWebhookDelivery(ctx, string(retry), Now().Add(webhookBackoff(delivery.Attempt))); err != nil {
+		metrics.IncWebhookQueueErr()
+		n.logger.Error("[WebhookNotifier] AddWebhookDelivery failed", "error", err)
+		return // retried after the lease
+	}
+	n.delete(ctx, rawDelivery)
+}
+
+func (n *WebhookNotifier) delete(ctx context.Context, rawDelivery string) {
+	if err := n.state.DelWebhookDelivery(ctx, rawDelivery); err != nil {
+		metrics.IncWebhookQueueErr()
+		n.logger.Error("[WebhookNotifier] DelWebhookDelivery failed", "error", err)
+	}
+}
+
+// call posts the event to the webhook, and fails unless it responds with a 2xx status
+func (n *WebhookNotifier) call(ctx context.Context, config WebhookConfig, event WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIdHeader, event.Id.String())
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, WebhookSignature(config.Secret, timestamp, body))
+	res, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// WebhookSignature returns the signature header of a call: sha256= and the hex HMAC-SHA256 of the timestamp, a dot
+// and the body. Receivers should compare it in constant time and reject old timestamps.
+func WebhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff doubles the delay of each retry, up to DefaultWebhookMaxBackoff
+func webhookBackoff(attempt int) time.Duration {
+	backoff := DefaultWebhookBackoff
+	for i := 1; i < attempt && backoff < DefaultWebhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, DefaultWebhookMaxBackoff)
+}
+
+// inclusionWebhookEvent returns the event of an inclusion outcome
+func inclusionWebhookEvent(chain string, tx database.RelayedTx, outcome database.InclusionOutcome) WebhookEvent {
+	event := WebhookEvent{
+		Event:           WebhookEventFailed,
+		OriginId:        tx.OriginId,
+		Chain:           chain,
+		RawTxEntryId:    tx.RawTxEntryId,
+		TxHash:          tx.TxHash,
+		InclusionStatus: string(outcome.Status),
+		BlockNumber:     outcome.BlockNumber,
+	}
+	if outcome.BlockNumber > 0 {
+		event.Event = WebhookEventIncluded
+	}
+	return event
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/flashbots/rpc-endpoint/database"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookReceiver records the calls of a webhook, and responds with the next status
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	calls    []*http.Request
+	bodies   [][]byte
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	rcv.calls = append(rcv.calls, req)
+	rcv.bodies = append(rcv.bodies, body)
+	status := http.StatusOK
+	if len(rcv.statuses) > 0 {
+		status, rcv.statuses = rcv.statuses[0], rcv.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	defer func() { Now = time.Now }()
+	now := time.Unix(1700000000, 0)
+	Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	receiver := &webhookReceiver{statuses: []int{http.StatusInternalServerError}}
+	webhookServer := httptest.NewServer(receiver)
+	defer webhookServer.Close()
+	state := newTestMemState(t)
+	notifier := NewWebhookNotifier(log.New(), state, map[string]WebhookConfig{
+		"wallet": {URL: webhookServer.URL, Secret: "secret", Events: []string{WebhookEventRelayed}},
+	})
+
+	rawTxEntryId := uuid.New()
+	notifier.Notify(ctx, WebhookEvent{Event: WebhookEventRelayed, OriginId: "wallet", RawTxEntryId: rawTxEntryId, TxHash: "0x01"})
+	notifier.Notify(ctx, WebhookEvent{Event: WebhookEventCancelled, OriginId: "wallet", RawTxEntryId: rawTxEntryId, TxHash: "0x01"})
+	notifier.Notify(ctx, WebhookEvent{Event: WebhookEventRelayed, OriginId: "other", TxHash: "0x02"})
+
+	// The first call fails, and is retried after the backoff
+	require.NoError(t, notifier.Deliver(ctx))
+	require.Len(t, receiver.calls, 1)
+	require.NoError(t, notifier.Deliver(ctx))
+	require.Len(t, receiver.calls, 1)
+	now = now.Add(DefaultWebhookBackoff)
+	require.NoError(t, notifier.Deliver(ctx))
+	require.Len(t, receiver.calls, 2)
+
+	var first, second WebhookEvent
+	require.NoError(t, json.Unmarshal(receiver.bodies[0], &first))
+	require.NoError(t, json.Unmarshal(receiver.bodies[1], &second))
+	require.Equal(t, first, second)
+	require.Equal(t, WebhookEventRelayed, second.Event)
+	require.Equal(t, "0x01", second.TxHash)
+	require.Equal(t, webhookEventId(rawTxEntryId, WebhookEventRelayed), second.Id)
+	require.NotEqual(t, webhookEventId(rawTxEntryId, WebhookEventCancelled), second.Id)
+
+	call := receiver.calls[1]
+	require.Equal(t, second.Id.String(), call.Header.Get(WebhookIdHeader))
+	require.Equal(t, "1700000005", call.Header.Get(WebhookTimestampHeader))
+	require.Equal(t, WebhookSignature("secret", "1700000005", receiver.bodies[1]), call.Header.Get(WebhookSignatureHeader))
+
+	// Delivered events are removed from the queue
+	now = now.Add(time.Hour)
+	deliveries, err := state.ClaimWebhookDeliveries(ctx, now, now, 10)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+}
+
+func TestWebhookNotifierDropsEvents(t *testing.T) {
+	defer func() { Now = time.Now }()
+	now := time.Unix(1700000000, 0)
+	Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	receiver := &webhookReceiver{statuses: make([]int, DefaultWebhookMaxAttempts)}
+	for i := range receiver.statuses {
+		receiver.statuses[i] = http.StatusServiceUnavailable
+	}
+	webhookServer := httptest.NewServer(receiver)
+	defer webhookServer.Close()
+	state := newTestMemState(t)
+	notifier := NewWebhookNotifier(log.New(), state, map[string]WebhookConfig{
+		"wallet": {URL: webhookServer.URL, Secret: "secret"},
+		"dapp":   {URL: webhookServer.URL, Secret: "secret"},
+	})
+
+	// Events are dropped after the max attempts
+	notifier.Notify(ctx, WebhookEvent{Event: WebhookEventFailed, OriginId: "wallet", TxHash: "0x01"})
+	for i := 0; i < DefaultWebhookMaxAttempts; i++ {
+		require.NoError(t, notifier.Deliver(ctx))
+		now = now.Add(DefaultWebhookMaxBackoff)
+	}
+	require.Len(t, receiver.calls, DefaultWebhookMaxAttempts)
+	deliveries, err := state.ClaimWebhookDeliveries(ctx, now, now, 10)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+
+	// Events of removed webhooks are dropped
+	notifier.Notify(ctx, WebhookEvent{Event: WebhookEventFailed, OriginId: "dapp", TxHash: "0x02"})
+	notifier.SetWebhooks(map[string]WebhookConfig{"wallet": {URL: webhookServer.URL, Secret: "secret"}})
+	require.NoError(t, notifier.Deliver(ctx))
+	require.Len(t, receiver.calls, DefaultWebhookMaxAttempts)
+	deliveries, err = state.ClaimWebhookDeliveries(ctx, now.Add(time.Hour), now, 10)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	require.Equal(t, 5*time.Second, webhookBackoff(1))
+	require.Equal(t, 10*time.Second, webhookBackoff(2))
+	require.Equal(t, 80*time.Second, webhookBackoff(5))
+	require.Equal(t, time.Hour, webhookBackoff(20))
+}
+
+func TestInclusionTrackerWebhooks(t *testing.T) {
+	setupMockTxApi()
+	defer func() { Now = time.Now }()
+	now := time.Unix(1700000000, 0)
+	Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	store := database.NewMemStore()
+	includedHash := common.HexToHash("0x01")
+	expiredHash := common.HexToHash("0x02")
+	for _, tx := range []struct {
+		hash       common.Hash
+		originId   string
+		receivedAt time.Time
+	}{
+		{includedHash, "wallet", now.Add(-30 * time.Second)},
+		{expiredHash, "wallet", now.Add(-15 * time.Minute)},
+		{common.HexToHash("0x03"), "", now.Add(-15 * time.Minute)},
+	} {
+		request := database.RequestEntry{Id: uuid.New(), ReceivedAt: tx.receivedAt}
+		entry := &database.EthSendRawTxEntry{Id: uuid.New(), RequestId: request.Id, TxHash: tx.hash.Hex(), OriginId: tx.originId, WasSentToRelay: true}
+		require.NoError(t, store.SaveRequestEntry(request))
+		require.NoError(t, store.SaveRawTxEntries([]*database.EthSendRawTxEntry{entry}))
+	}
+
+	state := newTestMemState(t)
+	notifier := NewWebhookNotifier(log.New(), state, map[string]WebhookConfig{"wallet": {URL: "https://example.com", Secret: "secret"}})
+	fetcher := &mockReceiptFetcher{
+		blockTime: now,
+		receipts: map[common.Hash]*ethtypes.Receipt{
+			includedHash: {Status: ethtypes.ReceiptStatusFailed, BlockNumber: big.NewInt(100)},
+		},
+	}
+	tracker := NewInclusionTracker(log.New(), store, "", ProtectTxApiHost, fetcher, 10*time.Minute, time.Second, 100, notifier)
+	require.NoError(t, tracker.Poll(ctx))
+
+	deliveries, err := state.ClaimWebhookDeliveries(ctx, now, now, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	events := map[string]WebhookEvent{}
+	for _, rawDelivery := range deliveries {
+		var delivery webhookDelivery
+		require.NoError(t, json.Unmarshal([]byte(rawDelivery), &delivery))
+		events[delivery.Event.TxHash] = delivery.Event
+	}
+	require.Equal(t, WebhookEventIncluded, events[includedHash.Hex()].Event)
+	require.Equal(t, "reverted", events[includedHash.Hex()].InclusionStatus)
+	require.Equal(t, int64(100), events[includedHash.Hex()].BlockNumber)
+	require.Equal(t, WebhookEventFailed, events[expiredHash.Hex()].Event)
+	require.Equal(t, "expired", events[expiredHash.Hex()].InclusionStatus)
+}
+
+// racingInclusionStore saves an outcome of every claimed tx before the tracker does, like the tracker of another replica
+type racingInclusionStore struct {
+	database.InclusionStore
+}
+
+func (s racingInclusionStore) ClaimUnresolvedRelayedTxs(ctx context.Context, chain string, since, now, leaseUntil time.Time, limit int) ([]database.RelayedTx, error) {
+	txs, err := s.InclusionStore.ClaimUnresolvedRelayedTxs(ctx, chain, since, now, leaseUntil, limit)
+	for _, tx := range txs {
+		_, err = s.InclusionStore.SaveInclusionOutcomes(ctx, []database.InclusionOutcome{{RawTxEntryId: tx.RawTxEntryId, Status: database.InclusionStatusExpired}})
+	}
+	return txs, err
+}
+
+func TestInclusionTrackerWebhooksOnlyForInsertedOutcomes(t *testing.T) {
+	setupMockTxApi()
+	defer func() { Now = time.Now }()
+	now := time.Unix(1700000000, 0)
+	Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	store := database.NewMemStore()
+	request := database.RequestEntry{Id: uuid.New(), ReceivedAt: now.Add(-15 * time.Minute)}
+	entry := &database.EthSendRawTxEntry{Id: uuid.New(), RequestId: request.Id, TxHash: common.HexToHash("0x01").Hex(), OriginId: "wallet", WasSentToRelay: true}
+	require.NoError(t, store.SaveRequestEntry(request))
+	require.NoError(t, store.SaveRawTxEntries([]*database.EthSendRawTxEntry{entry}))
+
+	state := newTestMemState(t)
+	notifier := NewWebhookNotifier(log.New(), state, map[string]WebhookConfig{"wallet": {URL: "https://example.com", Secret: "secret"}})
+	fetcher := &mockReceiptFetcher{receipts: map[common.Hash]*ethtypes.Receipt{}}
+	tracker := NewInclusionTracker(log.New(), racingInclusionStore{store}, "", ProtectTxApiHost, fetcher, 10*time.Minute, time.Second, 100, notifier)
+	require.NoError(t, tracker.Poll(ctx))
+
+	deliveries, err := state.ClaimWebhookDeliveries(ctx, now, now, 10)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+	require.Len(t, store.Inclusions, 1)
+}
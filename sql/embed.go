@@ -0,0 +1,9 @@
+// Package sql embeds the database schema migrations
+package sql
+
+import "embed"
+
+// Psql contains the numbered Postgres migrations, e.g. psql/001_initial.schema.up.sql
+//
+//go:embed psql/*.sql
+var Psql embed.FS
@@ -0,0 +1 @@
+DROP TABLE rpc_endpoint_tx_inclusions;
@@ -0,0 +1,11 @@
+CREATE TABLE rpc_endpoint_tx_inclusions(
+    raw_tx_entry_id uuid not null unique primary key,
+    inserted_at timestamp with time zone not null default now(),
+    tx_hash varchar(66) not null,
+    status varchar(20) not null,
+    block_number bigint,
+    gas_used bigint,
+    effective_gas_price numeric(78, 0),
+    fee_wei numeric(78, 0),
+    time_to_inclusion_ms bigint
+);
@@ -0,0 +1 @@
+ALTER TABLE rpc_endpoint_requests ADD COLUMN cancel_reason varchar(32) DEFAULT '';
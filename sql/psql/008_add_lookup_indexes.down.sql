@@ -0,0 +1,4 @@
+-- migrate:no-transaction
+DROP INDEX CONCURRENTLY IF EXISTS rpc_endpoint_requests_received_at_idx;
+DROP INDEX CONCURRENTLY IF EXISTS rpc_endpoint_eth_send_raw_txs_tx_from_idx;
+DROP INDEX CONCURRENTLY IF EXISTS rpc_endpoint_eth_send_raw_txs_tx_hash_idx;
@@ -0,0 +1,6 @@
+-- migrate:no-transaction
+-- The indexes are built without blocking writes. Rows saved before tx_hash and tx_from were lowercase are
+-- lowercased out of band by sql/scripts/lowercase_raw_tx_lookups.sql.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS rpc_endpoint_eth_send_raw_txs_tx_hash_idx ON rpc_endpoint_eth_send_raw_txs (tx_hash);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS rpc_endpoint_eth_send_raw_txs_tx_from_idx ON rpc_endpoint_eth_send_raw_txs (tx_from);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS rpc_endpoint_requests_received_at_idx ON rpc_endpoint_requests (received_at);
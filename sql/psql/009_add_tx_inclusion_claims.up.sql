@@ -0,0 +1,5 @@
+CREATE TABLE rpc_endpoint_tx_inclusion_claims(
+    raw_tx_entry_id uuid not null unique primary key,
+    claimed_until timestamp with time zone not null
+);
+CREATE INDEX IF NOT EXISTS rpc_endpoint_tx_inclusion_claims_claimed_until_idx ON rpc_endpoint_tx_inclusion_claims (claimed_until);
@@ -0,0 +1,11 @@
+ALTER TABLE rpc_endpoint_eth_send_raw_txs DROP COLUMN allow_tee;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs DROP COLUMN use_mempool;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs DROP COLUMN auction_timeout;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs DROP COLUMN block_range;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs DROP COLUMN refund;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs DROP COLUMN builders;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs DROP COLUMN hints;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs DROP COLUMN preset_applied;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs DROP COLUMN origin_id;
+
+ALTER TABLE rpc_endpoint_requests DROP COLUMN ip_hash;
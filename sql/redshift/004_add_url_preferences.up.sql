@@ -0,0 +1,11 @@
+ALTER TABLE rpc_endpoint_requests ADD COLUMN ip_hash varchar(64);
+
+ALTER TABLE rpc_endpoint_eth_send_raw_txs ADD COLUMN origin_id varchar(255);
+ALTER TABLE rpc_endpoint_eth_send_raw_txs ADD COLUMN preset_applied boolean DEFAULT FALSE;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs ADD COLUMN hints varchar(max);
+ALTER TABLE rpc_endpoint_eth_send_raw_txs ADD COLUMN builders varchar(max);
+ALTER TABLE rpc_endpoint_eth_send_raw_txs ADD COLUMN refund varchar(max);
+ALTER TABLE rpc_endpoint_eth_send_raw_txs ADD COLUMN block_range integer;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs ADD COLUMN auction_timeout bigint;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs ADD COLUMN use_mempool boolean DEFAULT FALSE;
+ALTER TABLE rpc_endpoint_eth_send_raw_txs ADD COLUMN allow_tee boolean DEFAULT FALSE;
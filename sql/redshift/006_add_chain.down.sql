@@ -0,0 +1 @@
+ALTER TABLE rpc_endpoint_requests DROP COLUMN chain;
@@ -0,0 +1 @@
+ALTER TABLE rpc_endpoint_requests ADD COLUMN chain varchar(64) DEFAULT '';
@@ -0,0 +1 @@
+-- tx_hash and tx_from stay lowercase, Redshift has no indexes to drop
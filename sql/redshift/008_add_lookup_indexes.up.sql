@@ -0,0 +1,3 @@
+-- Redshift has no indexes, the lookups only need the lowercase values
+UPDATE rpc_endpoint_eth_send_raw_txs SET tx_hash = lower(tx_hash) WHERE tx_hash <> lower(tx_hash);
+UPDATE rpc_endpoint_eth_send_raw_txs SET tx_from = lower(tx_from) WHERE tx_from <> lower(tx_from);
@@ -0,0 +1,20 @@
+-- Lowercases tx_hash and tx_from of raw tx entries saved before they were stored lowercase, so the lookups by
+-- tx hash and sender find them. It commits every batch of 10000 rows, so it doesn't block inserts for long, and
+-- can be stopped and run again. Run it with psql outside of a transaction, e.g. psql "$POSTGRES_DSN" -f <this file>
+DO $$
+DECLARE
+    last_id    uuid := '00000000-0000-0000-0000-000000000000';
+    batch_last uuid;
+BEGIN
+    LOOP
+        SELECT max(id) INTO batch_last FROM (
+            SELECT id FROM rpc_endpoint_eth_send_raw_txs WHERE id > last_id ORDER BY id LIMIT 10000
+        ) batch;
+        EXIT WHEN batch_last IS NULL;
+
+        UPDATE rpc_endpoint_eth_send_raw_txs SET tx_hash = lower(tx_hash), tx_from = lower(tx_from)
+        WHERE id > last_id AND id <= batch_last AND (tx_hash <> lower(tx_hash) OR tx_from <> lower(tx_from));
+        last_id := batch_last;
+        COMMIT;
+    END LOOP;
+END $$;
@@ -5,6 +5,7 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
@@ -12,11 +13,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/flashbots/rpc-endpoint/database"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/flashbots/rpc-endpoint/server"
@@ -46,6 +49,21 @@ func init() {
 
 var bundleJsonApi *httptest.Server
 
+var rpcState server.StateStore
+var rpcServer *server.RpcEndPointServer
+var auditSink server.AuditSink                        // used by the next testServerSetup
+var configurationWatcher *server.ConfigurationWatcher // used by the next testServerSetup
+
+// waitForRawTxEntries waits until n raw tx entries were saved in the background, before the store is inspected
+func waitForRawTxEntries(t *testing.T, store database.Reader, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		entries, err := store.GetRawTxEntries(context.Background(), database.RawTxEntryFilter{})
+		require.NoError(t, err)
+		return len(entries) >= n
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
 // Setup RPC endpoint and mock backend servers
 func testServerSetupWithMockStore() {
 	db := database.NewMockStore()
@@ -57,6 +75,10 @@ func testServerSetup(db database.Store) {
 	if err != nil {
 		panic(err)
 	}
+	rpcState, err = server.NewRedisState(redisServer.Addr(), server.DefaultStateOptions)
+	if err != nil {
+		panic(err)
+	}
 
 	// Create a fresh mock backend server (covers for both eth node and relay)
 	rpcBackendServer := httptest.NewServer(http.HandlerFunc(testutils.RpcBackendHandler))
@@ -68,16 +90,18 @@ func testServerSetup(db database.Store) {
 	server.ProtectTxApiHost = txApiServer.URL
 
 	// Create a fresh RPC endpoint server
-	rpcServer, err := server.NewRpcEndPointServer(server.Configuration{
-		DB:                  db,
-		Logger:              log.New("testlogger"),
-		ProxyTimeoutSeconds: 10,
-		ProxyUrl:            RpcBackendServerUrl,
-		RedisUrl:            redisServer.Addr(),
-		RelaySigningKey:     relaySigningKey,
-		RelayUrl:            RpcBackendServerUrl,
-		Version:             "test",
-		DefaultMempoolRPC:   RpcBackendServerUrl,
+	rpcServer, err = server.NewRpcEndPointServer(server.Configuration{
+		DB:                   db,
+		Logger:               log.New("testlogger"),
+		ProxyTimeoutSeconds:  10,
+		ProxyUrl:             RpcBackendServerUrl,
+		State:                rpcState,
+		RelaySigningKey:      relaySigningKey,
+		RelayUrl:             RpcBackendServerUrl,
+		Version:              "test",
+		DefaultMempoolRPC:    RpcBackendServerUrl,
+		AuditSink:            auditSink,
+		ConfigurationWatcher: configurationWatcher,
 	})
 	if err != nil {
 		panic(err)
@@ -160,11 +184,24 @@ func TestNetVersionIntercept(t *testing.T) {
 	res, err := testutils.SendRpcAndParseResponseTo(RpcBackendServerUrl, req)
 	require.Nil(t, err, err)
 	json.Unmarshal(res.Result, &rpcResult)
-	require.Equal(t, "3", rpcResult, "net_version from backend")
+	require.Equal(t, "1", rpcResult, "net_version from backend")
 
 	rpcResult = testutils.SendRpcAndParseResponseOrFailNowString(t, req)
 	require.Nil(t, res.Error)
-	require.Equal(t, "3", rpcResult, "net_version intercept")
+	require.Equal(t, "1", rpcResult, "net_version intercept")
+}
+
+// Methods which would send a tx to the public mempool are never proxied
+func TestSendTransactionRejected(t *testing.T) {
+	testServerSetupWithMockStore()
+
+	req := types.NewJsonRpcRequest(1, "eth_sendTransaction", []interface{}{map[string]string{"from": testutils.TestTx_BundleFailedTooManyTimes_From}})
+	res, err := testutils.SendRpcAndParseResponseTo(testutils.RpcEndpointUrl, req)
+	require.Nil(t, err, err)
+	require.NotNil(t, res.Error)
+	require.Equal(t, types.JsonRpcMethodNotFound, res.Error.Code)
+	require.Equal(t, "the method eth_sendTransaction does not exist/is not available", res.Error.Message)
+	require.Equal(t, "net_version", testutils.MockBackendLastJsonRpcRequest.Method, "not proxied")
 }
 
 // Ensure bundle response is the tx hash, not the bundle id
@@ -264,6 +301,7 @@ func TestMetamaskFix(t *testing.T) {
 }
 
 func TestRelayTx(t *testing.T) {
+	ctx := context.Background()
 	testServerSetupWithMockStore()
 
 	// sendRawTransaction adds tx to MM cache entry, to be used at later eth_getTransactionReceipt call
@@ -294,7 +332,7 @@ func TestRelayTx(t *testing.T) {
 	require.Equal(t, timeStampFirstRequest, testutils.MockBackendLastJsonRpcRequestTimestamp)
 
 	// Ensure nonce is saved to redis
-	nonce, found, err := server.RState.GetSenderMaxNonce(testutils.TestTx_BundleFailedTooManyTimes_From)
+	nonce, found, err := rpcState.GetSenderMaxNonce(ctx, testutils.TestTx_BundleFailedTooManyTimes_From)
 	require.Nil(t, err, err)
 	require.True(t, found)
 	require.Equal(t, uint64(30), nonce)
@@ -330,6 +368,7 @@ func TestRelayTxWithAuctionPreference(t *testing.T) {
 		require.Equal(t, expectedHints[i], strHint)
 	}
 
+	waitForRawTxEntries(t, memStore, 1)
 	require.Equal(t, 1, len(memStore.EthSendRawTxs))
 }
 
@@ -409,6 +448,96 @@ func TestRelayTxWithWrongNonce(t *testing.T) {
 	require.Equal(t, "invalid nonce", resp1.Error.Message)
 }
 
+type recordingAuditSink struct {
+	mu      sync.Mutex
+	records []*server.DecisionRecord
+}
+
+func (s *recordingAuditSink) Emit(record *server.DecisionRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+}
+
+func (s *recordingAuditSink) Close(ctx context.Context) error { return nil }
+
+func decisionChecks(record *server.DecisionRecord) map[string]string {
+	checks := make(map[string]string)
+	for _, check := range record.Checks {
+		checks[check.Name] = check.Result
+	}
+	return checks
+}
+
+func TestAuditDecisionRecords(t *testing.T) {
+	sink := &recordingAuditSink{}
+	auditSink = sink
+	defer func() { auditSink = nil }()
+	testServerSetupWithMockStore()
+
+	req := types.NewJsonRpcRequest(1, "eth_sendRawTransaction", []interface{}{testutils.TestTx_BundleFailedTooManyTimes_RawTx})
+	testutils.SendRpcAndParseResponseOrFailNow(t, req)
+	testutils.SendRpcAndParseResponseOrFailNow(t, req)
+	require.Eventually(t, func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		return len(sink.records) == 2
+	}, 5*time.Second, 5*time.Millisecond)
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	relayed, blocked := sink.records[0], sink.records[1]
+	require.Equal(t, "relayed", relayed.Outcome)
+	require.Equal(t, testutils.TestTx_BundleFailedTooManyTimes_Hash, relayed.TxHash)
+	require.Equal(t, map[string]string{
+		"params": server.DecisionPass, "chain_id": server.DecisionPass, "nonce_limit": server.DecisionPass,
+		"blocked_tx_hash": server.DecisionPass, "ofac": server.DecisionPass, "gas_tip": server.DecisionPass,
+		"resend": server.DecisionPass, "nonce_range": server.DecisionPass, "relay": server.DecisionPass,
+	}, decisionChecks(relayed))
+
+	// The second tx is blocked as a resend of a pending tx
+	require.Equal(t, "blocked", blocked.Outcome)
+	require.Equal(t, server.DecisionStop, decisionChecks(blocked)["resend"])
+	require.NotContains(t, decisionChecks(blocked), "relay")
+}
+
+func TestWebhookEvents(t *testing.T) {
+	var err error
+	configurationWatcher, err = server.NewConfigurationWatcher(server.CustomersConfig{
+		Webhooks: map[string]server.WebhookConfig{"wallet": {URL: "https://example.com/hook", Secret: "secret"}},
+	})
+	require.NoError(t, err)
+	defer func() { configurationWatcher = nil }()
+	testServerSetupWithMockStore()
+
+	// The initial tx is relayed, and then cancelled at the relay
+	for _, rawTx := range []string{testutils.TestTx_CancelAtRelay_Initial_RawTx, testutils.TestTx_CancelAtRelay_Cancel_RawTx} {
+		req := types.NewJsonRpcRequest(1, "eth_sendRawTransaction", []interface{}{rawTx})
+		res := testutils.SendRpcWithAuctionPreferenceAndParseResponse(t, req, "/?originId=wallet")
+		require.Nil(t, res.Error)
+	}
+	// Txs of origins without a webhook are not queued
+	req := types.NewJsonRpcRequest(1, "eth_sendRawTransaction", []interface{}{testutils.TestTx_BundleFailedTooManyTimes_RawTx})
+	testutils.SendRpcAndParseResponseOrFailNow(t, req)
+
+	now := time.Now()
+	deliveries, err := rpcState.ClaimWebhookDeliveries(context.Background(), now, now, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	events := make(map[string]server.WebhookEvent)
+	for _, rawDelivery := range deliveries {
+		var delivery struct {
+			Event server.WebhookEvent `json:"event"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(rawDelivery), &delivery))
+		require.Equal(t, "wallet", delivery.Event.OriginId)
+		events[delivery.Event.Event] = delivery.Event
+	}
+	require.Contains(t, events, server.WebhookEventRelayed)
+	require.Equal(t, events[server.WebhookEventRelayed].TxHash, events[server.WebhookEventCancelled].TxHash)
+	require.Equal(t, testutils.TestTx_CancelAtRelay_Cancel_Hash, events[server.WebhookEventCancelled].CancelTxHash)
+}
+
 // Test batch request with multiple eth raw transaction
 func TestBatch_eth_sendRawTransaction(t *testing.T) {
 	t.Skip()
@@ -556,6 +685,7 @@ func TestBatch_Validate_eth_sendRawTransaction_Error(t *testing.T) {
 
 // Whitehat Tests
 func TestWhitehatBundleCollection(t *testing.T) {
+	ctx := context.Background()
 	testServerSetupWithMockStore()
 
 	bundleId := "123"
@@ -570,7 +700,7 @@ func TestWhitehatBundleCollection(t *testing.T) {
 	// Last request should be network version (executed on start)
 	require.Equal(t, &types.JsonRpcRequest{Id: float64(1), Method: "net_version", Params: []interface{}{}, Version: "2.0"}, testutils.MockBackendLastJsonRpcRequest)
 	// Check redis
-	txs, err := server.RState.GetWhitehatBundleTx(bundleId)
+	txs, err := rpcState.GetWhitehatBundleTx(ctx, bundleId)
 	require.Nil(t, err, err)
 	require.Equal(t, 1, len(txs))
 
@@ -580,7 +710,7 @@ func TestWhitehatBundleCollection(t *testing.T) {
 	require.Nil(t, resp.Error, resp.Error)
 
 	// Check redis (#2)
-	txs, err = server.RState.GetWhitehatBundleTx(bundleId)
+	txs, err = rpcState.GetWhitehatBundleTx(ctx, bundleId)
 	require.Nil(t, err, err)
 	require.Equal(t, 1, len(txs))
 
@@ -649,6 +779,7 @@ func Test_StoreRequests(t *testing.T) {
 	r1 := testutils.SendRpcAndParseResponseOrFailNowAllowRpcError(t, reqSendRawTransaction2)
 	require.Nil(t, r1.Error)
 
+	waitForRawTxEntries(t, memStore, 2)
 	require.Equal(t, 2, len(memStore.Requests))
 	require.Equal(t, 2, len(memStore.EthSendRawTxs))
 	for _, txs := range memStore.EthSendRawTxs {
@@ -690,6 +821,7 @@ func Test_StoreBatchRequests(t *testing.T) {
 	res, err := testutils.SendBatchRpcAndParseResponse(batch)
 	require.Nil(t, err, err)
 	assert.Equal(t, len(res), 5)
+	waitForRawTxEntries(t, memStore, 1)
 	require.Equal(t, 1, len(memStore.Requests))
 	require.Equal(t, 1, len(memStore.EthSendRawTxs))
 }
@@ -714,6 +846,7 @@ func Test_StoreValidateTxs(t *testing.T) {
 	res, err := testutils.SendBatchRpcAndParseResponse(batch)
 	require.Nil(t, err, err)
 	assert.Equal(t, len(res), 2)
+	waitForRawTxEntries(t, memStore, 1)
 	require.Equal(t, 1, len(memStore.Requests))
 	require.Equal(t, 1, len(memStore.EthSendRawTxs))
 
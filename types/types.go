@@ -18,6 +18,13 @@ const (
 	JsonRpcInternalError  = -32603
 )
 
+// Server errors of the endpoint, in the range reserved for implementation-defined errors
+const (
+	JsonRpcWrongChainId  = -32010 // tx is signed for another chain
+	JsonRpcUnprotectedTx = -32011 // tx has no chain id (pre EIP-155), rejected by policy
+	JsonRpcDraining      = -32012 // the server is draining and doesn't accept txs, retry on another instance or later
+)
+
 type JsonRpcRequest struct {
 	Id      interface{}   `json:"id"`
 	Method  string        `json:"method"`
@@ -77,6 +84,38 @@ type HealthResponse struct {
 	Now       time.Time `json:"time"`
 	StartTime time.Time `json:"startTime"`
 	Version   string    `json:"version"`
+	DrainStatus
+}
+
+// DrainStatus tells whether the server is draining, and why
+type DrainStatus struct {
+	Draining    bool       `json:"draining"`
+	DrainReason string     `json:"drainReason,omitempty"`
+	DrainUntil  *time.Time `json:"drainUntil,omitempty"` // the drain ends automatically at this time, if set
+}
+
+type ReadinessStatus string
+
+const (
+	ReadinessOK       ReadinessStatus = "ok"
+	ReadinessDegraded ReadinessStatus = "degraded"
+	ReadinessFail     ReadinessStatus = "fail"
+	ReadinessDisabled ReadinessStatus = "disabled"
+)
+
+type ComponentStatus struct {
+	Status    ReadinessStatus `json:"status"`
+	Critical  bool            `json:"critical"`
+	LatencyMs int64           `json:"latencyMs"`
+	Message   string          `json:"message,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status     ReadinessStatus            `json:"status"`
+	Now        time.Time                  `json:"time"`
+	Version    string                     `json:"version"`
+	Draining   bool                       `json:"draining"`
+	Components map[string]ComponentStatus `json:"components"`
 }
 
 type TransactionReceipt struct {